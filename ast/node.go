@@ -16,6 +16,17 @@ const (
 	RforkFlags = "umnips"
 )
 
+const (
+	// RedirOutput is the output redirection: >[fd] location
+	RedirOutput RedirKind = iota
+	// RedirInput is the input redirection: <[fd] location
+	RedirInput
+	// RedirHereStr feeds the value of an expression to stdin: <<< value
+	RedirHereStr
+	// RedirAppend is the output redirection in append mode: >>[fd] location
+	RedirAppend
+)
+
 type (
 	// Node represents nodes in the grammar
 	Node interface {
@@ -64,6 +75,7 @@ type (
 		egalitarian
 
 		Path *StringExpr // Import path
+		Name string      // Module name of qualified imports
 	}
 
 	// A SetenvNode represents the node for a "setenv" keyword.
@@ -117,7 +129,11 @@ type (
 		args   []Expr
 		redirs []*RedirectNode
 
-		multi bool
+		// file descriptors connected to the next command of a pipe
+		pipeFds []int
+
+		multi      bool
+		background bool
 	}
 
 	// PipeNode represents the node for a command pipeline.
@@ -126,8 +142,9 @@ type (
 		token.FileInfo
 		egalitarian
 
-		cmds  []*CommandNode
-		multi bool
+		cmds       []*CommandNode
+		multi      bool
+		background bool
 	}
 
 	// StringExpr is a string argument
@@ -159,6 +176,50 @@ type (
 		IsVariadic bool
 	}
 
+	// MapExpr is a map literal
+	MapExpr struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		Keys   []Expr
+		Values []Expr
+	}
+
+	// BinaryExpr is an expression with an operator between two
+	// operands (eg.: $a == "b", $a < $b, <expr> && <expr>)
+	BinaryExpr struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		Lvalue Expr
+		Op     string
+		Rvalue Expr
+	}
+
+	// UnaryExpr is an expression with an operator applied to a
+	// single operand (eg.: !<expr>)
+	UnaryExpr struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		Op    string
+		Value Expr
+	}
+
+	// SliceExpr is a range used to index lists and strings
+	// (eg.: 1:3 in $l[1:3]). Start and End are nil if omitted.
+	SliceExpr struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		Start Expr
+		End   Expr
+	}
+
 	// ConcatExpr is a concatenation of arguments
 	ConcatExpr struct {
 		NodeType
@@ -189,12 +250,16 @@ type (
 		IsVariadic bool
 	}
 
-	// RedirectNode represents the output redirection part of a command
+	// RedirKind is the kind of the redirection operator
+	RedirKind int
+
+	// RedirectNode represents the redirection part of a command
 	RedirectNode struct {
 		NodeType
 		token.FileInfo
 		egalitarian
 
+		kind     RedirKind
 		rmap     RedirMap
 		location Expr
 	}
@@ -230,15 +295,38 @@ type (
 		token.FileInfo
 		egalitarian
 
-		lvalue Expr
-		rvalue Expr
-		op     string
+		cond   Expr
 		elseIf bool
 
 		ifTree   *Tree
 		elseTree *Tree
 	}
 
+	// A SwitchNode represents the "switch" keyword. It runs the
+	// block of the first case matching the value or the default
+	// block if none matches.
+	SwitchNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		value       Expr
+		cases       []*CaseNode
+		defaultTree *Tree
+	}
+
+	// A CaseNode represents a case of a switch statement. The case
+	// matches if any of its patterns matches the value.
+	CaseNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		patterns []Expr
+		glob     bool // patterns are glob patterns
+		tree     *Tree
+	}
+
 	// VarAssignDeclNode is a "var" declaration to assign values
 	VarAssignDeclNode struct {
 		NodeType
@@ -265,6 +353,8 @@ type (
 
 		Name       string
 		IsVariadic bool
+		Default    Expr   // value of optional arguments
+		ArgType    string // type annotation, eg.: str, list
 	}
 
 	// A FnDeclNode represents a function declaration.
@@ -273,9 +363,10 @@ type (
 		token.FileInfo
 		egalitarian
 
-		name string
-		args []*FnArgNode
-		tree *Tree
+		name        string
+		args        []*FnArgNode
+		returnTypes []string
+		tree        *Tree
 	}
 
 	// A FnInvNode represents a function invocation statement.
@@ -284,8 +375,18 @@ type (
 		token.FileInfo
 		egalitarian
 
-		name string
-		args []Expr
+		name  string
+		args  []Expr
+		named []*FnNamedArg
+	}
+
+	// FnNamedArg is an argument passed by name in a function
+	// invocation, as in: deploy("prod", region = "eu-west-1")
+	FnNamedArg struct {
+		token.FileInfo
+
+		Name  string
+		Value Expr
 	}
 
 	// A ReturnNode represents the "return" keyword.
@@ -297,6 +398,31 @@ type (
 		Returns []Expr
 	}
 
+	// A DeferNode represents the "defer" keyword. It schedules the
+	// command or function invocation to run when the function
+	// returns.
+	DeferNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		stmt Node
+	}
+
+	// A BreakNode represents the "break" keyword.
+	BreakNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+	}
+
+	// A ContinueNode represents the "continue" keyword.
+	ContinueNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+	}
+
 	// A BindFnNode represents the "bindfn" keyword.
 	BindFnNode struct {
 		NodeType
@@ -307,15 +433,48 @@ type (
 		cmdname string
 	}
 
+	// A ShowEnvNode represents the "showenv" keyword.
+	ShowEnvNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+	}
+
+	// A DumpNode represents the "dump" keyword. It writes the
+	// variables, functions and binds of the session to stdout or to
+	// a file.
+	DumpNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		filename Expr // nil dumps to stdout
+	}
+
+	// A SpawnNode represents the "spawn" keyword. It runs the
+	// function invocation as a new process.
+	SpawnNode struct {
+		NodeType
+		token.FileInfo
+		egalitarian
+
+		fnDecl *FnDeclNode // anonymous function or nil
+		fnInv  *FnInvNode
+	}
+
 	// A ForNode represents the "for" keyword.
 	ForNode struct {
 		NodeType
 		token.FileInfo
 		egalitarian
 
-		identifier string
-		inExpr     Expr
-		tree       *Tree
+		identifier      string
+		valueIdentifier string
+		pairs           bool // for (key, value) in <list of pairs>
+		inExpr          Expr
+		inCmd           Node // command streamed by "for line in <= cmd"
+		cond            Expr
+		tree            *Tree
 	}
 )
 
@@ -354,6 +513,9 @@ const (
 	// NodeFnInv is the type for function invocation
 	NodeFnInv
 
+	// NodeSpawn is the type for spawn statement
+	NodeSpawn
+
 	execEnd
 
 	expressionBegin
@@ -376,6 +538,18 @@ const (
 	// NodeConcatExpr is the type of concatenation expressions.
 	NodeConcatExpr
 
+	// NodeMapExpr is the type of map expression.
+	NodeMapExpr
+
+	// NodeBinaryExpr is the type of binary expressions.
+	NodeBinaryExpr
+
+	// NodeUnaryExpr is the type of unary expressions.
+	NodeUnaryExpr
+
+	// NodeSliceExpr is the type of slice range expressions.
+	NodeSliceExpr
+
 	expressionEnd
 
 	// NodeString are nodes for argument strings
@@ -390,6 +564,12 @@ const (
 	// NodeIf is the type for if statements
 	NodeIf
 
+	// NodeSwitch is the type for switch statements
+	NodeSwitch
+
+	// NodeCase is the type for the cases of switch statements
+	NodeCase
+
 	// NodeComment are nodes for comment
 	NodeComment
 
@@ -407,11 +587,26 @@ const (
 	// NodeReturn is the type for return statement
 	NodeReturn
 
+	// NodeDefer is the type for defer statement
+	NodeDefer
+
 	// NodeBindFn is the type for bindfn statements
 	NodeBindFn
 
+	// NodeShowEnv is the type for showenv statement
+	NodeShowEnv
+
+	// NodeDump is the type for dump statement
+	NodeDump
+
 	// NodeFor is the type for "for" statements
 	NodeFor
+
+	// NodeBreak is the type for break statement
+	NodeBreak
+
+	// NodeContinue is the type for continue statement
+	NodeContinue
 )
 
 var (
@@ -439,6 +634,18 @@ func (t NodeType) IsExecutable() bool {
 	return t > execBegin && t < execEnd
 }
 
+// IsBackground tells if n is a command or pipe running in background.
+func IsBackground(n Node) bool {
+	switch n := n.(type) {
+	case *CommandNode:
+		return n.IsBackground()
+	case *PipeNode:
+		return n.IsBackground()
+	}
+
+	return false
+}
+
 func (e egalitarian) equal(node, other Node) bool {
 	if node == other {
 		return true
@@ -519,6 +726,11 @@ func (n *ImportNode) IsEqual(other Node) bool {
 		return false
 	}
 
+	if n.Name != o.Name {
+		debug("Import module name differs: '%s' != '%s'", n.Name, o.Name)
+		return false
+	}
+
 	if n.Path != o.Path {
 		if n.Path != nil {
 			return n.Path.IsEqual(o.Path)
@@ -754,6 +966,12 @@ func NewCommandNode(info token.FileInfo, name string, multiline bool) *CommandNo
 func (n *CommandNode) IsMulti() bool   { return n.multi }
 func (n *CommandNode) SetMulti(b bool) { n.multi = b }
 
+// IsBackground tells if the command must run in background. Eg.: cmd &
+func (n *CommandNode) IsBackground() bool { return n.background }
+
+// SetBackground sets if the command must run in background.
+func (n *CommandNode) SetBackground(b bool) { n.background = b }
+
 // AddArg adds a new argument to the command
 func (n *CommandNode) AddArg(a Expr) {
 	n.args = append(n.args, a)
@@ -775,6 +993,14 @@ func (n *CommandNode) AddRedirect(redir *RedirectNode) {
 // Redirects return the list of redirect maps of the command.
 func (n *CommandNode) Redirects() []*RedirectNode { return n.redirs }
 
+// SetPipeFds sets the file descriptors of the command that are
+// piped to the next command of the pipeline. Eg.: |[1,2]
+func (n *CommandNode) SetPipeFds(fds []int) { n.pipeFds = fds }
+
+// PipeFds returns the file descriptors piped to the next command.
+// An empty list means only stdout.
+func (n *CommandNode) PipeFds() []int { return n.pipeFds }
+
 // Name returns the program name
 func (n *CommandNode) Name() string { return n.name }
 
@@ -796,6 +1022,11 @@ func (n *CommandNode) IsEqual(other Node) bool {
 		return false
 	}
 
+	if n.background != o.background {
+		debug("Command background differs.")
+		return false
+	}
+
 	if len(n.args) != len(o.args) {
 		debug("Command argument length differs: %d (%+v) != %d (%+v)",
 			len(n.args), n.args, len(o.args), o.args)
@@ -827,6 +1058,20 @@ func (n *CommandNode) IsEqual(other Node) bool {
 		}
 	}
 
+	if len(n.pipeFds) != len(o.pipeFds) {
+		debug("Number of piped fds differs. %d != %d", len(n.pipeFds),
+			len(o.pipeFds))
+		return false
+	}
+
+	for i := 0; i < len(n.pipeFds); i++ {
+		if n.pipeFds[i] != o.pipeFds[i] {
+			debug("Piped fd differs. %d != %d", n.pipeFds[i],
+				o.pipeFds[i])
+			return false
+		}
+	}
+
 	return n.name == o.name
 }
 
@@ -843,6 +1088,12 @@ func NewPipeNode(info token.FileInfo, multi bool) *PipeNode {
 func (n *PipeNode) IsMulti() bool   { return n.multi }
 func (n *PipeNode) SetMulti(b bool) { n.multi = b }
 
+// IsBackground tells if the pipeline must run in background.
+func (n *PipeNode) IsBackground() bool { return n.background }
+
+// SetBackground sets if the pipeline must run in background.
+func (n *PipeNode) SetBackground(b bool) { n.background = b }
+
 // AddCmd add another command to end of the pipeline
 func (n *PipeNode) AddCmd(c *CommandNode) {
 	n.cmds = append(n.cmds, c)
@@ -866,6 +1117,11 @@ func (n *PipeNode) IsEqual(other Node) bool {
 		return false
 	}
 
+	if n.background != o.background {
+		debug("Pipe background differs.")
+		return false
+	}
+
 	if len(n.cmds) != len(o.cmds) {
 		debug("Number of pipe commands differ: %d != %d",
 			len(n.cmds), len(o.cmds))
@@ -902,6 +1158,18 @@ func (r *RedirectNode) SetMap(lfd int, rfd int) {
 	r.rmap.rfd = rfd
 }
 
+// SetKind sets the kind of redirection (output, append, input or
+// here-string).
+func (r *RedirectNode) SetKind(kind RedirKind) { r.kind = kind }
+
+// Kind returns the kind of redirection.
+func (r *RedirectNode) Kind() RedirKind { return r.kind }
+
+// IsInput returns true if the redirection feeds the stdin.
+func (r *RedirectNode) IsInput() bool {
+	return r.kind == RedirInput || r.kind == RedirHereStr
+}
+
 // LeftFD return the lhs of the redirection map.
 func (r *RedirectNode) LeftFD() int { return r.rmap.lfd }
 
@@ -926,7 +1194,8 @@ func (r *RedirectNode) IsEqual(other Node) bool {
 		return false
 	}
 
-	if r.rmap.lfd != o.rmap.lfd ||
+	if r.kind != o.kind ||
+		r.rmap.lfd != o.rmap.lfd ||
 		r.rmap.rfd != o.rmap.rfd {
 		return false
 	}
@@ -1030,30 +1299,66 @@ func NewIfNode(info token.FileInfo) *IfNode {
 
 // Lvalue returns the lefthand part of condition
 func (n *IfNode) Lvalue() Expr {
-	return n.lvalue
+	if cond, ok := n.cond.(*BinaryExpr); ok {
+		return cond.Lvalue
+	}
+
+	return nil
 }
 
 // Rvalue returns the righthand side of condition
 func (n *IfNode) Rvalue() Expr {
-	return n.rvalue
+	if cond, ok := n.cond.(*BinaryExpr); ok {
+		return cond.Rvalue
+	}
+
+	return nil
 }
 
 // SetLvalue set the lefthand side of condition
 func (n *IfNode) SetLvalue(arg Expr) {
-	n.lvalue = arg
+	cond := n.binaryCond()
+	cond.FileInfo = token.NewFileInfo(arg.Line(), arg.Column())
+	cond.Lvalue = arg
 }
 
 // SetRvalue set the righthand side of condition
 func (n *IfNode) SetRvalue(arg Expr) {
-	n.rvalue = arg
+	n.binaryCond().Rvalue = arg
 }
 
 // Op returns the condition operation
-func (n *IfNode) Op() string { return n.op }
+func (n *IfNode) Op() string {
+	if cond, ok := n.cond.(*BinaryExpr); ok {
+		return cond.Op
+	}
+
+	return ""
+}
 
 // SetOp set the condition operation
 func (n *IfNode) SetOp(op string) {
-	n.op = op
+	n.binaryCond().Op = op
+}
+
+// Cond returns the condition expression
+func (n *IfNode) Cond() Expr { return n.cond }
+
+// SetCond set the condition expression
+func (n *IfNode) SetCond(cond Expr) {
+	n.cond = cond
+}
+
+// binaryCond returns the condition as a binary expression, creating
+// it if needed.
+func (n *IfNode) binaryCond() *BinaryExpr {
+	cond, ok := n.cond.(*BinaryExpr)
+	if !ok {
+		cond = NewBinaryExpr(n.FileInfo, nil, "", nil)
+		n.cond = cond
+	}
+
+	return cond
 }
 
 // IsElseIf tells if the if is an else-if statement
@@ -1095,23 +1400,8 @@ func (n *IfNode) IsEqual(other Node) bool {
 		return false
 	}
 
-	elvalue := n.Lvalue()
-	ervalue := n.Rvalue()
-	vlvalue := o.Lvalue()
-	vrvalue := o.Rvalue()
-
-	if !elvalue.IsEqual(vlvalue) {
-		debug("Lvalue differs: '%s' != '%s'", elvalue, vlvalue)
-		return false
-	}
-
-	if !ervalue.IsEqual(vrvalue) {
-		debug("Rvalue differs: '%s' != '%s'", ervalue, vrvalue)
-		return false
-	}
-
-	if n.Op() != o.Op() {
-		debug("Operation differs: %s != %s", n.Op(), o.Op())
+	if !n.cond.IsEqual(o.cond) {
+		debug("Condition differs: '%s' != '%s'", n.cond, o.cond)
 		return false
 	}
 
@@ -1130,6 +1420,129 @@ func (n *IfNode) IsEqual(other Node) bool {
 	return expectedTree.IsEqual(valueTree)
 }
 
+// NewSwitchNode creates a switch statement on the value expression.
+func NewSwitchNode(info token.FileInfo, value Expr) *SwitchNode {
+	return &SwitchNode{
+		NodeType: NodeSwitch,
+		FileInfo: info,
+
+		value: value,
+	}
+}
+
+// Value returns the expression matched by the cases.
+func (n *SwitchNode) Value() Expr { return n.value }
+
+// AddCase adds a case to the switch.
+func (n *SwitchNode) AddCase(c *CaseNode) {
+	n.cases = append(n.cases, c)
+}
+
+// Cases returns the cases in the order they are matched.
+func (n *SwitchNode) Cases() []*CaseNode { return n.cases }
+
+// SetDefaultTree sets the block run when no case matches.
+func (n *SwitchNode) SetDefaultTree(t *Tree) {
+	n.defaultTree = t
+}
+
+// DefaultTree returns the default block or nil.
+func (n *SwitchNode) DefaultTree() *Tree { return n.defaultTree }
+
+// IsEqual returns if it is equal to the other node.
+func (n *SwitchNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	o, ok := other.(*SwitchNode)
+	if !ok {
+		debug("Failed to convert to SwitchNode")
+		return false
+	}
+
+	if !n.value.IsEqual(o.value) {
+		debug("Switch value differs: '%s' != '%s'", n.value, o.value)
+		return false
+	}
+
+	if len(n.cases) != len(o.cases) {
+		debug("Number of cases differs: %d != %d", len(n.cases),
+			len(o.cases))
+		return false
+	}
+
+	for i := 0; i < len(n.cases); i++ {
+		if !n.cases[i].IsEqual(o.cases[i]) {
+			return false
+		}
+	}
+
+	if n.defaultTree == nil || o.defaultTree == nil {
+		return n.defaultTree == o.defaultTree
+	}
+
+	return n.defaultTree.IsEqual(o.defaultTree)
+}
+
+// NewCaseNode creates a case of a switch statement. If glob is true
+// the patterns are glob patterns.
+func NewCaseNode(info token.FileInfo, glob bool) *CaseNode {
+	return &CaseNode{
+		NodeType: NodeCase,
+		FileInfo: info,
+
+		glob: glob,
+	}
+}
+
+// AddPattern adds a pattern to the case.
+func (n *CaseNode) AddPattern(pattern Expr) {
+	n.patterns = append(n.patterns, pattern)
+}
+
+// Patterns returns the patterns of the case.
+func (n *CaseNode) Patterns() []Expr { return n.patterns }
+
+// IsGlob tells if the patterns are glob patterns.
+func (n *CaseNode) IsGlob() bool { return n.glob }
+
+// SetTree sets the block run when the case matches.
+func (n *CaseNode) SetTree(t *Tree) {
+	n.tree = t
+}
+
+// Tree returns the block of the case.
+func (n *CaseNode) Tree() *Tree { return n.tree }
+
+// IsEqual returns if it is equal to the other node.
+func (n *CaseNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	o, ok := other.(*CaseNode)
+	if !ok {
+		debug("Failed to convert to CaseNode")
+		return false
+	}
+
+	if n.glob != o.glob || len(n.patterns) != len(o.patterns) {
+		debug("Case differs: '%s' != '%s'", n, o)
+		return false
+	}
+
+	for i := 0; i < len(n.patterns); i++ {
+		if !n.patterns[i].IsEqual(o.patterns[i]) {
+			debug("Case pattern differs: '%s' != '%s'",
+				n.patterns[i], o.patterns[i])
+			return false
+		}
+	}
+
+	return n.tree.IsEqual(o.tree)
+}
+
 func NewFnArgNode(info token.FileInfo, name string, isVariadic bool) *FnArgNode {
 	return &FnArgNode{
 		NodeType: NodeFnArg,
@@ -1149,9 +1562,15 @@ func (a *FnArgNode) IsEqual(other Node) bool {
 		return false
 	}
 	if a.Name != o.Name ||
-		a.IsVariadic != o.IsVariadic {
+		a.IsVariadic != o.IsVariadic ||
+		a.ArgType != o.ArgType {
 		return false
 	}
+	if a.Default != o.Default {
+		if a.Default == nil || !a.Default.IsEqual(o.Default) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -1225,6 +1644,16 @@ func (n *FnDeclNode) AddArg(arg *FnArgNode) {
 	n.args = append(n.args, arg)
 }
 
+// ReturnTypes returns the type annotations of the return values
+func (n *FnDeclNode) ReturnTypes() []string {
+	return n.returnTypes
+}
+
+// SetReturnTypes set the type annotations of the return values
+func (n *FnDeclNode) SetReturnTypes(types []string) {
+	n.returnTypes = types
+}
+
 // Tree return the function block
 func (n *FnDeclNode) Tree() *Tree {
 	return n.tree
@@ -1246,7 +1675,8 @@ func (n *FnDeclNode) IsEqual(other Node) bool {
 		return false
 	}
 
-	if n.name != o.name || len(n.args) != len(o.args) {
+	if n.name != o.name || len(n.args) != len(o.args) ||
+		len(n.returnTypes) != len(o.returnTypes) {
 		return false
 	}
 
@@ -1256,6 +1686,12 @@ func (n *FnDeclNode) IsEqual(other Node) bool {
 		}
 	}
 
+	for i := 0; i < len(n.returnTypes); i++ {
+		if n.returnTypes[i] != o.returnTypes[i] {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -1287,6 +1723,29 @@ func (n *FnInvNode) AddArg(arg Expr) {
 // Args return the invocation arguments.
 func (n *FnInvNode) Args() []Expr { return n.args }
 
+// AddNamedArg adds an argument passed by name to the invocation.
+func (n *FnInvNode) AddNamedArg(arg *FnNamedArg) {
+	n.named = append(n.named, arg)
+}
+
+// NamedArgs returns the arguments passed by name.
+func (n *FnInvNode) NamedArgs() []*FnNamedArg { return n.named }
+
+// NewFnNamedArg creates a new argument passed by name.
+func NewFnNamedArg(info token.FileInfo, name string, value Expr) *FnNamedArg {
+	return &FnNamedArg{
+		FileInfo: info,
+
+		Name:  name,
+		Value: value,
+	}
+}
+
+// IsEqual returns if it is equal to the other argument.
+func (a *FnNamedArg) IsEqual(o *FnNamedArg) bool {
+	return a.Name == o.Name && a.Value.IsEqual(o.Value)
+}
+
 // IsEqual returns if it is equal to the other node.
 func (n *FnInvNode) IsEqual(other Node) bool {
 	if !n.equal(n, other) {
@@ -1299,7 +1758,8 @@ func (n *FnInvNode) IsEqual(other Node) bool {
 		return false
 	}
 
-	if len(n.args) != len(o.args) {
+	if len(n.args) != len(o.args) ||
+		len(n.named) != len(o.named) {
 		return false
 	}
 
@@ -1309,9 +1769,59 @@ func (n *FnInvNode) IsEqual(other Node) bool {
 		}
 	}
 
+	for i := 0; i < len(n.named); i++ {
+		if !n.named[i].IsEqual(o.named[i]) {
+			return false
+		}
+	}
+
 	return true
 }
 
+// NewSpawnNode creates a new spawn of the function invocation fnInv
+func NewSpawnNode(info token.FileInfo, fnInv *FnInvNode) *SpawnNode {
+	return &SpawnNode{
+		NodeType: NodeSpawn,
+		FileInfo: info,
+
+		fnInv: fnInv,
+	}
+}
+
+// SetFnDecl sets the anonymous function invoked by the spawn.
+func (n *SpawnNode) SetFnDecl(fnDecl *FnDeclNode) {
+	n.fnDecl = fnDecl
+}
+
+// FnDecl returns the anonymous function invoked by the spawn or nil
+// if a named function is invoked.
+func (n *SpawnNode) FnDecl() *FnDeclNode { return n.fnDecl }
+
+// FnInv returns the function invocation.
+func (n *SpawnNode) FnInv() *FnInvNode { return n.fnInv }
+
+// IsEqual returns if it is equal to the other node.
+func (n *SpawnNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	o, ok := other.(*SpawnNode)
+	if !ok {
+		return false
+	}
+
+	if (n.fnDecl == nil) != (o.fnDecl == nil) {
+		return false
+	}
+
+	if n.fnDecl != nil && !n.fnDecl.IsEqual(o.fnDecl) {
+		return false
+	}
+
+	return n.fnInv.IsEqual(o.fnInv)
+}
+
 // NewBindFnNode creates a new bindfn statement
 func NewBindFnNode(info token.FileInfo, name, cmd string) *BindFnNode {
 	return &BindFnNode{
@@ -1343,6 +1853,56 @@ func (n *BindFnNode) IsEqual(other Node) bool {
 	return n.name == o.name && n.cmdname == o.cmdname
 }
 
+// NewShowEnvNode creates a showenv statement
+func NewShowEnvNode(info token.FileInfo) *ShowEnvNode {
+	return &ShowEnvNode{
+		NodeType: NodeShowEnv,
+		FileInfo: info,
+	}
+}
+
+// IsEqual returns if it is equal to the other node.
+func (n *ShowEnvNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	_, ok := other.(*ShowEnvNode)
+	return ok
+}
+
+// NewDumpNode creates a dump statement. If filename is nil the
+// session is dumped to stdout.
+func NewDumpNode(info token.FileInfo, filename Expr) *DumpNode {
+	return &DumpNode{
+		NodeType: NodeDump,
+		FileInfo: info,
+
+		filename: filename,
+	}
+}
+
+// Filename returns the file where the session is dumped or nil.
+func (n *DumpNode) Filename() Expr { return n.filename }
+
+// IsEqual returns if it is equal to the other node.
+func (n *DumpNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	o, ok := other.(*DumpNode)
+	if !ok {
+		return false
+	}
+
+	if n.filename == nil || o.filename == nil {
+		return n.filename == o.filename
+	}
+
+	return n.filename.IsEqual(o.filename)
+}
+
 // NewReturnNode create a return statement
 func NewReturnNode(info token.FileInfo) *ReturnNode {
 	return &ReturnNode{
@@ -1382,6 +1942,68 @@ func (n *ReturnNode) IsEqual(other Node) bool {
 	return true
 }
 
+// NewDeferNode creates a defer statement of the command or function
+// invocation stmt
+func NewDeferNode(info token.FileInfo, stmt Node) *DeferNode {
+	return &DeferNode{
+		NodeType: NodeDefer,
+		FileInfo: info,
+
+		stmt: stmt,
+	}
+}
+
+// Stmt returns the deferred command or function invocation.
+func (n *DeferNode) Stmt() Node { return n.stmt }
+
+// IsEqual returns if it is equal to the other node.
+func (n *DeferNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	o, ok := other.(*DeferNode)
+	if !ok {
+		return false
+	}
+
+	return n.stmt.IsEqual(o.stmt)
+}
+
+// NewBreakNode create a break statement
+func NewBreakNode(info token.FileInfo) *BreakNode {
+	return &BreakNode{
+		FileInfo: info,
+		NodeType: NodeBreak,
+	}
+}
+
+func (n *BreakNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	_, ok := other.(*BreakNode)
+	return ok
+}
+
+// NewContinueNode create a continue statement
+func NewContinueNode(info token.FileInfo) *ContinueNode {
+	return &ContinueNode{
+		FileInfo: info,
+		NodeType: NodeContinue,
+	}
+}
+
+func (n *ContinueNode) IsEqual(other Node) bool {
+	if !n.equal(n, other) {
+		return false
+	}
+
+	_, ok := other.(*ContinueNode)
+	return ok
+}
+
 // NewForNode create a new for statement
 func NewForNode(info token.FileInfo) *ForNode {
 	return &ForNode{
@@ -1398,12 +2020,40 @@ func (n *ForNode) SetIdentifier(a string) {
 // Identifier return the identifier part
 func (n *ForNode) Identifier() string { return n.identifier }
 
+// SetValueIdentifier set the second identifier of "for k, v in ..."
+func (n *ForNode) SetValueIdentifier(a string) {
+	n.valueIdentifier = a
+}
+
+// ValueIdentifier return the second identifier part (if any)
+func (n *ForNode) ValueIdentifier() string { return n.valueIdentifier }
+
+// SetPairs sets if the loop unpacks a list of pairs, as in
+// "for (key, value) in $pairs".
+func (n *ForNode) SetPairs(b bool) { n.pairs = b }
+
+// Pairs tells if the loop unpacks a list of pairs.
+func (n *ForNode) Pairs() bool { return n.pairs }
+
 // InVar return the "in" variable
 func (n *ForNode) InExpr() Expr { return n.inExpr }
 
 // SetInVar set "in" expression
 func (n *ForNode) SetInExpr(a Expr) { n.inExpr = a }
 
+// InCmd returns the command whose output lines are iterated by
+// "for line in <= cmd".
+func (n *ForNode) InCmd() Node { return n.inCmd }
+
+// SetInCmd sets the command whose output lines are iterated.
+func (n *ForNode) SetInCmd(a Node) { n.inCmd = a }
+
+// Cond returns the loop condition of a conditional for
+func (n *ForNode) Cond() Expr { return n.cond }
+
+// SetCond set the loop condition
+func (n *ForNode) SetCond(a Expr) { n.cond = a }
+
 // SetTree set the for block of statements
 func (n *ForNode) SetTree(a *Tree) {
 	n.tree = a
@@ -1427,10 +2077,24 @@ func (n *ForNode) IsEqual(other Node) bool {
 		return false
 	}
 
-	if n.identifier != o.identifier {
+	if n.identifier != o.identifier ||
+		n.valueIdentifier != o.valueIdentifier ||
+		n.pairs != o.pairs {
 		return false
 	}
 
+	if n.cond != o.cond {
+		if n.cond == nil || !n.cond.IsEqual(o.cond) {
+			return false
+		}
+	}
+
+	if n.inCmd != o.inCmd {
+		if n.inCmd == nil || o.inCmd == nil || !n.inCmd.IsEqual(o.inCmd) {
+			return false
+		}
+	}
+
 	if n.inExpr == o.inExpr {
 		return true
 	}
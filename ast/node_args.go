@@ -130,6 +130,49 @@ func (l *ListExpr) IsEqual(other Node) bool {
 	return true
 }
 
+func NewMapExpr(info token.FileInfo, keys, values []Expr) *MapExpr {
+	return &MapExpr{
+		NodeType: NodeMapExpr,
+		FileInfo: info,
+
+		Keys:   keys,
+		Values: values,
+	}
+}
+
+// PushEntry push a key/value entry to end of the map literal
+func (m *MapExpr) PushEntry(key, value Expr) {
+	m.Keys = append(m.Keys, key)
+	m.Values = append(m.Values, value)
+}
+
+func (m *MapExpr) IsEqual(other Node) bool {
+	if !m.equal(m, other) {
+		return false
+	}
+
+	o, ok := other.(*MapExpr)
+
+	if !ok {
+		return false
+	}
+
+	if len(m.Keys) != len(o.Keys) || len(m.Values) != len(o.Values) {
+		return false
+	}
+
+	for i := 0; i < len(m.Keys); i++ {
+		if !m.Keys[i].IsEqual(o.Keys[i]) ||
+			!m.Values[i].IsEqual(o.Values[i]) {
+			debug("map entry %d differs: %s: %s != %s: %s", i,
+				m.Keys[i], m.Values[i], o.Keys[i], o.Values[i])
+			return false
+		}
+	}
+
+	return true
+}
+
 func NewConcatExpr(info token.FileInfo, parts []Expr) *ConcatExpr {
 	return &ConcatExpr{
 		NodeType: NodeConcatExpr,
@@ -175,6 +218,101 @@ func (c *ConcatExpr) IsEqual(other Node) bool {
 	return true
 }
 
+// NewBinaryExpr creates a new binary expression
+func NewBinaryExpr(info token.FileInfo, lvalue Expr, op string, rvalue Expr) *BinaryExpr {
+	return &BinaryExpr{
+		NodeType: NodeBinaryExpr,
+		FileInfo: info,
+
+		Lvalue: lvalue,
+		Op:     op,
+		Rvalue: rvalue,
+	}
+}
+
+func (b *BinaryExpr) IsEqual(other Node) bool {
+	if !b.equal(b, other) {
+		return false
+	}
+
+	o, ok := other.(*BinaryExpr)
+	if !ok {
+		return false
+	}
+
+	if b.Op != o.Op {
+		debug("Operation differs: %s != %s", b.Op, o.Op)
+		return false
+	}
+
+	if !b.Lvalue.IsEqual(o.Lvalue) {
+		debug("Lvalue differs: '%s' != '%s'", b.Lvalue, o.Lvalue)
+		return false
+	}
+
+	if !b.Rvalue.IsEqual(o.Rvalue) {
+		debug("Rvalue differs: '%s' != '%s'", b.Rvalue, o.Rvalue)
+		return false
+	}
+
+	return true
+}
+
+// NewUnaryExpr creates a new unary expression
+func NewUnaryExpr(info token.FileInfo, op string, value Expr) *UnaryExpr {
+	return &UnaryExpr{
+		NodeType: NodeUnaryExpr,
+		FileInfo: info,
+
+		Op:    op,
+		Value: value,
+	}
+}
+
+func (u *UnaryExpr) IsEqual(other Node) bool {
+	if !u.equal(u, other) {
+		return false
+	}
+
+	o, ok := other.(*UnaryExpr)
+	if !ok {
+		return false
+	}
+
+	return u.Op == o.Op && u.Value.IsEqual(o.Value)
+}
+
+func NewSliceExpr(info token.FileInfo, start, end Expr) *SliceExpr {
+	return &SliceExpr{
+		NodeType: NodeSliceExpr,
+		FileInfo: info,
+
+		Start: start,
+		End:   end,
+	}
+}
+
+func (s *SliceExpr) IsEqual(other Node) bool {
+	if !s.equal(s, other) {
+		return false
+	}
+
+	o, ok := other.(*SliceExpr)
+	if !ok {
+		return false
+	}
+
+	cmp := func(a, b Expr) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+
+		return a.IsEqual(b)
+	}
+
+	return cmp(s.Start, o.Start) && cmp(s.End, o.End)
+}
+
 func NewVarExpr(info token.FileInfo, name string) *VarExpr {
 	return NewVarVariadicExpr(info, name, false)
 }
@@ -45,20 +45,116 @@ func (l *ListExpr) String() string {
 	return str
 }
 
+func (m *MapExpr) string() (string, bool) {
+	entries := make([]string, len(m.Keys))
+	columnCount := 0
+
+	for i := 0; i < len(m.Keys); i++ {
+		entries[i] = m.Keys[i].String() + ": " + m.Values[i].String()
+		columnCount += len(entries[i])
+	}
+
+	if columnCount+len(entries) > 50 {
+		for i := 0; i < len(entries); i++ {
+			entries[i] = strings.Replace(entries[i], "\n", "\n\t", -1)
+		}
+
+		return "{\n\t" + strings.Join(entries, ",\n\t") + ",\n}", true
+	}
+
+	return "{" + strings.Join(entries, ", ") + "}", false
+}
+
+func (m *MapExpr) String() string {
+	str, _ := m.string()
+	return str
+}
+
 func (c *ConcatExpr) String() string {
 	ret := ""
+	sep := "+"
+
+	// integer expressions require spaces around the operator
+	for _, part := range c.concat {
+		if part.Type() == NodeIntExpr || part.Type() == NodeBinaryExpr {
+			sep = " + "
+			break
+		}
+	}
 
 	for i := 0; i < len(c.concat); i++ {
 		ret += c.concat[i].String()
 
 		if i < (len(c.concat) - 1) {
-			ret += "+"
+			ret += sep
 		}
 	}
 
 	return ret
 }
 
+func (b *BinaryExpr) String() string {
+	prec := precedence(b)
+
+	lstr := b.Lvalue.String()
+	if precedence(b.Lvalue) < prec {
+		lstr = "(" + lstr + ")"
+	}
+
+	rstr := b.Rvalue.String()
+	if precedence(b.Rvalue) <= prec {
+		rstr = "(" + rstr + ")"
+	}
+
+	return lstr + " " + b.Op + " " + rstr
+}
+
+func (u *UnaryExpr) String() string {
+	if u.Value.Type() == NodeBinaryExpr {
+		return u.Op + "(" + u.Value.String() + ")"
+	}
+
+	return u.Op + u.Value.String()
+}
+
+func (s *SliceExpr) String() string {
+	var start, end string
+
+	if s.Start != nil {
+		start = s.Start.String()
+	}
+
+	if s.End != nil {
+		end = s.End.String()
+	}
+
+	return start + ":" + end
+}
+
+// precedence returns the binding power of the expression operator.
+// Expressions without operators bind tighter than any operator.
+func precedence(expr Expr) int {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		switch e.Op {
+		case "||":
+			return 1
+		case "&&":
+			return 2
+		case "-":
+			return 4
+		case "*", "/", "%":
+			return 5
+		default:
+			return 3
+		}
+	case *ConcatExpr:
+		return 4
+	}
+
+	return 6
+}
+
 func (v *VarExpr) String() string {
 	if v.IsVariadic {
 		return v.Name + "..."
@@ -156,6 +252,10 @@ func (l *BlockNode) String() string {
 
 // String returns the string representation of the import
 func (n *ImportNode) String() string {
+	if n.Name != "" {
+		return `import ` + n.Path.String() + ` as ` + n.Name
+	}
+
 	return `import ` + n.Path.String()
 }
 
@@ -198,6 +298,9 @@ func (n *AssignNode) string() (string, bool) {
 			if obj.Type() == NodeListExpr {
 				lobj := obj.(*ListExpr)
 				objStr, objmulti = lobj.string()
+			} else if obj.Type() == NodeMapExpr {
+				mobj := obj.(*MapExpr)
+				objStr, objmulti = mobj.string()
 			} else {
 				objStr = obj.String()
 			}
@@ -243,6 +346,9 @@ func (n *ExecAssignNode) string() (string, bool) {
 	} else if n.cmd.Type() == NodePipe {
 		cmd := n.cmd.(*PipeNode)
 		cmdStr, multi = cmd.string()
+	} else if n.cmd.Type() == NodeSpawn {
+		cmdStr = n.cmd.String()
+		multi = strings.Contains(cmdStr, "\n")
 	} else {
 		cmd := n.cmd.(*FnInvNode)
 		cmdStr, multi = cmd.string()
@@ -355,7 +461,7 @@ func (n *CommandNode) multiString() string {
 // String returns the string representation of command statement
 func (n *CommandNode) string() (string, bool) {
 	if n.multi {
-		return n.multiString(), true
+		return n.multiString() + bgString(n.background), true
 	}
 
 	var content []string
@@ -370,7 +476,7 @@ func (n *CommandNode) string() (string, bool) {
 		content = append(content, n.redirs[i].String())
 	}
 
-	return strings.Join(content, " "), false
+	return strings.Join(content, " ") + bgString(n.background), false
 }
 
 func (n *CommandNode) String() string {
@@ -378,6 +484,22 @@ func (n *CommandNode) String() string {
 	return str
 }
 
+// pipeString returns the pipe operator connecting the command to
+// the next one of the pipeline.
+func (n *CommandNode) pipeString() string {
+	if len(n.pipeFds) == 0 {
+		return "|"
+	}
+
+	fds := make([]string, len(n.pipeFds))
+
+	for i, fd := range n.pipeFds {
+		fds[i] = strconv.Itoa(fd)
+	}
+
+	return "|[" + strings.Join(fds, ",") + "]"
+}
+
 func (n *PipeNode) multiString() string {
 	totalLen := 0
 
@@ -406,7 +528,7 @@ func (n *PipeNode) multiString() string {
 			result += strings.Join(content[i].content, " ")
 
 			if i < len(content)-1 {
-				result += " | "
+				result += " " + n.cmds[i].pipeString() + " "
 			}
 		}
 
@@ -430,7 +552,7 @@ func (n *PipeNode) multiString() string {
 		result += strings.Join(cmdContent, "\n")
 
 		if i < len(content)-1 {
-			result += " |\n"
+			result += " " + n.cmds[i].pipeString() + "\n"
 		}
 	}
 
@@ -440,7 +562,7 @@ func (n *PipeNode) multiString() string {
 // String returns the string representation of pipeline statement
 func (n *PipeNode) string() (string, bool) {
 	if n.multi {
-		return n.multiString(), true
+		return n.multiString() + bgString(n.background), true
 	}
 
 	ret := ""
@@ -449,11 +571,20 @@ func (n *PipeNode) string() (string, bool) {
 		ret += n.cmds[i].String()
 
 		if i < (len(n.cmds) - 1) {
-			ret += " | "
+			ret += " " + n.cmds[i].pipeString() + " "
 		}
 	}
 
-	return ret, false
+	return ret + bgString(n.background), false
+}
+
+// bgString returns the suffix of commands running in background.
+func bgString(background bool) string {
+	if background {
+		return " &"
+	}
+
+	return ""
 }
 
 func (n *PipeNode) String() string {
@@ -465,20 +596,31 @@ func (n *PipeNode) String() string {
 func (r *RedirectNode) String() string {
 	var result string
 
+	op := ">"
+
+	switch r.kind {
+	case RedirAppend:
+		op = ">>"
+	case RedirInput:
+		op = "<"
+	case RedirHereStr:
+		return "<<< " + r.location.String()
+	}
+
 	if r.rmap.lfd == r.rmap.rfd {
 		if r.location != nil {
-			return "> " + r.location.String()
+			return op + " " + r.location.String()
 		}
 
 		return ""
 	}
 
 	if r.rmap.rfd >= 0 {
-		result = ">[" + strconv.Itoa(r.rmap.lfd) + "=" + strconv.Itoa(r.rmap.rfd) + "]"
+		result = op + "[" + strconv.Itoa(r.rmap.lfd) + "=" + strconv.Itoa(r.rmap.rfd) + "]"
 	} else if r.rmap.rfd == RedirMapNoValue {
-		result = ">[" + strconv.Itoa(r.rmap.lfd) + "]"
+		result = op + "[" + strconv.Itoa(r.rmap.lfd) + "]"
 	} else if r.rmap.rfd == RedirMapSupress {
-		result = ">[" + strconv.Itoa(r.rmap.lfd) + "=]"
+		result = op + "[" + strconv.Itoa(r.rmap.lfd) + "=]"
 	}
 
 	if r.location != nil {
@@ -515,12 +657,7 @@ func (n *CommentNode) String() string {
 
 // String returns the string representation of if statement
 func (n *IfNode) String() string {
-	var lstr, rstr string
-
-	lstr = n.lvalue.String()
-	rstr = n.rvalue.String()
-
-	ifStr := "if " + lstr + " " + n.op + " " + rstr + " {\n"
+	ifStr := "if " + n.cond.String() + " {\n"
 
 	ifTree := n.IfTree()
 
@@ -563,6 +700,64 @@ func (n *IfNode) String() string {
 	return ifStr
 }
 
+// String returns the string representation of switch statement
+func (n *SwitchNode) String() string {
+	switchStr := "switch " + n.value.String() + " {\n"
+
+	for _, c := range n.cases {
+		switchStr += indent(c.String()) + "\n"
+	}
+
+	if n.defaultTree != nil {
+		switchStr += indent(blockString("default", n.defaultTree)) + "\n"
+	}
+
+	return switchStr + "}"
+}
+
+// String returns the string representation of a case of switch
+// statement
+func (n *CaseNode) String() string {
+	patterns := make([]string, len(n.patterns))
+
+	for i, pattern := range n.patterns {
+		patterns[i] = pattern.String()
+	}
+
+	caseStr := "case "
+
+	if n.glob {
+		caseStr += "glob "
+	}
+
+	return blockString(caseStr+strings.Join(patterns, ", "), n.tree)
+}
+
+// blockString returns the string representation of the block tree
+// prefixed by header.
+func blockString(header string, tree *Tree) string {
+	block := tree.String()
+
+	if strings.TrimSpace(block) == "" {
+		return header + " {\n\n}"
+	}
+
+	return header + " {\n" + indent(block) + "\n}"
+}
+
+// indent indents the non empty lines of code with a tab.
+func indent(code string) string {
+	lines := strings.Split(code, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		if len(lines[i]) > 0 {
+			lines[i] = "\t" + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func (n *VarAssignDeclNode) String() string     { return "var " + n.Assign.String() }
 func (n *VarExecAssignDeclNode) String() string { return "var " + n.ExecAssign.String() }
 
@@ -572,6 +767,8 @@ func (n *FnDeclNode) String() string {
 
 	if n.name != "" {
 		fnStr += " " + n.name + "("
+	} else {
+		fnStr += " ("
 	}
 
 	for i := 0; i < len(n.args); i++ {
@@ -581,7 +778,13 @@ func (n *FnDeclNode) String() string {
 		}
 	}
 
-	fnStr += ") {\n"
+	fnStr += ")"
+
+	if len(n.returnTypes) > 0 {
+		fnStr += ": " + strings.Join(n.returnTypes, ", ")
+	}
+
+	fnStr += " {\n"
 
 	tree := n.Tree()
 
@@ -605,6 +808,12 @@ func (arg *FnArgNode) String() string {
 	if arg.IsVariadic {
 		ret += "..."
 	}
+	if arg.ArgType != "" {
+		ret += ": " + arg.ArgType
+	}
+	if arg.Default != nil {
+		ret += " = " + arg.Default.String()
+	}
 	return ret
 }
 
@@ -620,6 +829,14 @@ func (n *FnInvNode) string() (string, bool) {
 		}
 	}
 
+	for i := 0; i < len(n.named); i++ {
+		if i > 0 || len(n.args) > 0 {
+			fnInvStr += ", "
+		}
+
+		fnInvStr += n.named[i].String()
+	}
+
 	fnInvStr += ")"
 
 	return fnInvStr, false
@@ -630,11 +847,46 @@ func (n *FnInvNode) String() string {
 	return str
 }
 
+// String returns the string representation of the named argument
+func (a *FnNamedArg) String() string {
+	return a.Name + " = " + a.Value.String()
+}
+
+// String returns the string representation of spawn
+func (n *SpawnNode) String() string {
+	spawnStr := "spawn "
+
+	if n.fnDecl != nil {
+		spawnStr += n.fnDecl.String()
+	}
+
+	return spawnStr + n.fnInv.String()
+}
+
+// String returns the string representation of defer statement
+func (n *DeferNode) String() string {
+	return "defer " + n.stmt.String()
+}
+
 // String returns the string representation of bindfn
 func (n *BindFnNode) String() string {
 	return "bindfn " + n.name + " " + n.cmdname
 }
 
+// String returns the string representation of showenv statement
+func (n *ShowEnvNode) String() string {
+	return "showenv"
+}
+
+// String returns the string representation of dump statement
+func (n *DumpNode) String() string {
+	if n.filename == nil {
+		return "dump"
+	}
+
+	return "dump " + n.filename.String()
+}
+
 // String returns the string representation of return statement
 func (n *ReturnNode) String() string {
 	var returns []string
@@ -654,12 +906,37 @@ func (n *ReturnNode) String() string {
 	return ret
 }
 
+// String returns the string representation of break statement
+func (n *BreakNode) String() string {
+	return "break"
+}
+
+// String returns the string representation of continue statement
+func (n *ContinueNode) String() string {
+	return "continue"
+}
+
 // String returns the string representation of for statement
 func (n *ForNode) String() string {
 	ret := "for"
 
-	if n.identifier != "" {
-		ret += " " + n.identifier + " in " + n.inExpr.String()
+	if n.pairs {
+		ret += " (" + n.identifier + ", " + n.valueIdentifier + ") in " +
+			n.inExpr.String()
+	} else if n.identifier != "" {
+		ret += " " + n.identifier
+
+		if n.valueIdentifier != "" {
+			ret += ", " + n.valueIdentifier
+		}
+
+		if n.inCmd != nil {
+			ret += " in <= " + n.inCmd.String()
+		} else {
+			ret += " in " + n.inExpr.String()
+		}
+	} else if n.cond != nil {
+		ret += " " + n.cond.String()
 	}
 
 	ret += " {\n"
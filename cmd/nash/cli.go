@@ -93,6 +93,14 @@ func cli(shell *nash.Shell) error {
 
 	shell.SetInteractive(true)
 
+	// job control is only enabled if the shell reads commands
+	// from a terminal
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		if err := shell.SetJobControl(true); err != nil {
+			fmt.Fprintf(os.Stderr, "no job control in this shell: %s\n", err)
+		}
+	}
+
 	if err := loadInit(shell); err != nil {
 		fmt.Fprintf(os.Stderr, "error loading init file:\n%s\n", err)
 	}
@@ -151,6 +159,7 @@ func docli(shell *nash.Shell, rline *readline.Instance) error {
 		}
 
 		if !unfinished {
+			shell.NotifyJobs()
 			prompt = shell.Prompt()
 		}
 
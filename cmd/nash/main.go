@@ -87,11 +87,8 @@ func main() {
 	}
 
 	if (file == "" && command == "") || interactive {
-		if err = cli(shell); err != nil {
-			goto Error
-		}
-
-		return
+		err = cli(shell)
+		goto Error
 	}
 
 	if file != "" {
@@ -108,6 +105,10 @@ func main() {
 	}
 
 Error:
+	if shell != nil {
+		shell.RunExitTrap()
+	}
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
@@ -25,6 +25,11 @@ type (
 		unfinished
 	}
 
+	unfinishedMapError struct {
+		*NashError
+		unfinished
+	}
+
 	unfinishedCmdError struct {
 		*NashError
 		unfinished
@@ -64,6 +69,13 @@ func NewUnfinishedListError(name string, it scanner.Token) error {
 	}
 }
 
+func NewUnfinishedMapError(name string, it scanner.Token) error {
+	return &unfinishedMapError{
+		NashError: NewError("%s:%d:%d: Map literal not finished. Found %v",
+			name, it.Line(), it.Column(), it),
+	}
+}
+
 func NewUnfinishedCmdError(name string, it scanner.Token) error {
 	return &unfinishedCmdError{
 		NashError: NewError("%s:%d:%d: Multi-line command not finished. Found %v but expect ')'",
@@ -97,6 +97,9 @@ func (f *builtinFn) SetStdout(w io.Writer) {
 func (f *builtinFn) StdoutPipe() (io.ReadCloser, error) {
 	return nil, errors.NewError("builtin functions doesn't works with pipes")
 }
+func (f *builtinFn) StderrPipe() (io.ReadCloser, error) {
+	return nil, errors.NewError("builtin functions doesn't works with pipes")
+}
 func (f *builtinFn) Stdin() io.Reader  { return f.stdin }
 func (f *builtinFn) Stdout() io.Writer { return f.stdout }
 func (f *builtinFn) Stderr() io.Writer { return f.stderr }
@@ -0,0 +1,51 @@
+package builtin
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	atoiFn struct {
+		val int
+	}
+)
+
+func newAtoi() *atoiFn {
+	return &atoiFn{}
+}
+
+func (a *atoiFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{sh.NewFnArg("str", false)}
+}
+
+func (a *atoiFn) Run(in io.Reader, out io.Writer, e io.Writer) ([]sh.Obj, error) {
+	return []sh.Obj{sh.NewIntObj(a.val)}, nil
+}
+
+func (a *atoiFn) SetArgs(args []sh.Obj) error {
+	if len(args) != 1 {
+		return errors.NewError("atoi expects 1 string argument")
+	}
+
+	obj := args[0]
+	if obj.Type() != sh.StringType {
+		return errors.NewError(
+			"atoi expects a string, but a %s was provided",
+			obj.Type(),
+		)
+	}
+
+	val, err := strconv.Atoi(strings.TrimSpace(obj.String()))
+	if err != nil {
+		return errors.NewError("atoi:error[%s] converting '%s' to int",
+			err, obj)
+	}
+
+	a.val = val
+	return nil
+}
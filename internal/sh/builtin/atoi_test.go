@@ -0,0 +1,72 @@
+package builtin_test
+
+import "testing"
+
+func TestAtoi(t *testing.T) {
+	type atoiDesc struct {
+		script string
+		output string
+	}
+
+	tests := map[string]atoiDesc{
+		"positive": {
+			script: `
+				var n <= atoi("41")
+				echo $n
+				n = $n + 1
+				echo $n
+			`,
+			output: "41\n42\n",
+		},
+		"negative": {
+			script: `
+				var n <= atoi("-10")
+				n = $n * 2
+				echo $n
+			`,
+			output: "-20\n",
+		},
+		"spaces": {
+			script: `
+				var n <= atoi(" 7
+")
+				n = $n % 4
+				echo $n
+			`,
+			output: "3\n",
+		},
+	}
+
+	for name, desc := range tests {
+		t.Run(name, func(t *testing.T) {
+			output := execSuccess(t, desc.script)
+			if output != desc.output {
+				t.Fatalf("got %q expected %q", output, desc.output)
+			}
+		})
+	}
+}
+
+func TestAtoiErrors(t *testing.T) {
+	type atoiDesc struct {
+		script string
+	}
+
+	tests := map[string]atoiDesc{
+		"noParams": {
+			script: `atoi()`,
+		},
+		"notANumber": {
+			script: `atoi("nash")`,
+		},
+		"notAString": {
+			script: `atoi(("1" "2"))`,
+		},
+	}
+
+	for name, desc := range tests {
+		t.Run(name, func(t *testing.T) {
+			execFailure(t, desc.script)
+		})
+	}
+}
@@ -36,6 +36,11 @@ func (e *exitFn) SetArgs(args []sh.Obj) error {
 	}
 
 	obj := args[0]
+	if obj.Type() == sh.IntType {
+		e.status = obj.(*sh.IntObj).Int()
+		return nil
+	}
+
 	if obj.Type() != sh.StringType {
 		return errors.NewError(
 			"exit expects a status string, but a %s was provided",
@@ -10,7 +10,7 @@ import (
 
 type (
 	lenFn struct {
-		arg sh.Collection
+		arg sh.Sizer
 	}
 )
 
@@ -38,11 +38,11 @@ func (l *lenFn) SetArgs(args []sh.Obj) error {
 	}
 
 	obj := args[0]
-	col, err := sh.NewCollection(obj)
+	sizer, err := sh.NewSizer(obj)
 	if err != nil {
 		return errors.NewError("len:error[%s]", err)
 	}
 
-	l.arg = col
+	l.arg = sizer
 	return nil
 }
@@ -53,4 +53,24 @@ func TestLen(t *testing.T) {
 		t.Errorf("String differs: '%s' != '%s'", "4", string(out.Bytes()))
 		return
 	}
+
+	out.Reset()
+
+	err = sh.Exec(
+		"test len map",
+		`var m = {"a": "1", "b": "2"}
+		 var l <= len($m)
+		 echo -n $l
+		`,
+	)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if "2" != string(out.Bytes()) {
+		t.Errorf("String differs: '%s' != '%s'", "2", string(out.Bytes()))
+		return
+	}
 }
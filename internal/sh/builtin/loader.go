@@ -33,5 +33,6 @@ func Constructors() map[string]Constructor {
 		"chdir":  func() Fn { return newChdir() },
 		"append": func() Fn { return newAppend() },
 		"exit":   func() Fn { return newExit() },
+		"atoi":   func() Fn { return newAtoi() },
 	}
 }
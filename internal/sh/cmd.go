@@ -2,6 +2,7 @@ package sh
 
 import (
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 
@@ -69,28 +70,52 @@ func (c *Cmd) SetStdin(in io.Reader)   { c.Cmd.Stdin = in }
 func (c *Cmd) SetStdout(out io.Writer) { c.Cmd.Stdout = out }
 func (c *Cmd) SetStderr(err io.Writer) { c.Cmd.Stderr = err }
 
+// SetExtraFile sets the file opened as the descriptor fd (greater
+// than 2) in the child process.
+func (c *Cmd) SetExtraFile(fd int, f *os.File) {
+	idx := fd - 3
+
+	for len(c.Cmd.ExtraFiles) <= idx {
+		c.Cmd.ExtraFiles = append(c.Cmd.ExtraFiles, nil)
+	}
+
+	c.Cmd.ExtraFiles[idx] = f
+}
+
+// ExtraFile returns the file of the descriptor fd (greater than 2)
+// or nil if it was not set.
+func (c *Cmd) ExtraFile(fd int) *os.File {
+	idx := fd - 3
+
+	if idx < 0 || idx >= len(c.Cmd.ExtraFiles) {
+		return nil
+	}
+
+	return c.Cmd.ExtraFiles[idx]
+}
+
 func (c *Cmd) SetArgs(nodeArgs []sh.Obj) error {
 	args := make([]string, 1, len(nodeArgs)+1)
 	args[0] = c.Path
 
 	for _, obj := range nodeArgs {
-		if obj.Type() == sh.StringType {
-			objstr := obj.(*sh.StrObj)
-			args = append(args, objstr.Str())
+		if obj.Type() == sh.StringType || obj.Type() == sh.IntType {
+			args = append(args, obj.String())
 		} else if obj.Type() == sh.ListType {
 			objlist := obj.(*sh.ListObj)
 			values := objlist.List()
 
 			for _, l := range values {
-				if l.Type() != sh.StringType {
+				if l.Type() != sh.StringType && l.Type() != sh.IntType {
 					return errors.NewError("Command arguments requires string or list of strings. But received '%v'", l.String())
 				}
 
-				lstr := l.(*sh.StrObj)
-				args = append(args, lstr.Str())
+				args = append(args, l.String())
 			}
 		} else if obj.Type() == sh.FnType {
 			return errors.NewError("Function cannot be passed as argument to commands.")
+		} else if obj.Type() == sh.MapType {
+			return errors.NewError("Map cannot be passed as argument to commands.")
 		} else {
 			return errors.NewError("Invalid command argument '%v'", obj)
 		}
@@ -125,3 +150,12 @@ func (c *Cmd) Start() error {
 }
 
 func (c *Cmd) Results() []sh.Obj { return nil }
+
+// Kill kills the started process.
+func (c *Cmd) Kill() error {
+	if c.Process == nil {
+		return nil
+	}
+
+	return c.Process.Kill()
+}
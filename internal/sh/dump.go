@@ -0,0 +1,183 @@
+package sh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+	"github.com/madlambda/nash/token"
+)
+
+// executeShowEnv prints the variables exported to the environment
+// of commands.
+func (shell *Shell) executeShowEnv(n *ast.ShowEnvNode) {
+	env := buildenv(shell.Environ())
+
+	sort.Strings(env)
+
+	for _, e := range env {
+		fmt.Fprintf(shell.stdout, "%s\n", e)
+	}
+}
+
+// executeDump writes the session as a nash script to stdout or to
+// the file of the dump statement. Importing the script recreates the
+// variables and functions of the session. Binds are only written to
+// stdout, because the file can be imported by a non-interactive shell
+// and bindfn isn't allowed there.
+func (shell *Shell) executeDump(n *ast.DumpNode) error {
+	filename := n.Filename()
+
+	if filename == nil {
+		shell.dump(shell.stdout, true)
+		return nil
+	}
+
+	obj, err := shell.evalExpr(filename)
+	if err != nil {
+		return err
+	}
+
+	if obj.Type() != sh.StringType {
+		return errors.NewEvalError(shell.filename, n,
+			"dump expects a file name, but a %s was provided", obj.Type())
+	}
+
+	var out bytes.Buffer
+
+	shell.dump(&out, false)
+
+	err = ioutil.WriteFile(obj.String(), out.Bytes(), 0644)
+	if err != nil {
+		return errors.NewEvalError(shell.filename, n, "%s", err.Error())
+	}
+
+	return nil
+}
+
+// processVars are the variables set by the shell itself when it
+// starts. They describe the running process, then they aren't dumped
+// to not overwrite the values of the shell importing the dump. The
+// _ variable discards values, then it is never dumped either.
+var processVars = map[string]bool{
+	"PID":   true,
+	"PWD":   true,
+	"SHELL": true,
+	"argv":  true,
+	"ARGS":  true,
+	"_":     true,
+}
+
+// dump writes the environment, variables, functions and, if withBinds
+// is set, the binds visible from shell as nash code. Variables with
+// functions, as imported modules, builtin functions, the variables of
+// the process and the values inherited from the environment of the
+// process aren't dumped.
+func (shell *Shell) dump(out io.Writer, withBinds bool) {
+	var (
+		env   = shell.Environ()
+		vars  = make(map[string]sh.Obj)
+		binds = make(map[string]sh.FnDef)
+		inits Var
+	)
+
+	for scope := shell; scope != nil; scope = scope.parent {
+		inits = scope.inits
+
+		for name, value := range scope.vars {
+			if _, ok := vars[name]; !ok {
+				vars[name] = value
+			}
+		}
+
+		for name, fnDef := range scope.binds {
+			if _, ok := binds[name]; !ok {
+				binds[name] = fnDef
+			}
+		}
+	}
+
+	for _, name := range sortedNames(env) {
+		if processVars[name] || env[name] == inits[name] {
+			continue
+		}
+
+		if _, ok := objExpr(env[name]); ok {
+			printVar(out, name, env[name])
+			printEnv(out, name)
+		}
+	}
+
+	for _, name := range sortedNames(vars) {
+		if processVars[name] || vars[name] == inits[name] {
+			continue
+		}
+
+		if value := vars[name]; value != env[name] {
+			printVar(out, name, value)
+		}
+	}
+
+	for _, name := range sortedNames(vars) {
+		fnObj, ok := vars[name].(*sh.FnObj)
+		if !ok {
+			continue
+		}
+
+		if userFn, ok := fnObj.Fn().(*userFnDef); ok {
+			printFn(out, name, userFn)
+		}
+	}
+
+	if !withBinds {
+		return
+	}
+
+	cmdNames := make([]string, 0, len(binds))
+
+	for name := range binds {
+		cmdNames = append(cmdNames, name)
+	}
+
+	sort.Strings(cmdNames)
+
+	for _, name := range cmdNames {
+		fmt.Fprintf(out, "bindfn %s %s\n", binds[name].Name(), name)
+	}
+}
+
+// printFn prints the declaration of the user function as nash code.
+func printFn(out io.Writer, name string, fn *userFnDef) {
+	var info token.FileInfo
+
+	fnDecl := ast.NewFnDeclNode(info, name)
+
+	for _, arg := range fn.ArgNames() {
+		argNode := ast.NewFnArgNode(info, arg.Name, arg.IsVariadic)
+		argNode.Default = arg.Default
+		argNode.ArgType = arg.Type
+		fnDecl.AddArg(argNode)
+	}
+
+	fnDecl.SetReturnTypes(fn.returnTypes)
+
+	fnDecl.SetTree(fn.Body)
+
+	fmt.Fprintf(out, "%s\n", fnDecl)
+}
+
+func sortedNames(vars map[string]sh.Obj) []string {
+	names := make([]string, 0, len(vars))
+
+	for name := range vars {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/madlambda/nash/ast"
 	"github.com/madlambda/nash/errors"
@@ -16,6 +17,12 @@ type (
 		IsVariadic bool
 	}
 
+	// NamedArg is an argument passed by name to a function.
+	NamedArg struct {
+		Name  string
+		Value sh.Obj
+	}
+
 	UserFn struct {
 		argNames []sh.FnArg // argNames store parameter name
 		done     chan error // for async execution
@@ -30,8 +37,11 @@ type (
 		stdin          io.Reader
 		stdout, stderr io.Writer
 
+		returnTypes []string
+
 		body           *ast.Tree
 		repr           string
+		closeAfterRun  []io.Closer
 		closeAfterWait []io.Closer
 	}
 )
@@ -49,6 +59,7 @@ func NewUserFn(name string, args []sh.FnArg, body *ast.Tree, parent *Shell) *Use
 		subshell: NewSubShell(name, parent),
 	}
 
+	fn.subshell.userFn = fn
 	fn.subshell.SetTree(fn.body)
 	fn.subshell.SetRepr(fn.repr)
 	fn.subshell.SetDebug(fn.parent.debug)
@@ -66,9 +77,18 @@ func (fn *UserFn) AddArgName(arg sh.FnArg) {
 }
 
 func (fn *UserFn) SetArgs(args []sh.Obj) error {
+	return fn.SetNamedArgs(args, nil)
+}
+
+// SetNamedArgs sets the positional arguments args and the arguments
+// passed by name. Optional arguments not given are initialized with
+// their default value, evaluated in the function scope.
+func (fn *UserFn) SetNamedArgs(args []sh.Obj, named []NamedArg) error {
 	var (
 		isVariadic      bool
 		countNormalArgs int
+		countRequired   int
+		values          = make([]sh.Obj, len(fn.argNames))
 	)
 
 	for i, argName := range fn.argNames {
@@ -79,64 +99,209 @@ func (fn *UserFn) SetArgs(args []sh.Obj) error {
 			isVariadic = true
 		} else {
 			countNormalArgs++
+
+			if argName.Default == nil {
+				countRequired++
+			}
 		}
 	}
 
-	if !isVariadic && len(args) != len(fn.argNames) {
-		return errors.NewError("Wrong number of arguments for function %s. "+
-			"Expected %d but found %d",
-			fn.name, len(fn.argNames), len(args))
+	if (!isVariadic && len(args) > countNormalArgs) ||
+		(len(named) == 0 && len(args) < countRequired) {
+		return fn.wrongNumberOfArgs(len(args), countRequired,
+			countNormalArgs, isVariadic)
+	}
+
+	valist := []sh.Obj{}
+
+	for i, arg := range args {
+		if i < countNormalArgs {
+			values[i] = arg
+		} else {
+			valist = append(valist, arg)
+		}
 	}
 
 	if isVariadic {
-		if len(args) < countNormalArgs {
-			return errors.NewError("Wrong number of arguments for function %s. "+
-				"Expected at least %d arguments but found %d", fn.name,
-				countNormalArgs, len(args))
+		values[countNormalArgs] = sh.NewListObj(valist)
+	}
+
+	for _, arg := range named {
+		i := fn.argIndex(arg.Name)
+
+		if i < 0 {
+			return errors.NewError("Unknown argument '%s' for function %s",
+				arg.Name, fn.signature())
+		}
+
+		if fn.argNames[i].IsVariadic {
+			return errors.NewError("Variadic argument '%s' of function %s can't be passed by name",
+				arg.Name, fn.signature())
 		}
 
-		if len(args) == 0 {
-			// there's only a variadic (optional) argument
-			// and user supplied no argument...
-			// then only initialize the variadic variable to
-			// empty list
-			fn.subshell.Newvar(fn.argNames[0].Name, sh.NewListObj([]sh.Obj{}))
-			return nil
+		if values[i] != nil {
+			return errors.NewError("Argument '%s' of function %s given twice",
+				arg.Name, fn.signature())
 		}
+
+		values[i] = arg.Value
 	}
 
-	var i int
-	for i = 0; i < len(fn.argNames) && i < len(args); i++ {
-		arg := args[i]
-		argName := fn.argNames[i].Name
-		isVariadic := fn.argNames[i].IsVariadic
+	// arguments are set in order, then the default value of an
+	// optional argument can refer to the arguments before it.
+	for i, argName := range fn.argNames {
+		value := values[i]
 
-		if isVariadic {
-			var valist []sh.Obj
-			for ; i < len(args); i++ {
-				arg = args[i]
-				valist = append(valist, arg)
+		if value == nil {
+			if argName.Default == nil {
+				return errors.NewError("Missing argument '%s' for function %s",
+					argName.Name, fn.signature())
+			}
+
+			var err error
+
+			value, err = fn.subshell.evalExpr(argName.Default)
+			if err != nil {
+				return err
 			}
-			valistarg := sh.NewListObj(valist)
-			fn.subshell.Newvar(argName, valistarg)
-		} else {
-			fn.subshell.Newvar(argName, arg)
 		}
+
+		err := fn.checkArgType(argName, value)
+		if err != nil {
+			return err
+		}
+
+		fn.subshell.Newvar(argName.Name, value)
+	}
+
+	return nil
+}
+
+// checkArgType checks value against the type annotation of the
+// argument. Each value of a variadic argument is checked.
+func (fn *UserFn) checkArgType(arg sh.FnArg, value sh.Obj) error {
+	if arg.Type == "" {
+		return nil
+	}
+
+	values := []sh.Obj{value}
+
+	if arg.IsVariadic {
+		values = value.(*sh.ListObj).List()
 	}
 
-	// set remaining (variadic) list
-	if len(fn.argNames) > 0 && i < len(fn.argNames) {
-		last := fn.argNames[len(fn.argNames)-1]
-		if !last.IsVariadic {
-			return errors.NewError("internal error: optional arguments only for variadic parameter")
+	for _, value := range values {
+		if !hasType(value, arg.Type) {
+			return errors.NewError("Wrong type for argument '%s' of function %s. "+
+				"Expected %s but found %s", arg.Name, fn.signature(),
+				arg.Type, typeName(value))
 		}
+	}
 
-		fn.subshell.Newvar(last.Name, sh.NewListObj([]sh.Obj{}))
+	return nil
+}
+
+// checkReturns checks the values returned by the function against
+// its return types, if declared.
+func (fn *UserFn) checkReturns(values []sh.Obj) error {
+	if len(fn.returnTypes) == 0 {
+		return nil
+	}
+
+	if len(values) != len(fn.returnTypes) {
+		return errors.NewError("Wrong number of return values for function %s. "+
+			"Expected %d but found %d", fn.signature(),
+			len(fn.returnTypes), len(values))
+	}
+
+	for i, value := range values {
+		if !hasType(value, fn.returnTypes[i]) {
+			return errors.NewError("Wrong type for return value %d of function %s. "+
+				"Expected %s but found %s", i+1, fn.signature(),
+				fn.returnTypes[i], typeName(value))
+		}
 	}
 
 	return nil
 }
 
+func (fn *UserFn) wrongNumberOfArgs(found, required, max int, isVariadic bool) error {
+	if isVariadic {
+		return errors.NewError("Wrong number of arguments for function %s. "+
+			"Expected at least %d arguments but found %d", fn.signature(),
+			required, found)
+	}
+
+	if required == max {
+		return errors.NewError("Wrong number of arguments for function %s. "+
+			"Expected %d but found %d", fn.signature(), required, found)
+	}
+
+	return errors.NewError("Wrong number of arguments for function %s. "+
+		"Expected %d to %d arguments but found %d", fn.signature(),
+		required, max, found)
+}
+
+// argIndex returns the position of the argument name or -1 if the
+// function has no such argument.
+func (fn *UserFn) argIndex(name string) int {
+	for i, argName := range fn.argNames {
+		if argName.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// signature returns the function declaration as written by the user,
+// eg.: deploy(env, region = "us-east-1")
+func (fn *UserFn) signature() string {
+	params := make([]string, 0, len(fn.argNames))
+
+	for _, arg := range fn.argNames {
+		param := arg.Name
+
+		if arg.IsVariadic {
+			param += "..."
+		}
+
+		if arg.Type != "" {
+			param += ": " + arg.Type
+		}
+
+		if arg.Default != nil {
+			param += " = " + arg.Default.String()
+		}
+
+		params = append(params, param)
+	}
+
+	signature := fn.name + "(" + strings.Join(params, ", ") + ")"
+
+	if len(fn.returnTypes) > 0 {
+		signature += ": " + strings.Join(fn.returnTypes, ", ")
+	}
+
+	return signature
+}
+
+// setFnArgs sets the positional and named arguments of fn. Only user
+// functions accept arguments passed by name.
+func setFnArgs(fn sh.Fn, args []sh.Obj, named []NamedArg) error {
+	if len(named) == 0 {
+		return fn.SetArgs(args)
+	}
+
+	userFn, ok := fn.(*UserFn)
+	if !ok {
+		return errors.NewError("Function %s doesn't accept arguments by name",
+			fn.Name())
+	}
+
+	return userFn.SetNamedArgs(args, named)
+}
+
 func (fn *UserFn) Name() string { return fn.name }
 
 func (fn *UserFn) SetRepr(repr string) {
@@ -151,7 +316,13 @@ func (fn *UserFn) closeDescriptors(closers []io.Closer) {
 
 func (fn *UserFn) execute() ([]sh.Obj, error) {
 	if fn.body != nil {
-		return fn.subshell.ExecuteTree(fn.body)
+		results, err := fn.subshell.ExecuteTree(fn.body)
+		if err == nil && len(results) == 0 {
+			// the body finished without a return statement
+			err = fn.checkReturns(nil)
+		}
+
+		return results, fn.subshell.runDefers(err)
 	}
 
 	return nil, fmt.Errorf("fn not properly created")
@@ -161,6 +332,9 @@ func (fn *UserFn) Start() error {
 	go func() {
 		var err error
 		fn.results, err = fn.execute()
+
+		// signal EOF to the reader of the fn stdout pipe
+		fn.closeDescriptors(fn.closeAfterRun)
 		fn.done <- err
 	}()
 
@@ -183,14 +357,17 @@ func (fn *UserFn) SetEnviron(env []string) {
 
 func (fn *UserFn) SetStderr(w io.Writer) {
 	fn.stderr = w
+	fn.subshell.SetStderr(w)
 }
 
 func (fn *UserFn) SetStdout(w io.Writer) {
 	fn.stdout = w
+	fn.subshell.SetStdout(w)
 }
 
 func (fn *UserFn) SetStdin(r io.Reader) {
 	fn.stdin = r
+	fn.subshell.SetStdin(r)
 }
 
 func (fn *UserFn) Stdin() io.Reader  { return fn.stdin }
@@ -205,15 +382,49 @@ func (fn *UserFn) String() string {
 }
 
 func (fn *UserFn) StdoutPipe() (io.ReadCloser, error) {
+	return fn.pipe(fn.SetStdout)
+}
+
+func (fn *UserFn) StderrPipe() (io.ReadCloser, error) {
+	return fn.pipe(fn.SetStderr)
+}
+
+func (fn *UserFn) pipe(setOutput func(io.Writer)) (io.ReadCloser, error) {
 	pr, pw, err := os.Pipe()
 
 	if err != nil {
 		return nil, err
 	}
 
-	fn.subshell.SetStdout(pw)
+	setOutput(pw)
 
-	// As fn doesn't fork, both fd can be closed after wait is called
-	fn.closeAfterWait = append(fn.closeAfterWait, pw, pr)
+	// As fn doesn't fork, the write end must be closed when the
+	// body finishes, so the next stage of the pipe receives EOF.
+	fn.closeAfterRun = append(fn.closeAfterRun, pw)
+	fn.closeAfterWait = append(fn.closeAfterWait, pr)
 	return pr, nil
 }
+
+// typeName returns the name used in type annotations for the type of
+// obj.
+func typeName(obj sh.Obj) string {
+	switch obj.Type() {
+	case sh.StringType:
+		return "str"
+	case sh.IntType:
+		return "int"
+	case sh.ListType:
+		return "list"
+	case sh.MapType:
+		return "map"
+	case sh.FnType:
+		return "fn"
+	}
+
+	return obj.Type().String()
+}
+
+// hasType tells if obj has the type of the annotation typ.
+func hasType(obj sh.Obj, typ string) bool {
+	return typeName(obj) == typ
+}
@@ -16,6 +16,8 @@ type (
 		Body     *ast.Tree
 		argNames []sh.FnArg
 
+		returnTypes []string
+
 		stdin          io.Reader
 		stdout, stderr io.Writer
 		environ        []string
@@ -32,14 +34,16 @@ type (
 )
 
 // newFnDef creates a new function definition
-func newFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.Tree) (*fnDef, error) {
+func newFnDef(name string, parent *Shell, args []*ast.FnArgNode,
+	returnTypes []string, body *ast.Tree) (*fnDef, error) {
 	fn := fnDef{
-		name:   name,
-		Parent: parent,
-		Body:   body,
-		stdin:  parent.stdin,
-		stdout: parent.stdout,
-		stderr: parent.stderr,
+		name:        name,
+		Parent:      parent,
+		Body:        body,
+		returnTypes: returnTypes,
+		stdin:       parent.stdin,
+		stdout:      parent.stdout,
+		stderr:      parent.stderr,
 	}
 
 	for i := 0; i < len(args); i++ {
@@ -51,7 +55,19 @@ func newFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.Tree)
 				arg.String())
 		}
 
-		fn.argNames = append(fn.argNames, sh.FnArg{arg.Name, arg.IsVariadic})
+		if i > 0 && arg.Default == nil && !arg.IsVariadic &&
+			args[i-1].Default != nil {
+			return nil, errors.NewEvalError(parent.filename,
+				arg, "Argument '%s' without default value follows optional argument '%s'",
+				arg.Name, args[i-1].Name)
+		}
+
+		fn.argNames = append(fn.argNames, sh.FnArg{
+			Name:       arg.Name,
+			IsVariadic: arg.IsVariadic,
+			Default:    arg.Default,
+			Type:       arg.ArgType,
+		})
 	}
 	return &fn, nil
 }
@@ -80,8 +96,9 @@ func (fnDef *fnDef) Stdin() io.Reader  { return fnDef.stdin }
 func (fnDef *fnDef) Stdout() io.Writer { return fnDef.stdout }
 func (fnDef *fnDef) Stderr() io.Writer { return fnDef.stderr }
 
-func newUserFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.Tree) (*userFnDef, error) {
-	fnDef, err := newFnDef(name, parent, args, body)
+func newUserFnDef(name string, parent *Shell, args []*ast.FnArgNode,
+	returnTypes []string, body *ast.Tree) (*userFnDef, error) {
+	fnDef, err := newFnDef(name, parent, args, returnTypes, body)
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +110,7 @@ func newUserFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.T
 
 func (ufnDef *userFnDef) Build() sh.Fn {
 	userfn := NewUserFn(ufnDef.Name(), ufnDef.ArgNames(), ufnDef.Body, ufnDef.Parent)
+	userfn.returnTypes = ufnDef.returnTypes
 	userfn.SetStdin(ufnDef.stdin)
 	userfn.SetStdout(ufnDef.stdout)
 	userfn.SetStderr(ufnDef.stderr)
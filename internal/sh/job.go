@@ -0,0 +1,561 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/internal/sh/builtin"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// job is a command or pipe running in background or stopped.
+	job struct {
+		id   int
+		node ast.Node
+		pgrp *procGroup // nil if job control is disabled
+		done chan struct{}
+		stop chan struct{} // receives when the job stops
+
+		sync.Mutex
+		stopped bool
+
+		status sh.Obj
+		err    error
+	}
+
+	// jobTable stores the background jobs not waited yet. The table
+	// is shared by the shell and its subshells.
+	jobTable struct {
+		sync.Mutex
+
+		lastID int
+		jobs   map[int]*job
+	}
+
+	// waitFn is the wait builtin function. It waits for the jobs
+	// given as arguments or for every job if none is given.
+	waitFn struct {
+		jobs *jobTable
+		ids  []int
+	}
+
+	// jobsFn is the jobs builtin function. It lists the jobs of
+	// the table.
+	jobsFn struct {
+		jobs *jobTable
+	}
+
+	// fgFn is the fg builtin function. It continues a job in
+	// foreground and waits for it.
+	fgFn struct {
+		shell *Shell
+		ids   []int
+	}
+
+	// bgFn is the bg builtin function. It continues a stopped job
+	// in background.
+	bgFn struct {
+		shell *Shell
+		ids   []int
+	}
+)
+
+// newJob runs wait in a new goroutine. The processes of pg are
+// watched for stops.
+func newJob(node ast.Node, wait func() (sh.Obj, error), pg *procGroup) *job {
+	j := &job{
+		node: node,
+		pgrp: pg,
+		done: make(chan struct{}),
+		stop: make(chan struct{}, 1),
+	}
+
+	pg.watch(j.setStopped)
+
+	go func() {
+		j.status, j.err = wait()
+		close(j.done)
+	}()
+
+	return j
+}
+
+func (j *job) setStopped(stopped bool) {
+	j.Lock()
+	defer j.Unlock()
+
+	if stopped && !j.stopped {
+		select {
+		case j.stop <- struct{}{}:
+		default:
+		}
+	}
+
+	j.stopped = stopped
+}
+
+// state returns the state of the job as reported by the jobs
+// builtin.
+func (j *job) state() string {
+	select {
+	case <-j.done:
+		return "Done"
+	default:
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.stopped {
+		return "Stopped"
+	}
+
+	return "Running"
+}
+
+// resume sends SIGCONT to the processes of a stopped job.
+func (j *job) resume() error {
+	select {
+	case <-j.stop:
+	default:
+	}
+
+	j.setStopped(false)
+	return j.pgrp.resume()
+}
+
+func newJobTable() *jobTable {
+	return &jobTable{
+		jobs: make(map[int]*job),
+	}
+}
+
+// add adds the job to the table, allocating a new id for it if it
+// doesn't have one yet.
+func (t *jobTable) add(j *job) *job {
+	t.Lock()
+	defer t.Unlock()
+
+	if j.id == 0 {
+		t.lastID++
+		j.id = t.lastID
+	}
+
+	t.jobs[j.id] = j
+	return j
+}
+
+// get returns the job of the given ids. The ids must have at most
+// one job and the last job is returned if it's empty.
+func (t *jobTable) get(ids []int) (*job, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(ids) > 1 {
+		return nil, errors.NewError("expected one job, but received %d", len(ids))
+	}
+
+	if len(ids) == 0 {
+		var last *job
+
+		for _, j := range t.jobs {
+			if last == nil || j.id > last.id {
+				last = j
+			}
+		}
+
+		if last == nil {
+			return nil, errors.NewError("no current job")
+		}
+
+		return last, nil
+	}
+
+	j, ok := t.jobs[ids[0]]
+	if !ok {
+		return nil, errors.NewError("job %d not found", ids[0])
+	}
+
+	return j, nil
+}
+
+// list returns the jobs in the order they were started.
+func (t *jobTable) list() []*job {
+	t.Lock()
+	defer t.Unlock()
+
+	jobs := make([]*job, 0, len(t.jobs))
+
+	for _, j := range t.jobs {
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].id < jobs[k].id
+	})
+
+	return jobs
+}
+
+// remove removes the job from the table. If ids is empty, all jobs
+// are removed in the order they were started.
+func (t *jobTable) remove(ids []int) ([]*job, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(ids) == 0 {
+		for id := range t.jobs {
+			ids = append(ids, id)
+		}
+
+		sort.Ints(ids)
+	}
+
+	jobs := make([]*job, 0, len(ids))
+
+	for _, id := range ids {
+		j, ok := t.jobs[id]
+		if !ok {
+			return nil, errors.NewError("job %d not found", id)
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	for _, j := range jobs {
+		delete(t.jobs, j.id)
+	}
+
+	return jobs, nil
+}
+
+// reap removes the finished jobs from the table and returns them
+// in the order they were started.
+func (t *jobTable) reap() []*job {
+	t.Lock()
+	defer t.Unlock()
+
+	var done []*job
+
+	for _, j := range t.jobs {
+		select {
+		case <-j.done:
+			done = append(done, j)
+		default:
+		}
+	}
+
+	sort.Slice(done, func(i, k int) bool {
+		return done[i].id < done[k].id
+	})
+
+	for _, j := range done {
+		delete(t.jobs, j.id)
+	}
+
+	return done
+}
+
+// wait waits for the job to finish. Errors ignored with '-' (dash)
+// are only reported by the status.
+func (j *job) wait() (sh.Obj, error) {
+	type IgnoreError interface {
+		Ignore() bool
+	}
+
+	<-j.done
+
+	if errIgnore, ok := j.err.(IgnoreError); ok && errIgnore.Ignore() {
+		return j.status, nil
+	}
+
+	if j.err != nil {
+		return j.status, errors.NewError("job %d (%s) failed: %s",
+			j.id, j.node, j.err)
+	}
+
+	return j.status, nil
+}
+
+// SetJobControl enables or disables the job control of the shell.
+// The stdin of the shell must be a terminal. When enabled, every
+// command runs in its own process group and the foreground one
+// owns the terminal, then stopped commands can be continued with
+// the fg and bg builtins.
+func (shell *Shell) SetJobControl(enable bool) error {
+	if !enable {
+		shell.term = nil
+		return nil
+	}
+
+	stdin, ok := shell.stdin.(interface {
+		Fd() uintptr
+	})
+
+	if !ok {
+		return errors.NewError("job control requires a terminal")
+	}
+
+	term, err := newTerminal(int(stdin.Fd()))
+	if err != nil {
+		return err
+	}
+
+	shell.term = term
+
+	// the job control functions are also available as commands
+	for _, name := range []string{"jobs", "fg", "bg"} {
+		fnObj, err := shell.GetFn(name)
+		if err != nil {
+			return err
+		}
+
+		shell.Setbindfn(name, fnObj.Fn())
+	}
+
+	return nil
+}
+
+// NotifyJobs removes the background jobs that finished from the
+// table of jobs and reports them to the stderr of the shell. The
+// interactive shell calls it before each prompt.
+func (shell *Shell) NotifyJobs() {
+	for _, j := range shell.jobs.reap() {
+		fmt.Fprintf(shell.stderr, "[%d]+ Done\t%s\n", j.id, j.node)
+	}
+}
+
+// foreground waits for the job j to finish or stop while it owns
+// the terminal. A stopped job is added to the table of jobs, then
+// it can be continued later.
+func (shell *Shell) foreground(j *job) (sh.Obj, error) {
+	term := shell.terminal()
+
+	select {
+	case <-j.done:
+	case <-j.stop:
+	}
+
+	err := term.restore(j.pgrp)
+	if err != nil {
+		shell.logf("failed to restore the terminal: %s\n", err)
+	}
+
+	select {
+	case <-j.done:
+	default:
+		shell.jobs.add(j)
+
+		fmt.Fprintf(shell.stderr, "\n[%d] Stopped\t%s\n", j.id, j.node)
+		return sh.NewStrObj(strconv.Itoa(EStopped)), nil
+	}
+
+	if j.id != 0 {
+		shell.jobs.remove([]int{j.id})
+	}
+
+	if j.pgrp.interrupted() {
+		// the shell doesn't receive the CTRL-C sent to the
+		// terminal while the job is in foreground.
+		shell.Lock()
+
+		if shell.looping {
+			shell.setIntr(true)
+		}
+
+		shell.Unlock()
+	}
+
+	return j.status, j.err
+}
+
+// jobIDs parses the job ids given to the builtin function name. The
+// ids can be prefixed with '%' as in other shells.
+func jobIDs(name string, args []sh.Obj) ([]int, error) {
+	var ids []int
+
+	for _, arg := range args {
+		if arg.Type() == sh.IntType {
+			ids = append(ids, arg.(*sh.IntObj).Int())
+			continue
+		}
+
+		if arg.Type() != sh.StringType {
+			return nil, errors.NewError("%s expects jobs, but a %s was provided",
+				name, arg.Type())
+		}
+
+		idstr := arg.(*sh.StrObj).Str()
+
+		id, err := strconv.Atoi(strings.TrimPrefix(idstr, "%"))
+		if err != nil {
+			return nil, errors.NewError("%s: invalid job %q", name, idstr)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func newWaitConstructor(jobs *jobTable) builtin.Constructor {
+	return func() builtin.Fn {
+		return &waitFn{jobs: jobs}
+	}
+}
+
+func (w *waitFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("jobs", true),
+	}
+}
+
+// Run waits for the jobs and returns the status of the last one.
+// It fails if any of the jobs failed.
+func (w *waitFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	var status sh.Obj = sh.NewStrObj("0")
+
+	jobs, err := w.jobs.remove(w.ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		status, err = j.wait()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []sh.Obj{status}, nil
+}
+
+func (w *waitFn) SetArgs(args []sh.Obj) error {
+	ids, err := jobIDs("wait", args)
+	w.ids = ids
+	return err
+}
+
+func newJobsConstructor(jobs *jobTable) builtin.Constructor {
+	return func() builtin.Fn {
+		return &jobsFn{jobs: jobs}
+	}
+}
+
+func (j *jobsFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{}
+}
+
+func (j *jobsFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	for _, job := range j.jobs.list() {
+		fmt.Fprintf(out, "[%d] %s\t%s\n", job.id, job.state(), job.node)
+	}
+
+	return nil, nil
+}
+
+func (j *jobsFn) SetArgs(args []sh.Obj) error {
+	if len(args) > 0 {
+		return errors.NewError("jobs expects no arguments, but received %d",
+			len(args))
+	}
+
+	return nil
+}
+
+func newFgConstructor(shell *Shell) builtin.Constructor {
+	return func() builtin.Fn {
+		return &fgFn{shell: shell}
+	}
+}
+
+func (fg *fgFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("job", true),
+	}
+}
+
+// Run continues the job in foreground and returns its status.
+func (fg *fgFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	term := fg.shell.terminal()
+	if term == nil {
+		return nil, errors.NewError("fg: no job control")
+	}
+
+	j, err := fg.shell.jobs.get(fg.ids)
+	if err != nil {
+		return nil, errors.NewError("fg: %s", err)
+	}
+
+	fmt.Fprintf(out, "%s\n", j.node)
+
+	if j.state() != "Done" {
+		err = term.foreground(j.pgrp)
+		if err != nil {
+			return nil, errors.NewError("fg: %s", err)
+		}
+
+		err = j.resume()
+		if err != nil {
+			return nil, errors.NewError("fg: %s", err)
+		}
+	}
+
+	status, err := fg.shell.foreground(j)
+	return []sh.Obj{status}, err
+}
+
+func (fg *fgFn) SetArgs(args []sh.Obj) error {
+	ids, err := jobIDs("fg", args)
+	fg.ids = ids
+	return err
+}
+
+func newBgConstructor(shell *Shell) builtin.Constructor {
+	return func() builtin.Fn {
+		return &bgFn{shell: shell}
+	}
+}
+
+func (bg *bgFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("job", true),
+	}
+}
+
+// Run continues the stopped job in background.
+func (bg *bgFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	if bg.shell.terminal() == nil {
+		return nil, errors.NewError("bg: no job control")
+	}
+
+	j, err := bg.shell.jobs.get(bg.ids)
+	if err != nil {
+		return nil, errors.NewError("bg: %s", err)
+	}
+
+	if j.state() != "Stopped" {
+		return nil, errors.NewError("bg: job %d already in background", j.id)
+	}
+
+	err = j.resume()
+	if err != nil {
+		return nil, errors.NewError("bg: %s", err)
+	}
+
+	fmt.Fprintf(out, "[%d] %s\n", j.id, j.node)
+	return nil, nil
+}
+
+func (bg *bgFn) SetArgs(args []sh.Obj) error {
+	ids, err := jobIDs("bg", args)
+	bg.ids = ids
+	return err
+}
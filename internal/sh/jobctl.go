@@ -0,0 +1,33 @@
+// +build !linux
+
+package sh
+
+import (
+	"runtime"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// terminal is the controlling terminal of a shell with job
+	// control enabled.
+	terminal struct{}
+
+	// procGroup is the process group of the commands of a job.
+	procGroup struct{}
+)
+
+func newTerminal(fd int) (*terminal, error) {
+	return nil, errors.NewError("job control is not supported on %s", runtime.GOOS)
+}
+
+func (t *terminal) foreground(pg *procGroup) error { return nil }
+func (t *terminal) restore(pg *procGroup) error    { return nil }
+
+func newProcGroup(term *terminal, foreground bool) *procGroup { return nil }
+
+func (pg *procGroup) start(cmd sh.Runner) error       { return cmd.Start() }
+func (pg *procGroup) watch(notify func(stopped bool)) {}
+func (pg *procGroup) resume() error                   { return nil }
+func (pg *procGroup) interrupted() bool               { return false }
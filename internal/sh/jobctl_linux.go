@@ -0,0 +1,279 @@
+// +build linux
+
+package sh
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// terminal is the controlling terminal of a shell with job
+	// control enabled.
+	terminal struct {
+		fd    int
+		pgid  int // process group of the shell
+		attrs syscall.Termios
+	}
+
+	// procGroup is the process group of the commands of a job.
+	procGroup struct {
+		term       *terminal
+		foreground bool
+		pgid       int
+		cmds       []*Cmd
+
+		// attributes of the terminal when the job stopped
+		attrs *syscall.Termios
+	}
+)
+
+const (
+	pPID = 1 // idtype of waitid
+
+	cldStopped   = 5
+	cldContinued = 6
+
+	sigBlock   = 0
+	sigSetmask = 2
+)
+
+func newTerminal(fd int) (*terminal, error) {
+	term := &terminal{fd: fd}
+
+	err := term.ioctl(syscall.TCGETS, unsafe.Pointer(&term.attrs))
+	if err != nil {
+		return nil, errors.NewError("job control requires a terminal: %s", err)
+	}
+
+	// The shell must never be stopped by the terminal. The signals
+	// are caught instead of ignored because ignored signals are
+	// inherited by the commands.
+	signal.Notify(make(chan os.Signal, 1),
+		syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+
+	term.pgid = syscall.Getpgrp()
+
+	if term.pgid != syscall.Getpid() {
+		err = syscall.Setpgid(0, 0)
+		if err != nil {
+			return nil, errors.NewError("failed to create the process group of the shell: %s", err)
+		}
+
+		term.pgid = syscall.Getpid()
+	}
+
+	err = term.setForeground(term.pgid)
+	if err != nil {
+		return nil, errors.NewError("failed to own the terminal: %s", err)
+	}
+
+	return term, nil
+}
+
+// ioctl runs the request on the terminal with SIGTTOU blocked,
+// otherwise the shell is signaled when changing the terminal while
+// it's not in foreground.
+func (t *terminal) ioctl(req uintptr, arg unsafe.Pointer) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var set, old uint64 = 1 << (uint(syscall.SIGTTOU) - 1), 0
+
+	_, _, e := syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigBlock,
+		uintptr(unsafe.Pointer(&set)), uintptr(unsafe.Pointer(&old)), 8, 0, 0)
+	if e != 0 {
+		return e
+	}
+
+	_, _, e = syscall.Syscall(syscall.SYS_IOCTL, uintptr(t.fd), req, uintptr(arg))
+
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask,
+		uintptr(unsafe.Pointer(&old)), 0, 8, 0, 0)
+
+	if e != 0 {
+		return e
+	}
+
+	return nil
+}
+
+func (t *terminal) setForeground(pgid int) error {
+	pgrp := int32(pgid)
+	return t.ioctl(syscall.TIOCSPGRP, unsafe.Pointer(&pgrp))
+}
+
+// foreground gives the terminal to the process group pg, restoring
+// the attributes it had when stopped.
+func (t *terminal) foreground(pg *procGroup) error {
+	if pg == nil || pg.pgid == 0 {
+		return nil
+	}
+
+	if pg.attrs != nil {
+		err := t.ioctl(syscall.TCSETS, unsafe.Pointer(pg.attrs))
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.setForeground(pg.pgid)
+}
+
+// restore gives the terminal back to the shell after the process
+// group pg stopped or finished. The attributes of the terminal are
+// saved in pg and the ones of the shell are restored.
+func (t *terminal) restore(pg *procGroup) error {
+	if pg == nil || pg.pgid == 0 {
+		return nil
+	}
+
+	err := t.setForeground(t.pgid)
+	if err != nil {
+		return err
+	}
+
+	var attrs syscall.Termios
+
+	err = t.ioctl(syscall.TCGETS, unsafe.Pointer(&attrs))
+	if err != nil {
+		return err
+	}
+
+	pg.attrs = &attrs
+	return t.ioctl(syscall.TCSETS, unsafe.Pointer(&t.attrs))
+}
+
+// newProcGroup returns the process group of a new job or nil if job
+// control is disabled.
+func newProcGroup(term *terminal, foreground bool) *procGroup {
+	if term == nil {
+		return nil
+	}
+
+	return &procGroup{
+		term:       term,
+		foreground: foreground,
+	}
+}
+
+// start starts the command in the process group. The first command
+// started creates the group. Functions run in the shell process and
+// are not part of it.
+func (pg *procGroup) start(cmd sh.Runner) error {
+	c, ok := cmd.(*Cmd)
+	if pg == nil || !ok {
+		return cmd.Start()
+	}
+
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pgid:       pg.pgid,
+		Foreground: pg.foreground && pg.pgid == 0,
+		Ctty:       pg.term.fd,
+	}
+
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+
+	if pg.pgid == 0 {
+		pg.pgid = c.Process.Pid
+	}
+
+	pg.cmds = append(pg.cmds, c)
+	return nil
+}
+
+// watch notifies when any command of the group stops or continues.
+func (pg *procGroup) watch(notify func(stopped bool)) {
+	if pg == nil {
+		return
+	}
+
+	for _, c := range pg.cmds {
+		go watchProcess(c.Process.Pid, notify)
+	}
+}
+
+// resume sends SIGCONT to the process group.
+func (pg *procGroup) resume() error {
+	if pg == nil || pg.pgid == 0 {
+		return nil
+	}
+
+	return syscall.Kill(-pg.pgid, syscall.SIGCONT)
+}
+
+// interrupted returns true if any command of the finished group was
+// killed by SIGINT.
+func (pg *procGroup) interrupted() bool {
+	if pg == nil {
+		return false
+	}
+
+	for _, c := range pg.cmds {
+		if c.ProcessState == nil {
+			continue
+		}
+
+		status, ok := c.ProcessState.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() && status.Signal() == syscall.SIGINT {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchProcess notifies the stops and continues of the process pid
+// until it exits. The exit status is left to be collected by Wait.
+func watchProcess(pid int, notify func(stopped bool)) {
+	for {
+		code, err := waitid(pid, syscall.WEXITED|syscall.WSTOPPED|
+			syscall.WCONTINUED|syscall.WNOWAIT)
+
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+
+		switch code {
+		case cldStopped:
+			notify(true)
+
+			// consume the stop, otherwise it's reported again
+			waitid(pid, syscall.WSTOPPED|syscall.WNOHANG)
+		case cldContinued:
+			notify(false)
+			waitid(pid, syscall.WCONTINUED|syscall.WNOHANG)
+		default:
+			return
+		}
+	}
+}
+
+// waitid waits for a state change of the process pid and returns
+// the si_code of the siginfo filled by the kernel.
+func waitid(pid int, options int) (int32, error) {
+	var info [128]byte
+
+	_, _, e := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+		uintptr(unsafe.Pointer(&info[0])), uintptr(options), 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+
+	// si_code follows si_signo and si_errno
+	return *(*int32)(unsafe.Pointer(&info[8])), nil
+}
@@ -0,0 +1,253 @@
+package sh
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+// moduleTable stores the modules already imported by their absolute
+// path. The table is shared by the shell, its subshells and its
+// modules, then each file is imported as a module only once.
+type moduleTable struct {
+	sync.Mutex
+
+	modules map[string]sh.Obj
+}
+
+func newModuleTable() *moduleTable {
+	return &moduleTable{
+		modules: make(map[string]sh.Obj),
+	}
+}
+
+func (t *moduleTable) get(path string) (sh.Obj, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	mod, ok := t.modules[path]
+	return mod, ok
+}
+
+func (t *moduleTable) add(path string, mod sh.Obj) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.modules[path] = mod
+}
+
+// importChain returns the files being imported by the shell and its
+// parents, outermost first. The first element is the file that
+// started the imports.
+func (shell *Shell) importChain() []string {
+	var chain []string
+
+	if shell.parent != nil {
+		chain = shell.parent.importChain()
+	}
+
+	return append(chain, shell.importing...)
+}
+
+// realPath returns the absolute path of path with the symbolic links
+// resolved. Paths of files that don't exist are returned unchanged.
+func realPath(path string) string {
+	realpath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+
+	abspath, err := filepath.Abs(realpath)
+	if err != nil {
+		return path
+	}
+
+	return abspath
+}
+
+// isImported returns true if the file at path was already imported
+// in the scope of the shell or of its parents.
+func (shell *Shell) isImported(path string) bool {
+	if shell.imported[path] {
+		return true
+	}
+
+	if shell.parent != nil {
+		return shell.parent.isImported(path)
+	}
+
+	return false
+}
+
+// importFile imports the file at path found by the import statement
+// n. A file is executed only once in a scope and imported as a
+// module only once. Files importing each other are reported with
+// the chain of imports.
+func (shell *Shell) importFile(n *ast.ImportNode, path string) error {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return errors.NewEvalError(shell.filename, n, "%s", err.Error())
+	}
+
+	if realpath, err := filepath.EvalSymlinks(abspath); err == nil {
+		abspath = realpath
+	}
+
+	chain := shell.importChain()
+	importing := shell.importing
+
+	if len(chain) == 0 {
+		// the file being executed, or <interactive>, starts the chain
+		chain = []string{realPath(shell.filename)}
+		importing = chain
+	}
+
+	for _, imported := range chain {
+		if imported == abspath {
+			cycle := append(append([]string{}, chain...), abspath)
+
+			return errors.NewEvalError(shell.filename, n,
+				"import cycle not allowed: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if n.Name != "" {
+		return shell.importModule(n.Name, abspath, chain)
+	}
+
+	if shell.isImported(abspath) {
+		shell.logf("File '%s' already imported\n", abspath)
+		return nil
+	}
+
+	bkImporting := shell.importing
+	shell.importing = append(importing, abspath)
+
+	defer func() {
+		shell.importing = bkImporting
+	}()
+
+	err = shell.ExecFile(path)
+	if err != nil {
+		return err
+	}
+
+	shell.imported[abspath] = true
+	return nil
+}
+
+// newModuleShell creates the shell where the file of a qualified
+// import runs. The module shares the environment, the builtin
+// functions and the command bindings of shell, but its variables
+// are isolated from the importer.
+func (shell *Shell) newModuleShell(path string) *Shell {
+	root := shell
+
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	mod := NewSubShell(path, shell)
+	mod.parent = nil
+	mod.isFn = false
+	mod.filename = path
+	mod.env = root.env
+	mod.binds = root.binds
+	mod.inits = root.inits
+	mod.debug = root.debug
+	mod.logf = root.logf
+	mod.interactive = root.interactive
+	mod.nashpath = root.nashpath
+	mod.nashroot = root.nashroot
+
+	// environment variables are also variables of the root shell
+	for name, value := range root.env {
+		mod.vars[name] = value
+	}
+
+	mod.setupBuiltin()
+	return mod
+}
+
+// importModule runs the file at path in a new module and declares
+// the variable name with its functions. Functions named with the
+// module name as prefix, as in map_get of the file map.sh, are also
+// available without it, as in name.get. The chain has the files
+// being imported by the shell.
+func (shell *Shell) importModule(name, path string, chain []string) error {
+	if obj, ok := shell.modules.get(path); ok {
+		shell.Newvar(name, obj)
+		return nil
+	}
+
+	mod := shell.newModuleShell(path)
+	mod.importing = append(append([]string{}, chain...), path)
+
+	err := mod.ExecFile(path)
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "_"
+	fns := make(map[string]sh.Obj)
+
+	for fnName, obj := range mod.vars {
+		fnObj, ok := obj.(*sh.FnObj)
+		if !ok {
+			continue
+		}
+
+		if _, ok := fnObj.Fn().(*userFnDef); !ok {
+			// builtin functions are not part of the module
+			continue
+		}
+
+		fns[fnName] = obj
+	}
+
+	shortNames := make(map[string]sh.Obj)
+
+	for fnName, obj := range fns {
+		shortName := strings.TrimPrefix(fnName, prefix)
+
+		if _, ok := fns[shortName]; !ok && shortName != "" {
+			shortNames[shortName] = obj
+		}
+	}
+
+	for shortName, obj := range shortNames {
+		fns[shortName] = obj
+	}
+
+	obj := sh.NewMapObj(fns)
+
+	shell.modules.add(path, obj)
+	shell.Newvar(name, obj)
+	return nil
+}
+
+// getModuleFn returns the function fnName of the module imported as
+// name.
+func (shell *Shell) getModuleFn(name, fnName string) (*sh.FnObj, error) {
+	obj, ok := shell.Getvar(name)
+	if !ok {
+		return nil, errors.NewError("module '%s' not found", name)
+	}
+
+	mod, ok := obj.(*sh.MapObj)
+	if !ok {
+		return nil, errors.NewError("Identifier '%s' is not a module", name)
+	}
+
+	fnObj, ok := mod.Map()[fnName].(*sh.FnObj)
+	if !ok {
+		return nil, errors.NewError("function '%s' not found in module '%s'",
+			fnName, name)
+	}
+
+	return fnObj, nil
+}
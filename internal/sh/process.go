@@ -0,0 +1,460 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/internal/sh/builtin"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// process is a function spawned to run concurrently. Processes
+	// share no state and communicate only by messages sent to their
+	// mailboxes.
+	process struct {
+		pid string
+
+		sync.Mutex
+		mailbox []sh.Obj
+		notify  chan struct{} // receives when a message arrives
+	}
+
+	// procTable stores the processes running. The table is shared
+	// by every shell of the interpreter.
+	procTable struct {
+		sync.Mutex
+
+		lastPid int
+		procs   map[string]*process
+	}
+
+	// sendFn is the send builtin function. It sends a message to
+	// the mailbox of a process.
+	sendFn struct {
+		procs *procTable
+		pid   string
+		msg   sh.Obj
+	}
+
+	// receiveFn is the receive builtin function. It waits for a
+	// message in the mailbox of the process calling it.
+	receiveFn struct {
+		proc       *process
+		timeout    time.Duration
+		hasTimeout bool
+	}
+
+	// selfFn is the self builtin function. It returns the pid of
+	// the process calling it.
+	selfFn struct {
+		proc *process
+	}
+)
+
+func newProcTable() *procTable {
+	return &procTable{
+		procs: make(map[string]*process),
+	}
+}
+
+// add creates a new process in the table.
+func (t *procTable) add() *process {
+	t.Lock()
+	defer t.Unlock()
+
+	t.lastPid++
+
+	p := &process{
+		pid:    strconv.Itoa(t.lastPid),
+		notify: make(chan struct{}, 1),
+	}
+
+	t.procs[p.pid] = p
+	return p
+}
+
+func (t *procTable) get(pid string) (*process, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	p, ok := t.procs[pid]
+	return p, ok
+}
+
+// remove removes the exited process p. Messages can't be sent to it
+// anymore.
+func (t *procTable) remove(p *process) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.procs, p.pid)
+}
+
+// send puts the message in the mailbox of the process.
+func (p *process) send(msg sh.Obj) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.mailbox = append(p.mailbox, msg)
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// receive waits for a message in the mailbox of the process. If
+// timeout is negative it waits forever, otherwise it returns false
+// when the timeout expires.
+func (p *process) receive(timeout time.Duration) (sh.Obj, bool) {
+	var expired <-chan time.Time
+
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	for {
+		p.Lock()
+
+		if len(p.mailbox) > 0 {
+			msg := p.mailbox[0]
+			p.mailbox = p.mailbox[1:]
+			p.Unlock()
+
+			return msg, true
+		}
+
+		p.Unlock()
+
+		select {
+		case <-p.notify:
+		case <-expired:
+			return nil, false
+		}
+	}
+}
+
+// copyObj returns a deep copy of obj. User functions are declared
+// again in the scope of shell, then calling them doesn't change the
+// state of their original scope.
+func copyObj(obj sh.Obj, shell *Shell) sh.Obj {
+	switch o := obj.(type) {
+	case *sh.ListObj:
+		values := make([]sh.Obj, 0, o.Len())
+
+		for _, value := range o.List() {
+			values = append(values, copyObj(value, shell))
+		}
+
+		return sh.NewListObj(values)
+	case *sh.MapObj:
+		values := make(map[string]sh.Obj, o.Len())
+
+		for key, value := range o.Map() {
+			values[key] = copyObj(value, shell)
+		}
+
+		return sh.NewMapObj(values)
+	case *sh.FnObj:
+		userFn, ok := o.Fn().(*userFnDef)
+		if !ok {
+			// builtin functions have no state
+			return obj
+		}
+
+		fnDef := *userFn.fnDef
+		fnDef.Parent = shell
+		fnDef.stdin = shell.stdin
+		fnDef.stdout = shell.stdout
+		fnDef.stderr = shell.stderr
+
+		return sh.NewFnObj(&userFnDef{fnDef: &fnDef})
+	}
+
+	// strings and integers are immutable
+	return obj
+}
+
+// hasFn returns true if obj is a function or a collection with
+// functions.
+func hasFn(obj sh.Obj) bool {
+	switch o := obj.(type) {
+	case *sh.FnObj:
+		return true
+	case *sh.ListObj:
+		for _, value := range o.List() {
+			if hasFn(value) {
+				return true
+			}
+		}
+	case *sh.MapObj:
+		for _, value := range o.Map() {
+			if hasFn(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// newProcessShell creates the shell of a new process spawned by
+// shell. Every variable visible from shell is deep copied, then the
+// process shares no state with its spawner. The environment is
+// copied too, but changes are still visible to the commands of
+// other processes because the nash process has a single one.
+func (shell *Shell) newProcessShell() *Shell {
+	root := shell
+
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	proc := shell.procs.add()
+
+	procShell := NewSubShell("process "+proc.pid, shell)
+	procShell.parent = nil
+	procShell.isFn = false
+	procShell.proc = proc
+	procShell.traps = nil // signals are handled by the main process
+	procShell.modules = newModuleTable()
+	procShell.Mutex = &sync.Mutex{}
+	procShell.debug = root.debug
+	procShell.logf = root.logf
+	procShell.nashpath = root.nashpath
+	procShell.nashroot = root.nashroot
+
+	for name, value := range root.env {
+		procShell.env[name] = copyObj(value, procShell)
+	}
+
+	for scope := shell; scope != nil; scope = scope.parent {
+		for name, value := range scope.vars {
+			if _, ok := procShell.vars[name]; !ok {
+				procShell.vars[name] = copyObj(value, procShell)
+			}
+		}
+	}
+
+	procShell.setupProcessBuiltin()
+	return procShell
+}
+
+// setupProcessBuiltin declares the builtin functions bound to the
+// process of the shell.
+func (shell *Shell) setupProcessBuiltin() {
+	selfDef := newBuiltinFnDef("self", shell, newSelfConstructor(shell.proc))
+	shell.Newvar("self", sh.NewFnObj(selfDef))
+
+	receiveDef := newBuiltinFnDef("receive", shell, newReceiveConstructor(shell.proc))
+	shell.Newvar("receive", sh.NewFnObj(receiveDef))
+}
+
+// executeSpawn runs the function invocation of n in a new process
+// and returns its pid. The arguments are evaluated by the spawner
+// and deep copied to the process.
+func (shell *Shell) executeSpawn(n *ast.SpawnNode) (sh.Obj, error) {
+	var (
+		fnDef sh.FnDef
+		err   error
+	)
+
+	fnInv := n.FnInv()
+
+	args, err := shell.evalArgExprs(fnInv.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	named, err := shell.evalNamedArgs(fnInv.NamedArgs())
+	if err != nil {
+		return nil, err
+	}
+
+	procShell := shell.newProcessShell()
+	proc := procShell.proc
+
+	if fnDecl := n.FnDecl(); fnDecl != nil {
+		fnDef, err = newUserFnDef(fnDecl.Name(), procShell,
+			fnDecl.Args(), fnDecl.ReturnTypes(), fnDecl.Tree())
+	} else {
+		fnDef, err = procShell.getFnDef(fnInv)
+	}
+
+	if err != nil {
+		shell.procs.remove(proc)
+		return nil, err
+	}
+
+	for i, arg := range args {
+		args[i] = copyObj(arg, procShell)
+	}
+
+	for i, arg := range named {
+		named[i].Value = copyObj(arg.Value, procShell)
+	}
+
+	fn := fnDef.Build()
+
+	err = setFnArgs(fn, args, named)
+	if err != nil {
+		shell.procs.remove(proc)
+		return nil, errors.NewEvalError(shell.filename,
+			n, "%s", err.Error())
+	}
+
+	fn.SetStdin(procShell.stdin)
+	fn.SetStdout(procShell.stdout)
+	fn.SetStderr(procShell.stderr)
+
+	err = fn.Start()
+	if err != nil {
+		shell.procs.remove(proc)
+		return nil, errors.NewEvalError(shell.filename,
+			n, "%s", err.Error())
+	}
+
+	go func() {
+		err := fn.Wait()
+		if err != nil {
+			fmt.Fprintf(procShell.stderr, "process %s failed: %s\n",
+				proc.pid, err)
+		}
+
+		shell.procs.remove(proc)
+	}()
+
+	shell.logf("Spawned process %s: %s\n", proc.pid, n)
+	return sh.NewStrObj(proc.pid), nil
+}
+
+func newSendConstructor(procs *procTable) builtin.Constructor {
+	return func() builtin.Fn {
+		return &sendFn{procs: procs}
+	}
+}
+
+func (s *sendFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("pid", false),
+		sh.NewFnArg("msg", false),
+	}
+}
+
+// Run sends the message and returns "0" if it was delivered or "1"
+// if the process doesn't exist.
+func (s *sendFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	p, ok := s.procs.get(s.pid)
+	if !ok {
+		return []sh.Obj{sh.NewStrObj("1")}, nil
+	}
+
+	p.send(copyObj(s.msg, nil))
+	return []sh.Obj{sh.NewStrObj("0")}, nil
+}
+
+func (s *sendFn) SetArgs(args []sh.Obj) error {
+	if len(args) != 2 {
+		return errors.NewError("send expects a pid and a message, but received %d arguments",
+			len(args))
+	}
+
+	if args[0].Type() != sh.StringType {
+		return errors.NewError("send expects a pid, but a %s was provided",
+			args[0].Type())
+	}
+
+	if hasFn(args[1]) {
+		return errors.NewError("send: functions can't be sent to processes")
+	}
+
+	s.pid = args[0].String()
+	s.msg = args[1]
+	return nil
+}
+
+func newReceiveConstructor(proc *process) builtin.Constructor {
+	return func() builtin.Fn {
+		return &receiveFn{proc: proc}
+	}
+}
+
+func (r *receiveFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("timeout", true),
+	}
+}
+
+// Run returns the next message in the mailbox. With a timeout it
+// also returns "0" if a message was received or "1" if the timeout
+// expired, in which case the message is empty.
+func (r *receiveFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	if !r.hasTimeout {
+		msg, _ := r.proc.receive(-1)
+		return []sh.Obj{msg}, nil
+	}
+
+	msg, ok := r.proc.receive(r.timeout)
+	if !ok {
+		return []sh.Obj{sh.NewStrObj(""), sh.NewStrObj("1")}, nil
+	}
+
+	return []sh.Obj{msg, sh.NewStrObj("0")}, nil
+}
+
+func (r *receiveFn) SetArgs(args []sh.Obj) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	if len(args) > 1 {
+		return errors.NewError("receive expects a timeout, but received %d arguments",
+			len(args))
+	}
+
+	if args[0].Type() != sh.StringType {
+		return errors.NewError("receive expects a timeout, but a %s was provided",
+			args[0].Type())
+	}
+
+	timeout, err := time.ParseDuration(args[0].String())
+	if err != nil || timeout < 0 {
+		return errors.NewError("receive: invalid timeout %q", args[0].String())
+	}
+
+	r.timeout = timeout
+	r.hasTimeout = true
+	return nil
+}
+
+func newSelfConstructor(proc *process) builtin.Constructor {
+	return func() builtin.Fn {
+		return &selfFn{proc: proc}
+	}
+}
+
+func (s *selfFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{}
+}
+
+func (s *selfFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	return []sh.Obj{sh.NewStrObj(s.proc.pid)}, nil
+}
+
+func (s *selfFn) SetArgs(args []sh.Obj) error {
+	if len(args) > 0 {
+		return errors.NewError("self expects no arguments, but received %d",
+			len(args))
+	}
+
+	return nil
+}
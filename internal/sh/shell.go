@@ -1,6 +1,7 @@
 package sh
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -26,6 +28,10 @@ import (
 const (
 	logNS     = "nashell.Shell"
 	defPrompt = "\033[31mλ>\033[0m "
+
+	// flags used to open files of output redirections
+	redirFileFlags   = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	redirAppendFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
 )
 
 type (
@@ -45,7 +51,8 @@ type (
 		logf        LogFn
 		nashdPath   string
 		isFn        bool
-		filename    string // current file being executed or imported
+		userFn      *UserFn // function executed by the shell, if any
+		filename    string  // current file being executed or imported
 
 		sigs        chan os.Signal
 		interrupted bool
@@ -58,10 +65,22 @@ type (
 		env   Env
 		vars  Var
 		binds Fns
+		inits Var // values of the variables when the shell started
+		jobs  *jobTable
+		term  *terminal // nil if job control is disabled
+		procs *procTable
+		proc  *process   // process running the shell
+		traps *trapTable // nil if signals are not handled by the shell
 
 		root   *ast.Tree
 		parent *Shell
 
+		defers []deferred // statements run when the function returns
+
+		imported  map[string]bool // files imported in the scope
+		importing []string        // files being imported, outermost first
+		modules   *moduleTable
+
 		repr string // string representation
 
 		nashpath string
@@ -70,6 +89,13 @@ type (
 		*sync.Mutex
 	}
 
+	// deferred is a statement of a defer. The scope has the
+	// variables of the function when the statement was deferred.
+	deferred struct {
+		scope *Shell
+		stmt  ast.Node
+	}
+
 	errIgnore struct {
 		*errors.NashError
 	}
@@ -81,11 +107,27 @@ type (
 	errStopWalking struct {
 		*errors.NashError
 	}
+
+	errBreak struct {
+		*errors.NashError
+	}
+
+	errContinue struct {
+		*errors.NashError
+	}
+
+	// errReturnType is a return statement not matching the return
+	// types of the function. It already has the position of the
+	// statement, then the callers of the function don't add theirs.
+	errReturnType struct {
+		*errors.NashError
+	}
 )
 
 const (
 	ESuccess    StatusCode = 0
 	ENotFound              = 127
+	EStopped               = 148 // 128 + SIGTSTP
 	ENotStarted            = 255
 )
 
@@ -115,6 +157,28 @@ func newErrStopWalking() *errStopWalking {
 
 func (e *errStopWalking) StopWalking() bool { return true }
 
+func newErrBreak() *errBreak {
+	return &errBreak{
+		NashError: errors.NewError("break"),
+	}
+}
+
+func (e *errBreak) Break() bool { return true }
+
+func newErrContinue() *errContinue {
+	return &errContinue{
+		NashError: errors.NewError("continue"),
+	}
+}
+
+func (e *errContinue) Continue() bool { return true }
+
+func newErrReturnType(path string, n ast.Node, err error) error {
+	return &errReturnType{
+		NashError: errors.NewEvalError(path, n, "%s", err.Error()),
+	}
+}
+
 func NewAbortShell(nashpath string, nashroot string) (*Shell, error) {
 	return newShell(nashpath, nashroot, true)
 }
@@ -127,6 +191,8 @@ func NewShell(nashpath string, nashroot string) (*Shell, error) {
 }
 
 func newShell(nashpath string, nashroot string, abort bool) (*Shell, error) {
+	procs := newProcTable()
+
 	shell := &Shell{
 		name:        "parent scope",
 		interactive: false,
@@ -140,6 +206,12 @@ func newShell(nashpath string, nashroot string, abort bool) (*Shell, error) {
 		env:         make(Env),
 		vars:        make(Var),
 		binds:       make(Fns),
+		jobs:        newJobTable(),
+		traps:       newTrapTable(),
+		modules:     newModuleTable(),
+		imported:    make(map[string]bool),
+		procs:       procs,
+		proc:        procs.add(),
 		Mutex:       &sync.Mutex{},
 		sigs:        make(chan os.Signal, 1),
 		filename:    "<interactive>",
@@ -186,6 +258,12 @@ func NewSubShell(name string, parent *Shell) *Shell {
 		env:       make(Env),
 		vars:      make(Var),
 		binds:     make(Fns),
+		jobs:      parent.jobs,
+		traps:     parent.traps,
+		modules:   parent.modules,
+		imported:  make(map[string]bool),
+		procs:     parent.procs,
+		proc:      parent.proc,
 		Mutex:     parent.Mutex,
 		filename:  parent.filename,
 	}
@@ -272,6 +350,16 @@ func (shell *Shell) SetInteractive(i bool) {
 	}
 }
 
+// terminal returns the terminal of the shell or nil if job control
+// is disabled.
+func (shell *Shell) terminal() *terminal {
+	if shell.parent != nil {
+		return shell.parent.terminal()
+	}
+
+	return shell.term
+}
+
 func (shell *Shell) Interactive() bool {
 	if shell.parent != nil {
 		return shell.parent.Interactive()
@@ -358,6 +446,11 @@ func (shell *Shell) Getvar(name string) (sh.Obj, bool) {
 // GetFn returns the function name or error if not found.
 func (shell *Shell) GetFn(name string) (*sh.FnObj, error) {
 	shell.logf("Looking for function '%s' on shell '%s'\n", name, shell.name)
+
+	if i := strings.Index(name, "."); i > 0 {
+		return shell.getModuleFn(name[:i], name[i+1:])
+	}
+
 	if obj, ok := shell.vars[name]; ok {
 		if obj.Type() == sh.FnType {
 			fnObj := obj.(*sh.FnObj)
@@ -457,6 +550,31 @@ func (shell *Shell) setupBuiltin() {
 		fnDef := newBuiltinFnDef(name, shell, constructor)
 		shell.Newvar(name, sh.NewFnObj(fnDef))
 	}
+
+	// the job control functions need the shell
+	jobFns := map[string]builtin.Constructor{
+		"wait": newWaitConstructor(shell.jobs),
+		"jobs": newJobsConstructor(shell.jobs),
+		"fg":   newFgConstructor(shell),
+		"bg":   newBgConstructor(shell),
+	}
+
+	for name, constructor := range jobFns {
+		fnDef := newBuiltinFnDef(name, shell, constructor)
+		shell.Newvar(name, sh.NewFnObj(fnDef))
+	}
+
+	sendDef := newBuiltinFnDef("send", shell, newSendConstructor(shell.procs))
+	shell.Newvar("send", sh.NewFnObj(sendDef))
+
+	trapDef := newBuiltinFnDef("trap", shell, newTrapConstructor(shell.traps))
+	shell.Newvar("trap", sh.NewFnObj(trapDef))
+
+	// exit must run the function trapping EXIT
+	exitDef := newBuiltinFnDef("exit", shell, newExitConstructor(shell.traps))
+	shell.Newvar("exit", sh.NewFnObj(exitDef))
+
+	shell.setupProcessBuiltin()
 }
 
 func (shell *Shell) setupDefaultBindings() error {
@@ -499,6 +617,12 @@ func (shell *Shell) setup() error {
 		shell.Newvar("_", sh.NewStrObj(""))
 	}
 
+	shell.inits = make(Var, len(shell.vars))
+
+	for name, value := range shell.vars {
+		shell.inits[name] = value
+	}
+
 	shell.setupBuiltin()
 	return err
 }
@@ -516,7 +640,7 @@ func (shell *Shell) setupSignals() {
 
 				// TODO(i4k): Review implementation when interrupted inside
 				// function loops
-				if shell.looping {
+				if shell.looping && !shell.traps.trapped("INT") {
 					shell.setIntr(true)
 				}
 
@@ -604,6 +728,28 @@ func (shell *Shell) newvar(name *ast.NameNode, value sh.Obj) error {
 			name, "Variable %s not found", name.Ident)
 	}
 
+	err := shell.setIndexedValue(name, obj, value)
+	if err != nil {
+		return err
+	}
+
+	shell.Newvar(name.Ident, obj)
+	return nil
+}
+
+// setIndexedValue handles the assignment of ident[x] = v, where obj is
+// the value of ident (a list or a map).
+func (shell *Shell) setIndexedValue(name *ast.NameNode, obj, value sh.Obj) error {
+	if objmap, ok := obj.(*sh.MapObj); ok {
+		key, err := shell.evalMapKey(name.Index)
+		if err != nil {
+			return err
+		}
+
+		objmap.Set(key, value)
+		return nil
+	}
+
 	index, err := shell.evalIndex(name.Index)
 	if err != nil {
 		return err
@@ -624,7 +770,6 @@ func (shell *Shell) newvar(name *ast.NameNode, value sh.Obj) error {
 		)
 	}
 
-	shell.Newvar(name.Ident, obj)
 	return nil
 }
 
@@ -644,26 +789,11 @@ func (shell *Shell) setvar(name *ast.NameNode, value sh.Obj) error {
 			name, "Variable %s not found", name.Ident)
 	}
 
-	index, err := shell.evalIndex(name.Index)
+	err := shell.setIndexedValue(name, obj, value)
 	if err != nil {
 		return err
 	}
 
-	col, err := sh.NewWriteableCollection(obj)
-	if err != nil {
-		return errors.NewEvalError(shell.filename, name, err.Error())
-	}
-
-	err = col.Set(index, value)
-	if err != nil {
-		return errors.NewEvalError(
-			shell.filename,
-			name,
-			"error[%s] setting var",
-			err,
-		)
-	}
-
 	if !shell.Setvar(name.Ident, obj) {
 		return errors.NewEvalError(shell.filename,
 			name, "Variable '%s' is not initialized. Use 'var %s = <value>'",
@@ -734,79 +864,206 @@ func (shell *Shell) newcmdvars(names []*ast.NameNode, stdout, stderr, status sh.
 }
 
 // evalConcat reveives the AST representation of a concatenation of objects and
-// returns the string representation, or error.
-func (shell *Shell) evalConcat(path ast.Expr) (string, error) {
-	var pathStr string
-
+// returns the concatenated string or, if all of the parts are integers,
+// their sum.
+func (shell *Shell) evalConcat(path ast.Expr) (sh.Obj, error) {
 	if path.Type() != ast.NodeConcatExpr {
-		return "", fmt.Errorf("Invalid node %+v", path)
+		return nil, fmt.Errorf("Invalid node %+v", path)
 	}
 
 	concatExpr := path.(*ast.ConcatExpr)
 	concat := concatExpr.List()
+	parts := make([]sh.Obj, 0, len(concat))
 
 	for i := 0; i < len(concat); i++ {
 		part := concat[i]
 
 		switch part.Type() {
 		case ast.NodeConcatExpr:
-			return "", errors.NewEvalError(shell.filename, part,
+			return nil, errors.NewEvalError(shell.filename, part,
 				"Nested concat is not allowed: %s", part)
 		case ast.NodeVarExpr, ast.NodeIndexExpr:
 			partValue, err := shell.evalVariable(part)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 
 			if partValue.Type() == sh.ListType {
-				return "", errors.NewEvalError(shell.filename,
+				return nil, errors.NewEvalError(shell.filename,
 					part, "Concat of list variables is not allowed: %v = %v",
 					part, partValue)
-			} else if partValue.Type() != sh.StringType {
-				return "", errors.NewEvalError(shell.filename, part,
+			} else if partValue.Type() != sh.StringType &&
+				partValue.Type() != sh.IntType {
+				return nil, errors.NewEvalError(shell.filename, part,
 					"Invalid concat element: %v", partValue)
 			}
 
-			strval := partValue.(*sh.StrObj)
-			pathStr += strval.Str()
+			parts = append(parts, partValue)
 		case ast.NodeStringExpr:
 			str, ok := part.(*ast.StringExpr)
 			if !ok {
-				return "", errors.NewEvalError(shell.filename, part,
+				return nil, errors.NewEvalError(shell.filename, part,
 					"Failed to eval string: %s", part)
 			}
 
-			pathStr += str.Value()
+			parts = append(parts, sh.NewStrObj(str.Value()))
+		case ast.NodeIntExpr, ast.NodeBinaryExpr:
+			obj, err := shell.evalExpr(part)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, obj)
 		case ast.NodeFnInv:
 			fnNode := part.(*ast.FnInvNode)
 			result, err := shell.executeFnInv(fnNode)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 
 			if len(result) == 0 || len(result) > 1 {
-				return "", errors.NewEvalError(shell.filename, part,
+				return nil, errors.NewEvalError(shell.filename, part,
 					"Function '%s' used in string concat but returns %d values.",
 					fnNode.Name)
 			}
 			obj := result[0]
-			if obj.Type() != sh.StringType {
-				return "", errors.NewEvalError(shell.filename, part,
+			if obj.Type() != sh.StringType && obj.Type() != sh.IntType {
+				return nil, errors.NewEvalError(shell.filename, part,
 					"Function '%s' used in concat but returns a '%s'", obj.Type())
 			}
 
-			str := obj.(*sh.StrObj)
-			pathStr += str.Str()
+			parts = append(parts, obj)
 		case ast.NodeListExpr:
-			return "", errors.NewEvalError(shell.filename, part,
+			return nil, errors.NewEvalError(shell.filename, part,
 				"Concat of lists is not allowed: %+v", part.String())
 		default:
-			return "", errors.NewEvalError(shell.filename, part,
+			return nil, errors.NewEvalError(shell.filename, part,
 				"Invalid argument: %+v", part)
 		}
 	}
 
-	return pathStr, nil
+	// the type of the first operand decides the operation and
+	// the types can't be mixed.
+	if parts[0].Type() == sh.IntType {
+		var sum int
+
+		for i, part := range parts {
+			if part.Type() != sh.IntType {
+				return nil, errors.NewEvalError(shell.filename, concat[i],
+					"Invalid operation '+' between %s and %s: %s",
+					parts[0].Type(), part.Type(), path)
+			}
+
+			var ok bool
+
+			sum, ok = addInt(sum, part.(*sh.IntObj).Int())
+			if !ok {
+				return nil, errors.NewEvalError(shell.filename, concat[i],
+					"Integer overflow in operation '+': %s", path)
+			}
+		}
+
+		return sh.NewIntObj(sum), nil
+	}
+
+	var pathStr string
+
+	for i, part := range parts {
+		if part.Type() != sh.StringType {
+			return nil, errors.NewEvalError(shell.filename, concat[i],
+				"Invalid operation '+' between %s and %s: %s",
+				parts[0].Type(), part.Type(), path)
+		}
+
+		pathStr += part.String()
+	}
+
+	return sh.NewStrObj(pathStr), nil
+}
+
+// addInt returns a + b and false if the sum overflows.
+func addInt(a, b int) (int, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+// subInt returns a - b and false if the subtraction overflows.
+func subInt(a, b int) (int, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+// mulInt returns a * b and false if the product overflows.
+func mulInt(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+
+	const minInt = -1 << (strconv.IntSize - 1)
+
+	c := a * b
+	if c/b != a || (a == -1 && b == minInt) || (b == -1 && a == minInt) {
+		return c, false
+	}
+
+	return c, true
+}
+
+// evalArith evaluates the integer operations '-', '*', '/' and '%'.
+func (shell *Shell) evalArith(expr *ast.BinaryExpr) (sh.Obj, error) {
+	var operands [2]int
+
+	for i, operand := range []ast.Expr{expr.Lvalue, expr.Rvalue} {
+		obj, err := shell.evalExpr(operand)
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Type() != sh.IntType {
+			return nil, errors.NewEvalError(shell.filename, operand,
+				"Invalid operation '%s' on %s: %s", expr.Op,
+				obj.Type(), expr)
+		}
+
+		operands[i] = obj.(*sh.IntObj).Int()
+	}
+
+	lvalue, rvalue := operands[0], operands[1]
+
+	switch expr.Op {
+	case "-", "*":
+		var (
+			res int
+			ok  bool
+		)
+
+		if expr.Op == "-" {
+			res, ok = subInt(lvalue, rvalue)
+		} else {
+			res, ok = mulInt(lvalue, rvalue)
+		}
+
+		if !ok {
+			return nil, errors.NewEvalError(shell.filename, expr,
+				"Integer overflow in operation '%s': %s", expr.Op, expr)
+		}
+
+		return sh.NewIntObj(res), nil
+	case "/", "%":
+		if rvalue == 0 {
+			return nil, errors.NewEvalError(shell.filename, expr,
+				"Division by zero: %s", expr)
+		}
+
+		if expr.Op == "/" {
+			return sh.NewIntObj(lvalue / rvalue), nil
+		}
+
+		return sh.NewIntObj(lvalue % rvalue), nil
+	}
+
+	return nil, errors.NewEvalError(shell.filename, expr,
+		"Invalid operator '%s': %s", expr.Op, expr)
 }
 
 func (shell *Shell) executeNode(node ast.Node) ([]sh.Obj, error) {
@@ -833,22 +1090,38 @@ func (shell *Shell) executeNode(node ast.Node) ([]sh.Obj, error) {
 	case ast.NodeExecAssign:
 		err = shell.executeExecAssign(node.(*ast.ExecAssignNode))
 	case ast.NodeCommand:
-		_, err = shell.executeCommand(node.(*ast.CommandNode))
+		if node.(*ast.CommandNode).IsBackground() {
+			_, err = shell.executeBackground(node)
+		} else {
+			_, err = shell.executeCommand(node.(*ast.CommandNode))
+		}
 	case ast.NodePipe:
-		_, err = shell.executePipe(node.(*ast.PipeNode))
+		if node.(*ast.PipeNode).IsBackground() {
+			_, err = shell.executeBackground(node)
+		} else {
+			_, err = shell.executePipe(node.(*ast.PipeNode))
+		}
 	case ast.NodeRfork:
 		err = shell.executeRfork(node.(*ast.RforkNode))
 	case ast.NodeIf:
 		objs, err = shell.executeIf(node.(*ast.IfNode))
+	case ast.NodeSwitch:
+		objs, err = shell.executeSwitch(node.(*ast.SwitchNode))
 	case ast.NodeFnDecl:
 		err = shell.executeFnDecl(node.(*ast.FnDeclNode))
 	case ast.NodeFnInv:
 		// invocation ignoring output
 		_, err = shell.executeFnInv(node.(*ast.FnInvNode))
+	case ast.NodeSpawn:
+		_, err = shell.executeSpawn(node.(*ast.SpawnNode))
 	case ast.NodeFor:
 		objs, err = shell.executeFor(node.(*ast.ForNode))
 	case ast.NodeBindFn:
 		err = shell.executeBindFn(node.(*ast.BindFnNode))
+	case ast.NodeShowEnv:
+		shell.executeShowEnv(node.(*ast.ShowEnvNode))
+	case ast.NodeDump:
+		err = shell.executeDump(node.(*ast.DumpNode))
 	case ast.NodeReturn:
 		if shell.IsFn() {
 			objs, err = shell.executeReturn(node.(*ast.ReturnNode))
@@ -857,6 +1130,18 @@ func (shell *Shell) executeNode(node ast.Node) ([]sh.Obj, error) {
 				node,
 				"Unexpected return outside of function declaration.")
 		}
+	case ast.NodeDefer:
+		if shell.IsFn() {
+			shell.executeDefer(node.(*ast.DeferNode))
+		} else {
+			err = errors.NewEvalError(shell.filename,
+				node,
+				"Unexpected defer outside of function declaration.")
+		}
+	case ast.NodeBreak:
+		err = newErrBreak()
+	case ast.NodeContinue:
+		err = newErrContinue()
 	default:
 		// should never get here
 		return nil, errors.NewEvalError(shell.filename, node,
@@ -879,6 +1164,8 @@ func (shell *Shell) executeTree(tr *ast.Tree, stopable bool) ([]sh.Obj, error) {
 	root := tr.Root
 
 	for _, node := range root.Nodes {
+		shell.runTraps()
+
 		objs, err := shell.executeNode(node)
 		if err != nil {
 			type (
@@ -930,9 +1217,65 @@ func (shell *Shell) executeReturn(n *ast.ReturnNode) ([]sh.Obj, error) {
 		returns = append(returns, obj)
 	}
 
+	if shell.userFn != nil {
+		err := shell.userFn.checkReturns(returns)
+		if err != nil {
+			return nil, newErrReturnType(shell.filename, n, err)
+		}
+	}
+
 	return returns, newErrStopWalking()
 }
 
+// executeDefer records the statement of n to run when the function
+// returns. The values of the variables of the function are saved, then
+// changing them later doesn't change the deferred statement.
+func (shell *Shell) executeDefer(n *ast.DeferNode) {
+	scope := NewSubShell(shell.name, shell)
+
+	for name, value := range shell.vars {
+		scope.vars[name] = value
+	}
+
+	shell.defers = append(shell.defers, deferred{
+		scope: scope,
+		stmt:  n.Stmt(),
+	})
+}
+
+// runDefers runs the deferred statements in the reverse order they
+// were deferred. Every statement runs even if others fail. The error
+// err of the function is preserved and the errors of the deferred
+// statements are only returned if err is nil, otherwise they are
+// printed to stderr.
+func (shell *Shell) runDefers(err error) error {
+	type IgnoreError interface {
+		Ignore() bool
+	}
+
+	for i := len(shell.defers) - 1; i >= 0; i-- {
+		d := shell.defers[i]
+
+		_, derr := d.scope.executeNode(d.stmt)
+		if derr == nil {
+			continue
+		}
+
+		if errIgnore, ok := derr.(IgnoreError); ok && errIgnore.Ignore() {
+			continue
+		}
+
+		if err == nil {
+			err = derr
+		} else {
+			fmt.Fprintf(shell.stderr, "defer %s: %s\n", d.stmt, derr)
+		}
+	}
+
+	shell.defers = nil
+	return err
+}
+
 func (shell *Shell) getNashRootFromGOPATH(preverr error) (string, error) {
 	g, hasgopath := shell.Getenv("GOPATH")
 	if !hasgopath {
@@ -1004,9 +1347,11 @@ func (shell *Shell) executeImport(node *ast.ImportNode) error {
 			continue
 		}
 
-		if m := d.Mode(); !m.IsDir() {
-			return shell.ExecFile(path)
+		if m := d.Mode(); m.IsDir() {
+			continue
 		}
+
+		return shell.importFile(node, path)
 	}
 
 	errmsg := fmt.Sprintf(
@@ -1026,28 +1371,39 @@ func (shell *Shell) executeImport(node *ast.ImportNode) error {
 // each command separated by '|'. The $status of pipe execution will be
 // the $status of each command separated by '|'.
 func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
+	term := shell.terminal()
+	if term == nil {
+		return shell.startPipe(pipe, nil)()
+	}
+
+	pg := newProcGroup(term, true)
+	return shell.foreground(newJob(pipe, shell.startPipe(pipe, pg), pg))
+}
+
+// startPipe starts the commands of the pipe in the process group pg
+// and returns the function that waits for them to finish. Errors
+// starting the pipe are also reported by the returned function.
+func (shell *Shell) startPipe(pipe *ast.PipeNode, pg *procGroup) func() (sh.Obj, error) {
 	var (
 		closeFiles     []io.Closer
 		closeAfterWait []io.Closer
+		stdout, stderr io.Writer
 		errIndex       int
 		err            error
 	)
 
-	defer func() {
-		for _, c := range closeAfterWait {
-			c.Close()
-		}
-	}()
-
 	nodeCommands := pipe.Commands()
 
 	if len(nodeCommands) < 2 {
-		return sh.NewStrObj(strconv.Itoa(ENotStarted)),
-			errors.NewEvalError(shell.filename,
-				pipe, "Pipe requires at least two commands.")
+		return func() (sh.Obj, error) {
+			return sh.NewStrObj(strconv.Itoa(ENotStarted)),
+				errors.NewEvalError(shell.filename,
+					pipe, "Pipe requires at least two commands.")
+		}
 	}
 
 	cmds := make([]sh.Runner, len(nodeCommands))
+	pipes := make([]io.Closer, len(nodeCommands)) // stdin of each stage
 	errs := make([]string, len(nodeCommands))
 	igns := make([]bool, len(nodeCommands)) // ignoreErrors
 	cods := make([]string, len(nodeCommands))
@@ -1059,6 +1415,76 @@ func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
 
 	last := len(nodeCommands) - 1
 
+	closeAll := func() {
+		for _, c := range closeAfterWait {
+			c.Close()
+		}
+	}
+
+	pipeError := func() (sh.Obj, error) {
+		closeAll()
+
+		if igns[errIndex] {
+			errs[errIndex] = "none"
+		} else {
+			errs[errIndex] = err.Error()
+		}
+
+		cods[errIndex] = getErrStatus(err, cods[errIndex])
+
+		err = errors.NewEvalError(shell.filename,
+			pipe, strings.Join(errs, "|"))
+
+		// verify if all status codes are the same
+		uniqCodes := make(map[string]struct{})
+		var uniqCode string
+
+		for i := 0; i < len(cods); i++ {
+			uniqCodes[cods[i]] = struct{}{}
+			uniqCode = cods[i]
+		}
+
+		var status sh.Obj
+
+		if len(uniqCodes) == 1 {
+			// if all status are the same
+			status = sh.NewStrObj(uniqCode)
+		} else {
+			status = sh.NewStrObj(strings.Join(cods, "|"))
+		}
+
+		if igns[errIndex] {
+			return status, nil
+		}
+
+		return status, err
+	}
+
+	outputError := func() (sh.Obj, error) {
+		closeAll()
+		return sh.NewStrObj(strconv.Itoa(ENotStarted)), err
+	}
+
+	// The commands share a single pipe to each shell output that is
+	// not a file. Otherwise it's written concurrently by each command.
+	stdout, closeFiles, err = sharedWriter(shell.stdout)
+	closeAfterWait = append(closeAfterWait, closeFiles...)
+
+	if err != nil {
+		return outputError
+	}
+
+	stderr = stdout
+
+	if !sameWriter(shell.stderr, shell.stdout) {
+		stderr, closeFiles, err = sharedWriter(shell.stderr)
+		closeAfterWait = append(closeAfterWait, closeFiles...)
+
+		if err != nil {
+			return outputError
+		}
+	}
+
 	envVars := buildenv(shell.Environ())
 
 	// Create all commands
@@ -1071,14 +1497,14 @@ func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
 
 		nodeCmd := nodeCommands[i]
 
-		cmd, ignore, err = shell.getCommand(nodeCmd)
+		cmd, ignore, err = shell.getPipeStage(nodeCmd)
 
 		igns[i] = ignore
 
 		if err != nil {
 			errIndex = i
 			cods[i] = strconv.Itoa(ENotFound)
-			goto pipeError
+			return pipeError
 		}
 
 		// SetEnviron must be called before SetArgs
@@ -1089,16 +1515,18 @@ func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
 
 		if err != nil {
 			errIndex = i
-			goto pipeError
+			return pipeError
 		}
 
 		err = cmd.SetArgs(args)
 		if err != nil {
 			errIndex = i
-			goto pipeError
+			return pipeError
 		}
 
 		cmd.SetStdin(shell.stdin)
+		cmd.SetStdout(stdout)
+		cmd.SetStderr(stderr)
 
 		if i < last {
 			closeFiles, err = shell.setRedirects(cmd, nodeCmd.Redirects())
@@ -1106,112 +1534,129 @@ func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
 
 			if err != nil {
 				errIndex = i
-				goto pipeError
+				return pipeError
 			}
 		}
 
 		cmds[i] = cmd
 	}
 
-	// Shell does not support stdin redirection yet
-	cmds[0].SetStdin(shell.stdin)
-
-	// Setup the commands. Pointing the stdin of next command to stdout of previous.
-	// Except the stdout of last one
+	// Setup the commands. Pointing the stdin of next command to the piped output
+	// (stdout by default) of previous. Except the stdout of last one
 	for i, cmd := range cmds[:last] {
 		var (
 			stdin io.ReadCloser
 		)
 
-		// StdoutPipe complains if Stdout is already set
-		cmd.SetStdout(nil)
-		stdin, err = cmd.StdoutPipe()
+		stdin, err = pipeOutput(cmd, nodeCommands[i].PipeFds())
 
 		if err != nil {
 			errIndex = i
-			goto pipeError
+			return pipeError
 		}
 
 		cmds[i+1].SetStdin(stdin)
+		pipes[i+1] = stdin
 	}
 
-	cmds[last].SetStdout(shell.stdout)
-	cmds[last].SetStderr(shell.stderr)
+	cmds[last].SetStdout(stdout)
+	cmds[last].SetStderr(stderr)
 
 	closeFiles, err = shell.setRedirects(cmds[last], nodeCommands[last].Redirects())
 	closeAfterWait = append(closeAfterWait, closeFiles...)
 
 	if err != nil {
 		errIndex = last
-		goto pipeError
+		return pipeError
 	}
 
 	for i := 0; i < len(cmds); i++ {
 		cmd := cmds[i]
 
-		err = cmd.Start()
+		err = pg.start(cmd)
 
 		if err != nil {
 			errIndex = i
-			goto pipeError
+			return pipeError
 		}
 
 		errs[i] = "success"
 		cods[i] = "0"
 	}
 
-	for i, cmd := range cmds {
-		err = cmd.Wait()
+	return func() (sh.Obj, error) {
+		// Wait from the last stage to the first, closing the stdin of
+		// each finished stage. This way a stage never loses the data not
+		// yet read from the previous one and functions writing to a
+		// finished stage get EPIPE instead of blocking forever.
+		waitErrs := make([]error, len(cmds))
 
-		if err != nil {
-			errIndex = i
-			goto pipeError
+		for i := last; i >= 0; i-- {
+			waitErrs[i] = cmds[i].Wait()
+
+			if pipes[i] != nil {
+				pipes[i].Close()
+			}
 		}
 
-		errs[i] = "success"
-		cods[i] = "0"
-	}
+		for i := range cmds {
+			if waitErrs[i] != nil {
+				err = waitErrs[i]
+				errIndex = i
+				return pipeError()
+			}
 
-	return sh.NewStrObj("0"), nil
+			errs[i] = "success"
+			cods[i] = "0"
+		}
 
-pipeError:
-	if igns[errIndex] {
-		errs[errIndex] = "none"
-	} else {
-		errs[errIndex] = err.Error()
+		closeAll()
+		return sh.NewStrObj("0"), nil
 	}
+}
 
-	cods[errIndex] = getErrStatus(err, cods[errIndex])
+// pipeOutput returns a reader connected to the file descriptors fds
+// of cmd. Only the stdout is piped if fds is empty.
+func pipeOutput(cmd sh.Runner, fds []int) (io.ReadCloser, error) {
+	var stdout, stderr bool
 
-	err = errors.NewEvalError(shell.filename,
-		pipe, strings.Join(errs, "|"))
+	if len(fds) == 0 {
+		stdout = true
+	}
 
-	// verify if all status codes are the same
-	uniqCodes := make(map[string]struct{})
-	var uniqCode string
+	for _, fd := range fds {
+		switch fd {
+		case 1:
+			stdout = true
+		case 2:
+			stderr = true
+		}
+	}
 
-	for i := 0; i < len(cods); i++ {
-		uniqCodes[cods[i]] = struct{}{}
-		uniqCode = cods[i]
+	if !stdout {
+		// StderrPipe complains if Stderr is already set
+		cmd.SetStderr(nil)
+		return cmd.StderrPipe()
 	}
 
-	var status sh.Obj
+	// StdoutPipe complains if Stdout is already set
+	cmd.SetStdout(nil)
 
-	if len(uniqCodes) == 1 {
-		// if all status are the same
-		status = sh.NewStrObj(uniqCode)
-	} else {
-		status = sh.NewStrObj(strings.Join(cods, "|"))
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
 	}
 
-	if igns[errIndex] {
-		return status, nil
+	if stderr {
+		cmd.SetStderr(cmd.Stdout())
 	}
 
-	return status, err
+	return pipe, nil
 }
 
-func (shell *Shell) openRedirectLocation(location ast.Expr) (io.WriteCloser, error) {
+// openRedirectLocation opens the file or network location of a
+// redirection. The flag is used to open files (see os.OpenFile).
+func (shell *Shell) openRedirectLocation(location ast.Expr, flag int) (io.ReadWriteCloser, error) {
 	var protocol string
 
 	locationObj, err := shell.evalExpr(location)
@@ -1239,7 +1684,13 @@ func (shell *Shell) openRedirectLocation(location ast.Expr) (io.WriteCloser, err
 	}
 
 	if protocol == "" {
-		return os.OpenFile(locationStr, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		return os.OpenFile(locationStr, flag, 0644)
+	}
+
+	if protocol == "udp" && flag == os.O_RDONLY {
+		return nil, errors.NewEvalError(shell.filename,
+			location,
+			"Input redirection does not support udp: %s", locationStr)
 	}
 
 	switch protocol {
@@ -1263,8 +1714,18 @@ func (shell *Shell) openRedirectLocation(location ast.Expr) (io.WriteCloser, err
 		"Unexpected redirection value: %s", locationStr)
 }
 
-func (shell *Shell) setRedirects(cmd sh.Runner, redirDecls []*ast.RedirectNode) ([]io.Closer, error) {
-	var closeAfterWait []io.Closer
+// redirFlags returns the flags used to open the file of an output
+// redirection.
+func redirFlags(redir *ast.RedirectNode) int {
+	if redir.Kind() == ast.RedirAppend {
+		return redirAppendFlags
+	}
+
+	return redirFileFlags
+}
+
+func (shell *Shell) setRedirects(cmd sh.Runner, redirDecls []*ast.RedirectNode) ([]io.Closer, error) {
+	var closeAfterWait []io.Closer
 
 	for _, r := range redirDecls {
 		closeFiles, err := shell.buildRedirect(cmd, r)
@@ -1278,27 +1739,180 @@ func (shell *Shell) setRedirects(cmd sh.Runner, redirDecls []*ast.RedirectNode)
 	return closeAfterWait, nil
 }
 
+func (shell *Shell) buildInputRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([]io.Closer, error) {
+	if redirDecl.LeftFD() != 0 && redirDecl.LeftFD() != ast.RedirMapNoValue {
+		return nil, errors.NewEvalError(shell.filename,
+			redirDecl,
+			"Invalid file descriptor redirection: fd=%d", redirDecl.LeftFD())
+	}
+
+	if redirDecl.Kind() == ast.RedirHereStr {
+		obj, err := shell.evalExpr(redirDecl.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Type() != sh.StringType && obj.Type() != sh.IntType {
+			return nil, errors.NewEvalError(shell.filename,
+				redirDecl.Location(),
+				"Here-string requires a string, but found %v (%s)", obj, obj.Type())
+		}
+
+		cmd.SetStdin(strings.NewReader(obj.String()))
+		return nil, nil
+	}
+
+	file, err := shell.openRedirectLocation(redirDecl.Location(), os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.SetStdin(file)
+	return []io.Closer{file}, nil
+}
+
+// buildExtraRedirect sets up redirections involving file descriptors
+// greater than 2 (eg.: >[3] file, >[3=1], >[1=3]). The descriptors are
+// passed to the child process as extra files, then only commands
+// support them.
+func (shell *Shell) buildExtraRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([]io.Closer, error) {
+	var (
+		file           *os.File
+		closeAfterWait []io.Closer
+		err            error
+	)
+
+	lfd, rfd := redirDecl.LeftFD(), redirDecl.RightFD()
+
+	extra, ok := cmd.(extraFiler)
+	if !ok {
+		fd := lfd
+		if rfd > 2 {
+			fd = rfd
+		}
+
+		return nil, errors.NewEvalError(shell.filename,
+			redirDecl,
+			"Redirection of file descriptor %d is only supported by commands", fd)
+	}
+
+	switch rfd {
+	case 0:
+		return nil, errors.NewEvalError(shell.filename,
+			redirDecl, "Invalid redirect mapping: %d -> %d", lfd, rfd)
+	case 1, 2:
+		out := cmd.Stdout()
+		if rfd == 2 {
+			out = cmd.Stderr()
+		}
+
+		var closer io.Closer
+
+		file, closer, err = writerFile(out)
+		if err != nil {
+			return nil, err
+		}
+
+		if closer != nil {
+			closeAfterWait = append(closeAfterWait, closer)
+
+			// the command output also writes to the pipe, then
+			// out is not written concurrently by two copies.
+			if sameWriter(cmd.Stdout(), out) {
+				cmd.SetStdout(file)
+			}
+
+			if sameWriter(cmd.Stderr(), out) {
+				cmd.SetStderr(file)
+			}
+		}
+	case ast.RedirMapNoValue:
+		if redirDecl.Location() == nil {
+			return nil, errors.NewEvalError(shell.filename,
+				redirDecl,
+				"Missing file in redirection: >[%d] <??>", lfd)
+		}
+
+		location, err := shell.openRedirectLocation(redirDecl.Location(), redirFlags(redirDecl))
+		if err != nil {
+			return nil, err
+		}
+
+		closeAfterWait = append(closeAfterWait, location)
+
+		file, err = locationFile(location)
+		if err != nil {
+			return closeAfterWait, errors.NewEvalError(shell.filename,
+				redirDecl.Location(), "%s", err.Error())
+		}
+
+		if file != location {
+			closeAfterWait = append(closeAfterWait, file)
+		}
+	case ast.RedirMapSupress:
+		file, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		closeAfterWait = append(closeAfterWait, file)
+	default:
+		file = extra.ExtraFile(rfd)
+		if file == nil {
+			return nil, errors.NewEvalError(shell.filename,
+				redirDecl,
+				"Invalid redirect mapping: %d -> %d (fd %d is not open)",
+				lfd, rfd, rfd)
+		}
+	}
+
+	switch lfd {
+	case 0:
+		return closeAfterWait, errors.NewEvalError(shell.filename,
+			redirDecl,
+			"Invalid output redirection of stdin, use <[0] instead")
+	case 1:
+		cmd.SetStdout(file)
+	case 2:
+		cmd.SetStderr(file)
+	default:
+		extra.SetExtraFile(lfd, file)
+	}
+
+	return closeAfterWait, nil
+}
+
 func (shell *Shell) buildRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([]io.Closer, error) {
 	var closeAfterWait []io.Closer
 
-	if redirDecl.LeftFD() > 2 || redirDecl.LeftFD() < ast.RedirMapSupress {
+	if redirDecl.IsInput() {
+		return shell.buildInputRedirect(cmd, redirDecl)
+	}
+
+	if redirDecl.LeftFD() < ast.RedirMapSupress {
 		return closeAfterWait, errors.NewEvalError(shell.filename,
 			redirDecl,
 			"Invalid file descriptor redirection: fd=%d", redirDecl.LeftFD())
 	}
 
-	if redirDecl.RightFD() > 2 || redirDecl.RightFD() < ast.RedirMapSupress {
+	if redirDecl.RightFD() < ast.RedirMapSupress {
 		return closeAfterWait, errors.NewEvalError(shell.filename,
 			redirDecl,
 			"Invalid file descriptor redirection: fd=%d", redirDecl.RightFD())
 	}
 
+	if redirDecl.LeftFD() > 2 || redirDecl.RightFD() > 2 {
+		return shell.buildExtraRedirect(cmd, redirDecl)
+	}
+
 	var err error
 
 	// Note(i4k): We need to remove the repetitive code in some smarter way
 	switch redirDecl.LeftFD() {
 	case 0:
-		return closeAfterWait, fmt.Errorf("Does not support stdin redirection yet")
+		return closeAfterWait, errors.NewEvalError(shell.filename,
+			redirDecl,
+			"Invalid output redirection of stdin, use <[0] instead")
 	case 1:
 		switch redirDecl.RightFD() {
 		case 0:
@@ -1315,7 +1929,7 @@ func (shell *Shell) buildRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([
 					"Missing file in redirection: >[%d] <??>", redirDecl.LeftFD())
 			}
 
-			file, err := shell.openRedirectLocation(redirDecl.Location())
+			file, err := shell.openRedirectLocation(redirDecl.Location(), redirFlags(redirDecl))
 			if err != nil {
 				return closeAfterWait, err
 			}
@@ -1341,7 +1955,7 @@ func (shell *Shell) buildRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([
 					"Missing file in redirection: >[%d] <??>", redirDecl.LeftFD())
 			}
 
-			file, err := shell.openRedirectLocation(redirDecl.Location())
+			file, err := shell.openRedirectLocation(redirDecl.Location(), redirFlags(redirDecl))
 			if err != nil {
 				return closeAfterWait, err
 			}
@@ -1357,7 +1971,7 @@ func (shell *Shell) buildRedirect(cmd sh.Runner, redirDecl *ast.RedirectNode) ([
 				redirDecl, "Missing file in redirection: >[%d] <??>", redirDecl.LeftFD())
 		}
 
-		file, err := shell.openRedirectLocation(redirDecl.Location())
+		file, err := shell.openRedirectLocation(redirDecl.Location(), redirFlags(redirDecl))
 		if err != nil {
 			return closeAfterWait, err
 		}
@@ -1389,6 +2003,60 @@ func (shell *Shell) newBindfnRunner(
 	return fnDef.Build(), nil
 }
 
+// getFnCommand returns the user function name to be run as a
+// command. The functions being executed are skipped, then a function
+// can wrap the command with its name.
+func (shell *Shell) getFnCommand(name string) (sh.Runner, bool) {
+	for scope := shell; scope != nil; scope = scope.parent {
+		if scope.userFn != nil && scope.userFn.name == name {
+			return nil, false
+		}
+	}
+
+	fnObj, err := shell.GetFn(name)
+	if err != nil {
+		return nil, false
+	}
+
+	fnDef, ok := fnObj.Fn().(*userFnDef)
+	if !ok {
+		// builtin functions doesn't works as commands
+		return nil, false
+	}
+
+	shell.logf("Executing function %s as command\n", name)
+
+	fn := fnDef.Build()
+	fn.SetStdin(shell.stdin)
+	fn.SetStdout(shell.stdout)
+	fn.SetStderr(shell.stderr)
+
+	return fn, true
+}
+
+// getPipeStage returns the runner of a pipe stage. Functions
+// defined by the user are looked up before binds and commands, then
+// they can read and write the pipe as any other command.
+func (shell *Shell) getPipeStage(c *ast.CommandNode) (sh.Runner, bool, error) {
+	var ignoreError bool
+
+	fnName := c.Name()
+
+	if len(fnName) > 1 && fnName[0] == '-' {
+		ignoreError = true
+		fnName = fnName[1:]
+	}
+
+	if fn, ok := shell.getFnCommand(fnName); ok {
+		return fn, ignoreError, nil
+	}
+
+	return shell.getCommand(c)
+}
+
+// getCommand returns the runner of the command c. Binds are looked up
+// before commands, and functions defined by the user are used only if
+// no command is found.
 func (shell *Shell) getCommand(c *ast.CommandNode) (sh.Runner, bool, error) {
 	var (
 		ignoreError bool
@@ -1427,6 +2095,10 @@ func (shell *Shell) getCommand(c *ast.CommandNode) (sh.Runner, bool, error) {
 		shell.logf("Command fails: %s", err.Error())
 
 		if errNotFound, ok := err.(NotFound); ok && errNotFound.NotFound() {
+			if fn, ok := shell.getFnCommand(cmdName); ok {
+				return fn, ignoreError, nil
+			}
+
 			return nil, ignoreError, err
 		}
 
@@ -1440,7 +2112,43 @@ func (shell *Shell) getCommand(c *ast.CommandNode) (sh.Runner, bool, error) {
 	return cmd, ignoreError, nil
 }
 
+// executeBackground starts the command or pipe n in background and
+// returns the job. The job must be waited with the wait builtin.
+func (shell *Shell) executeBackground(n ast.Node) (sh.Obj, error) {
+	var wait func() (sh.Obj, error)
+
+	pg := newProcGroup(shell.terminal(), false)
+
+	switch n := n.(type) {
+	case *ast.CommandNode:
+		wait = shell.startCommand(n, pg)
+	case *ast.PipeNode:
+		wait = shell.startPipe(n, pg)
+	default:
+		return nil, errors.NewEvalError(shell.filename,
+			n, "Invalid node type (%v). Expected command or pipe", n)
+	}
+
+	job := shell.jobs.add(newJob(n, wait, pg))
+
+	shell.logf("Started job %d: %s\n", job.id, n)
+	return sh.NewStrObj(strconv.Itoa(job.id)), nil
+}
+
 func (shell *Shell) executeCommand(c *ast.CommandNode) (sh.Obj, error) {
+	term := shell.terminal()
+	if term == nil {
+		return shell.startCommand(c, nil)()
+	}
+
+	pg := newProcGroup(term, true)
+	return shell.foreground(newJob(c, shell.startCommand(c, pg), pg))
+}
+
+// startCommand starts the command c in the process group pg and
+// returns the function that waits for it to finish. Errors starting
+// the command are also reported by the returned function.
+func (shell *Shell) startCommand(c *ast.CommandNode, pg *procGroup) func() (sh.Obj, error) {
 	var (
 		ignoreError    bool
 		status         = "127"
@@ -1451,15 +2159,26 @@ func (shell *Shell) executeCommand(c *ast.CommandNode) (sh.Obj, error) {
 		args           []sh.Obj
 	)
 
-	defer func() {
+	closeAll := func() {
 		for _, c := range closeAfterWait {
 			c.Close()
 		}
-	}()
+	}
+
+	cmdError := func() (sh.Obj, error) {
+		closeAll()
+
+		statusObj := sh.NewStrObj(getErrStatus(err, status))
+		if ignoreError {
+			return statusObj, newErrIgnore(err.Error())
+		}
+
+		return statusObj, err
+	}
 
 	cmd, ignoreError, err = shell.getCommand(c)
 	if err != nil {
-		goto cmdError
+		return cmdError
 	}
 
 	// SetEnviron must be called before SetArgs
@@ -1470,38 +2189,33 @@ func (shell *Shell) executeCommand(c *ast.CommandNode) (sh.Obj, error) {
 
 	args, err = shell.evalExprs(c.Args())
 	if err != nil {
-		goto cmdError
+		return cmdError
 	}
 
 	err = cmd.SetArgs(args)
 	if err != nil {
-		goto cmdError
+		return cmdError
 	}
 
 	closeAfterWait, err = shell.setRedirects(cmd, c.Redirects())
 	if err != nil {
-		goto cmdError
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		goto cmdError
+		return cmdError
 	}
 
-	err = cmd.Wait()
+	err = pg.start(cmd)
 	if err != nil {
-		goto cmdError
+		return cmdError
 	}
 
-	return sh.NewStrObj("0"), nil
+	return func() (sh.Obj, error) {
+		err = cmd.Wait()
+		if err != nil {
+			return cmdError()
+		}
 
-cmdError:
-	statusObj := sh.NewStrObj(getErrStatus(err, status))
-	if ignoreError {
-		return statusObj, newErrIgnore(err.Error())
+		closeAll()
+		return sh.NewStrObj("0"), nil
 	}
-
-	return statusObj, err
 }
 
 func (shell *Shell) evalList(argList *ast.ListExpr) (sh.Obj, error) {
@@ -1519,6 +2233,40 @@ func (shell *Shell) evalList(argList *ast.ListExpr) (sh.Obj, error) {
 	return sh.NewListObj(values), nil
 }
 
+func (shell *Shell) evalMap(mapExpr *ast.MapExpr) (sh.Obj, error) {
+	values := make(map[string]sh.Obj, len(mapExpr.Keys))
+
+	for i := 0; i < len(mapExpr.Keys); i++ {
+		key, err := shell.evalMapKey(mapExpr.Keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := shell.evalExpr(mapExpr.Values[i])
+		if err != nil {
+			return nil, err
+		}
+
+		values[key] = obj
+	}
+
+	return sh.NewMapObj(values), nil
+}
+
+func (shell *Shell) evalMapKey(key ast.Expr) (string, error) {
+	obj, err := shell.evalExpr(key)
+	if err != nil {
+		return "", err
+	}
+
+	if obj.Type() != sh.StringType {
+		return "", errors.NewEvalError(shell.filename,
+			key, "Invalid object type on map key: %s", obj.Type())
+	}
+
+	return obj.String(), nil
+}
+
 func (shell *Shell) evalArgList(argList *ast.ListExpr) ([]sh.Obj, error) {
 	values := make([]sh.Obj, 0, len(argList.List))
 
@@ -1554,6 +2302,10 @@ func (shell *Shell) evalIndex(index ast.Expr) (int, error) {
 		return 0, err
 	}
 
+	if idxObj.Type() == sh.IntType {
+		return idxObj.(*sh.IntObj).Int(), nil
+	}
+
 	if idxObj.Type() != sh.StringType {
 		return 0, errors.NewEvalError(shell.filename,
 			index, "Invalid object type on index value: %s", idxObj.Type())
@@ -1576,11 +2328,28 @@ func (shell *Shell) evalIndexedVar(indexVar *ast.IndexExpr) (sh.Obj, error) {
 		return nil, err
 	}
 
+	if objmap, ok := v.(*sh.MapObj); ok {
+		key, err := shell.evalMapKey(indexVar.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := objmap.Get(key)
+		if err != nil {
+			return nil, errors.NewEvalError(shell.filename, indexVar.Var, err.Error())
+		}
+		return val, nil
+	}
+
 	col, err := sh.NewCollection(v)
 	if err != nil {
 		return nil, errors.NewEvalError(shell.filename, indexVar.Var, err.Error())
 	}
 
+	if slice, ok := indexVar.Index.(*ast.SliceExpr); ok {
+		return shell.evalSlice(indexVar, col, slice)
+	}
+
 	indexNum, err := shell.evalIndex(indexVar.Index)
 	if err != nil {
 		return nil, err
@@ -1593,25 +2362,36 @@ func (shell *Shell) evalIndexedVar(indexVar *ast.IndexExpr) (sh.Obj, error) {
 	return val, nil
 }
 
-func (shell *Shell) evalArgIndexedVar(indexVar *ast.IndexExpr) ([]sh.Obj, error) {
-	v, err := shell.evalVariable(indexVar.Var)
-	if err != nil {
-		return nil, err
+func (shell *Shell) evalSlice(indexVar *ast.IndexExpr, col sh.Collection, slice *ast.SliceExpr) (sh.Obj, error) {
+	var err error
+
+	start, end := 0, col.Len()
+
+	if slice.Start != nil {
+		start, err = shell.evalIndex(slice.Start)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	col, err := sh.NewCollection(v)
-	if err != nil {
-		return nil, errors.NewEvalError(shell.filename, indexVar.Var, err.Error())
+	if slice.End != nil {
+		end, err = shell.evalIndex(slice.End)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	indexNum, err := shell.evalIndex(indexVar.Index)
+	val, err := col.Slice(start, end)
 	if err != nil {
-		return nil, err
+		return nil, errors.NewEvalError(shell.filename, indexVar.Var, err.Error())
 	}
+	return val, nil
+}
 
-	retval, err := col.Get(indexNum)
+func (shell *Shell) evalArgIndexedVar(indexVar *ast.IndexExpr) ([]sh.Obj, error) {
+	retval, err := shell.evalIndexedVar(indexVar)
 	if err != nil {
-		return nil, errors.NewEvalError(shell.filename, indexVar.Var, err.Error())
+		return nil, err
 	}
 
 	if indexVar.IsVariadic {
@@ -1715,6 +2495,21 @@ func (shell *Shell) evalArgExprs(exprs []ast.Expr) ([]sh.Obj, error) {
 	return ret, nil
 }
 
+func (shell *Shell) evalNamedArgs(args []*ast.FnNamedArg) ([]NamedArg, error) {
+	ret := make([]NamedArg, 0, len(args))
+
+	for _, arg := range args {
+		obj, err := shell.evalExpr(arg.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, NamedArg{Name: arg.Name, Value: obj})
+	}
+
+	return ret, nil
+}
+
 func (shell *Shell) evalArgExpr(expr ast.Expr) ([]sh.Obj, error) {
 	switch expr.Type() {
 	case ast.NodeStringExpr:
@@ -1723,6 +2518,12 @@ func (shell *Shell) evalArgExpr(expr ast.Expr) ([]sh.Obj, error) {
 				sh.NewStrObj(str.Value()),
 			}, nil
 		}
+	case ast.NodeIntExpr:
+		if num, ok := expr.(*ast.IntExpr); ok {
+			return []sh.Obj{
+				sh.NewIntObj(num.Value()),
+			}, nil
+		}
 	case ast.NodeConcatExpr:
 		if concat, ok := expr.(*ast.ConcatExpr); ok {
 			argVal, err := shell.evalConcat(concat)
@@ -1730,9 +2531,16 @@ func (shell *Shell) evalArgExpr(expr ast.Expr) ([]sh.Obj, error) {
 				return nil, err
 			}
 
-			return []sh.Obj{
-				sh.NewStrObj(argVal),
-			}, nil
+			return []sh.Obj{argVal}, nil
+		}
+	case ast.NodeBinaryExpr:
+		if binExpr, ok := expr.(*ast.BinaryExpr); ok {
+			obj, err := shell.evalArith(binExpr)
+			if err != nil {
+				return nil, err
+			}
+
+			return []sh.Obj{obj}, nil
 		}
 	case ast.NodeVarExpr:
 		return shell.evalArgVariable(expr)
@@ -1744,6 +2552,15 @@ func (shell *Shell) evalArgExpr(expr ast.Expr) ([]sh.Obj, error) {
 		if listExpr, ok := expr.(*ast.ListExpr); ok {
 			return shell.evalArgList(listExpr)
 		}
+	case ast.NodeMapExpr:
+		if mapExpr, ok := expr.(*ast.MapExpr); ok {
+			obj, err := shell.evalMap(mapExpr)
+			if err != nil {
+				return nil, err
+			}
+
+			return []sh.Obj{obj}, nil
+		}
 	case ast.NodeFnInv:
 		if fnInv, ok := expr.(*ast.FnInvNode); ok {
 			objs, err := shell.executeFnInv(fnInv)
@@ -1779,14 +2596,17 @@ func (shell *Shell) evalExpr(expr ast.Expr) (sh.Obj, error) {
 		if str, ok := expr.(*ast.StringExpr); ok {
 			return sh.NewStrObj(str.Value()), nil
 		}
+	case ast.NodeIntExpr:
+		if num, ok := expr.(*ast.IntExpr); ok {
+			return sh.NewIntObj(num.Value()), nil
+		}
 	case ast.NodeConcatExpr:
 		if concat, ok := expr.(*ast.ConcatExpr); ok {
-			argVal, err := shell.evalConcat(concat)
-			if err != nil {
-				return nil, err
-			}
-
-			return sh.NewStrObj(argVal), nil
+			return shell.evalConcat(concat)
+		}
+	case ast.NodeBinaryExpr:
+		if binExpr, ok := expr.(*ast.BinaryExpr); ok {
+			return shell.evalArith(binExpr)
 		}
 	case ast.NodeVarExpr:
 		return shell.evalVariable(expr)
@@ -1798,6 +2618,10 @@ func (shell *Shell) evalExpr(expr ast.Expr) (sh.Obj, error) {
 		if listExpr, ok := expr.(*ast.ListExpr); ok {
 			return shell.evalList(listExpr)
 		}
+	case ast.NodeMapExpr:
+		if mapExpr, ok := expr.(*ast.MapExpr); ok {
+			return shell.evalMap(mapExpr)
+		}
 	case ast.NodeFnInv:
 		if fnInv, ok := expr.(*ast.FnInvNode); ok {
 			objs, err := shell.executeFnInv(fnInv)
@@ -1947,11 +2771,7 @@ func (shell *Shell) execCmdOutput(cmd ast.Node,
 		shell.SetStderr(bkStderr)
 	}()
 
-	if cmd.Type() == ast.NodeCommand {
-		status, err = shell.executeCommand(cmd.(*ast.CommandNode))
-	} else {
-		status, err = shell.executePipe(cmd.(*ast.PipeNode))
-	}
+	status, err = shell.executeCmdStatus(cmd, ignoreError)
 
 	outb := outBuf.Bytes()
 	errb := errBuf.Bytes()
@@ -1966,13 +2786,31 @@ func (shell *Shell) execCmdOutput(cmd ast.Node,
 		return data[:]
 	}
 
-	if ignoreError {
-		err = nil
-	}
-
 	return trimnl(outb), trimnl(errb), status, err
 }
 
+// executeCmdStatus executes the command or pipe cmd and returns its
+// status. If ignoreError is true, the failure of the command is only
+// reported by the status.
+func (shell *Shell) executeCmdStatus(cmd ast.Node, ignoreError bool) (sh.Obj, error) {
+	var (
+		status sh.Obj
+		err    error
+	)
+
+	if cmd.Type() == ast.NodeCommand {
+		status, err = shell.executeCommand(cmd.(*ast.CommandNode))
+	} else {
+		status, err = shell.executePipe(cmd.(*ast.PipeNode))
+	}
+
+	if ignoreError {
+		err = nil
+	}
+
+	return status, err
+}
+
 func (shell *Shell) executeExecAssignCmd(v ast.Node) (stdout, stderr, status sh.Obj, err error) {
 	assign := v.(*ast.ExecAssignNode)
 	cmd := assign.Command()
@@ -2025,7 +2863,25 @@ func (shell *Shell) executeExecAssign(v *ast.ExecAssignNode) (err error) {
 			return err
 		}
 		err = shell.setvars(v.Names, values)
+	case ast.NodeSpawn:
+		var pid sh.Obj
+		pid, err = shell.executeSpawn(exec.(*ast.SpawnNode))
+		if err != nil {
+			return err
+		}
+
+		err = shell.setvar(v.Names[0], pid)
 	case ast.NodeCommand, ast.NodePipe:
+		if ast.IsBackground(exec) {
+			var job sh.Obj
+			job, err = shell.executeBackground(exec)
+			if err != nil {
+				return err
+			}
+
+			return shell.setvar(v.Names[0], job)
+		}
+
 		var stdout, stderr, status sh.Obj
 		stdout, stderr, status, err = shell.executeExecAssignCmd(v)
 		if err != nil {
@@ -2083,7 +2939,25 @@ func (shell *Shell) executeVarExecAssign(v *ast.VarExecAssignDeclNode) (err erro
 			return err
 		}
 		shell.newvars(assign.Names, values)
+	case ast.NodeSpawn:
+		var pid sh.Obj
+		pid, err = shell.executeSpawn(exec.(*ast.SpawnNode))
+		if err != nil {
+			return err
+		}
+
+		return shell.newvar(assign.Names[0], pid)
 	case ast.NodeCommand, ast.NodePipe:
+		if ast.IsBackground(exec) {
+			var job sh.Obj
+			job, err = shell.executeBackground(exec)
+			if err != nil {
+				return err
+			}
+
+			return shell.newvar(assign.Names[0], job)
+		}
+
 		var stdout, stderr, status sh.Obj
 		stdout, stderr, status, err = shell.executeExecAssignCmd(assign)
 		if err != nil {
@@ -2143,75 +3017,176 @@ func (shell *Shell) evalIfArgument(arg ast.Node) (sh.Obj, error) {
 	return obj, nil
 }
 
-func (shell *Shell) evalIfArguments(n *ast.IfNode) (string, string, error) {
-	var (
-		lobj, robj sh.Obj
-		err        error
-	)
+// evalCond evaluates the boolean expression of if statements. The
+// operators '&&' and '||' are short-circuited.
+func (shell *Shell) evalCond(cond ast.Expr) (bool, error) {
+	switch c := cond.(type) {
+	case *ast.UnaryExpr:
+		if c.Op != "!" {
+			break
+		}
 
-	lobj, err = shell.evalIfArgument(n.Lvalue())
+		v, err := shell.evalCond(c.Value)
+		if err != nil {
+			return false, err
+		}
 
-	if err != nil {
-		return "", "", err
+		return !v, nil
+	case *ast.BinaryExpr:
+		switch c.Op {
+		case "&&":
+			l, err := shell.evalCond(c.Lvalue)
+			if err != nil || !l {
+				return false, err
+			}
+
+			return shell.evalCond(c.Rvalue)
+		case "||":
+			l, err := shell.evalCond(c.Lvalue)
+			if err != nil || l {
+				return l, err
+			}
+
+			return shell.evalCond(c.Rvalue)
+		}
+
+		return shell.evalComparison(c)
+	case *ast.CommandNode, *ast.PipeNode:
+		status, err := shell.executeCmdStatus(cond, true)
+		if err != nil {
+			return false, err
+		}
+
+		return status.String() == "0", nil
 	}
 
-	robj, err = shell.evalIfArgument(n.Rvalue())
+	return false, errors.NewEvalError(shell.filename,
+		cond, "Invalid condition: %s", cond)
+}
 
+func (shell *Shell) evalComparison(c *ast.BinaryExpr) (bool, error) {
+	lobj, err := shell.evalIfArgument(c.Lvalue)
 	if err != nil {
-		return "", "", err
+		return false, err
 	}
 
-	if lobj.Type() != sh.StringType {
-		return "", "", errors.NewEvalError(shell.filename,
-			n, "lvalue is not comparable: (%v) -> %s.", lobj, lobj.Type())
+	robj, err := shell.evalIfArgument(c.Rvalue)
+	if err != nil {
+		return false, err
 	}
 
-	if robj.Type() != sh.StringType {
-		return "", "", errors.NewEvalError(shell.filename,
-			n, "rvalue is not comparable: (%v) -> %s.", lobj, lobj.Type())
-	}
+	switch c.Op {
+	case "==", "!=":
+		if !isComparable(lobj) {
+			return false, errors.NewEvalError(shell.filename,
+				c, "lvalue is not comparable: (%v) -> %s.", lobj, lobj.Type())
+		}
 
-	lobjstr := lobj.(*sh.StrObj)
-	robjstr := robj.(*sh.StrObj)
+		if !isComparable(robj) {
+			return false, errors.NewEvalError(shell.filename,
+				c, "rvalue is not comparable: (%v) -> %s.", robj, robj.Type())
+		}
+
+		eq := objEqual(lobj, robj)
+		if c.Op == "!=" {
+			return !eq, nil
+		}
 
-	return lobjstr.Str(), robjstr.Str(), nil
+		return eq, nil
+	case "<", ">":
+		lnum, err := shell.evalNumber(c.Lvalue, lobj)
+		if err != nil {
+			return false, err
+		}
+
+		rnum, err := shell.evalNumber(c.Rvalue, robj)
+		if err != nil {
+			return false, err
+		}
+
+		if c.Op == "<" {
+			return lnum < rnum, nil
+		}
+
+		return lnum > rnum, nil
+	}
+
+	return false, errors.NewEvalError(shell.filename,
+		c, "Invalid comparison operator '%s'", c.Op)
 }
 
-func (shell *Shell) executeIfEqual(n *ast.IfNode) ([]sh.Obj, error) {
-	lstr, rstr, err := shell.evalIfArguments(n)
+func (shell *Shell) evalNumber(expr ast.Expr, obj sh.Obj) (int, error) {
+	if obj.Type() == sh.IntType {
+		return obj.(*sh.IntObj).Int(), nil
+	}
+
+	if obj.Type() != sh.StringType {
+		return 0, errors.NewEvalError(shell.filename,
+			expr, "Expected a number, but found %s", obj.Type())
+	}
 
+	num, err := strconv.Atoi(obj.String())
 	if err != nil {
-		return nil, err
+		return 0, errors.NewEvalError(shell.filename,
+			expr, "Expected a number, but found '%s'", obj)
 	}
 
-	if lstr == rstr {
-		return shell.executeTree(n.IfTree(), false)
-	} else if n.ElseTree() != nil {
-		return shell.executeTree(n.ElseTree(), false)
+	return num, nil
+}
+
+// isComparable tells if the object can be compared with '==' and '!='.
+// Strings, integers and lists of comparable objects are comparable.
+func isComparable(obj sh.Obj) bool {
+	switch obj.Type() {
+	case sh.StringType, sh.IntType:
+		return true
+	case sh.ListType:
+		for _, o := range obj.(*sh.ListObj).List() {
+			if !isComparable(o) {
+				return false
+			}
+		}
+
+		return true
 	}
 
-	return nil, nil
+	return false
 }
 
-func (shell *Shell) executeIfNotEqual(n *ast.IfNode) ([]sh.Obj, error) {
-	lstr, rstr, err := shell.evalIfArguments(n)
+func objEqual(a, b sh.Obj) bool {
+	if a.Type() != b.Type() {
+		if a.Type() == sh.ListType || b.Type() == sh.ListType {
+			return false
+		}
 
-	if err != nil {
-		return nil, err
+		// an integer equals the string with its decimal form,
+		// as in $i == "3", but not "03" or "+3".
+		return a.String() == b.String()
 	}
 
-	if lstr != rstr {
-		return shell.executeTree(n.IfTree(), false)
-	} else if n.ElseTree() != nil {
-		return shell.executeTree(n.ElseTree(), false)
+	if a.Type() != sh.ListType {
+		return a.String() == b.String()
 	}
 
-	return nil, nil
-}
+	alist := a.(*sh.ListObj).List()
+	blist := b.(*sh.ListObj).List()
 
-func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
-	var fnDef sh.FnDef
+	if len(alist) != len(blist) {
+		return false
+	}
+
+	for i := 0; i < len(alist); i++ {
+		if !objEqual(alist[i], blist[i]) {
+			return false
+		}
+	}
+
+	return true
+}
 
+// getFnDef returns the function invoked by n. The function can be
+// referenced by name or by a variable.
+func (shell *Shell) getFnDef(n *ast.FnInvNode) (sh.FnDef, error) {
 	fnName := n.Name()
 	if len(fnName) > 1 && fnName[0] == '$' {
 		argVar := ast.NewVarExpr(token.NewFileInfo(n.Line(), n.Column()), fnName)
@@ -2227,14 +3202,22 @@ func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
 		}
 
 		objfn := obj.(*sh.FnObj)
-		fnDef = objfn.Fn()
-	} else {
-		fnObj, err := shell.GetFn(fnName)
-		if err != nil {
-			return nil, errors.NewEvalError(shell.filename,
-				n, err.Error())
-		}
-		fnDef = fnObj.Fn()
+		return objfn.Fn(), nil
+	}
+
+	fnObj, err := shell.GetFn(fnName)
+	if err != nil {
+		return nil, errors.NewEvalError(shell.filename,
+			n, err.Error())
+	}
+
+	return fnObj.Fn(), nil
+}
+
+func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
+	fnDef, err := shell.getFnDef(n)
+	if err != nil {
+		return nil, err
 	}
 
 	fn := fnDef.Build()
@@ -2243,7 +3226,12 @@ func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
 		return nil, err
 	}
 
-	err = fn.SetArgs(args)
+	named, err := shell.evalNamedArgs(n.NamedArgs())
+	if err != nil {
+		return nil, err
+	}
+
+	err = setFnArgs(fn, args, named)
 	if err != nil {
 		return nil, errors.NewEvalError(shell.filename,
 			n, err.Error())
@@ -2260,7 +3248,9 @@ func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
 	}
 
 	err = fn.Wait()
-	if err != nil {
+	if _, ok := err.(*errReturnType); ok {
+		return nil, err
+	} else if err != nil {
 		return nil, errors.NewEvalError(shell.filename,
 			n, err.Error())
 	}
@@ -2269,54 +3259,32 @@ func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
 }
 
 func (shell *Shell) executeInfLoop(tr *ast.Tree) ([]sh.Obj, error) {
-	var (
-		err  error
-		objs []sh.Obj
-	)
-
 	for {
-		objs, err = shell.executeTree(tr, false)
+		objs, stop, err := shell.executeForBody(tr)
 
 		runtime.Gosched()
 
-		type (
-			interruptedError interface {
-				Interrupted() bool
-			}
-
-			stopWalkingError interface {
-				StopWalking() bool
-			}
-		)
-
-		if errInterrupted, ok := err.(interruptedError); ok && errInterrupted.Interrupted() {
-			break
-		}
-
-		if errStopWalking, ok := err.(stopWalkingError); ok && errStopWalking.StopWalking() {
+		if stop || err != nil {
 			return objs, err
 		}
+	}
+}
 
-		shell.Lock()
-
-		if shell.getIntr() {
-			shell.setIntr(false)
-
-			if err != nil {
-				err = newErrInterrupted(err.Error())
-			} else {
-				err = newErrInterrupted("loop interrupted")
-			}
+func (shell *Shell) executeCondLoop(n *ast.ForNode) ([]sh.Obj, error) {
+	for {
+		ok, err := shell.evalCond(n.Cond())
+		if err != nil || !ok {
+			return nil, err
 		}
 
-		shell.Unlock()
+		objs, stop, err := shell.executeForBody(n.Tree())
 
-		if err != nil {
-			break
+		runtime.Gosched()
+
+		if stop || err != nil {
+			return objs, err
 		}
 	}
-
-	return nil, err
 }
 
 func (shell *Shell) executeFor(n *ast.ForNode) ([]sh.Obj, error) {
@@ -2331,6 +3299,14 @@ func (shell *Shell) executeFor(n *ast.ForNode) ([]sh.Obj, error) {
 		shell.looping = false
 	}()
 
+	if n.Cond() != nil {
+		return shell.executeCondLoop(n)
+	}
+
+	if n.InCmd() != nil {
+		return shell.executeForCmd(n)
+	}
+
 	if n.InExpr() == nil {
 		return shell.executeInfLoop(n.Tree())
 	}
@@ -2354,12 +3330,11 @@ func (shell *Shell) executeFor(n *ast.ForNode) ([]sh.Obj, error) {
 			return nil, err
 		}
 
-		if len(objs) != 1 {
+		obj, err = forFnResult(objs)
+		if err != nil {
 			return nil, errors.NewEvalError(shell.filename,
-				inExpr, "Functions with multiple returns do not work as for 'in expression' yet: %v", inExpr)
+				inExpr, "%s: %v", err.Error(), inExpr)
 		}
-
-		obj = objs[0]
 	} else {
 		return nil, errors.NewEvalError(shell.filename,
 			inExpr, "Invalid expression in for loop: %s", inExpr.Type())
@@ -2369,6 +3344,32 @@ func (shell *Shell) executeFor(n *ast.ForNode) ([]sh.Obj, error) {
 		return nil, err
 	}
 
+	if n.Pairs() {
+		return shell.executeForPairs(n, obj)
+	}
+
+	if objmap, ok := obj.(*sh.MapObj); ok {
+		for _, key := range objmap.Keys() {
+			val, err := objmap.Get(key)
+			if err != nil {
+				return nil, errors.NewEvalError(shell.filename,
+					inExpr, "unexpected error[%s] during iteration", err)
+			}
+
+			shell.Newvar(id, sh.NewStrObj(key))
+			if n.ValueIdentifier() != "" {
+				shell.Newvar(n.ValueIdentifier(), val)
+			}
+
+			objs, stop, err := shell.executeForBody(n.Tree())
+			if stop || err != nil {
+				return objs, err
+			}
+		}
+
+		return nil, nil
+	}
+
 	col, err := sh.NewCollection(obj)
 	if err != nil {
 		return nil, errors.NewEvalError(shell.filename,
@@ -2381,52 +3382,369 @@ func (shell *Shell) executeFor(n *ast.ForNode) ([]sh.Obj, error) {
 			return nil, errors.NewEvalError(shell.filename,
 				inExpr, "unexpected error[%s] during iteration", err)
 		}
-		shell.Newvar(id, val)
-		objs, err := shell.executeTree(n.Tree(), false)
 
-		type (
-			interruptedError interface {
-				Interrupted() bool
-			}
+		if n.ValueIdentifier() != "" {
+			// for index, value in $list
+			shell.Newvar(id, sh.NewIntObj(i))
+			shell.Newvar(n.ValueIdentifier(), val)
+		} else {
+			shell.Newvar(id, val)
+		}
 
-			stopWalkingError interface {
-				StopWalking() bool
-			}
-		)
+		objs, stop, err := shell.executeForBody(n.Tree())
+		if stop || err != nil {
+			return objs, err
+		}
+	}
 
-		if errInterrupted, ok := err.(interruptedError); ok && errInterrupted.Interrupted() {
-			return nil, err
+	return nil, nil
+}
+
+// executeForCmd executes the for loop over the lines written to
+// stdout by the command of "for line in <= cmd". The lines are
+// iterated while the command runs and, if the loop body fails or
+// breaks, the command is killed.
+func (shell *Shell) executeForCmd(n *ast.ForNode) ([]sh.Obj, error) {
+	var (
+		ignoreError    bool
+		closeAfterWait []io.Closer
+		cmd            sh.Runner
+		stdout         io.ReadCloser
+		args           []sh.Obj
+		err            error
+	)
+
+	c := n.InCmd().(*ast.CommandNode)
+
+	closeAll := func() {
+		for _, c := range closeAfterWait {
+			c.Close()
 		}
+	}
+
+	defer closeAll()
+
+	cmdError := func(err error) error {
+		if ignoreError {
+			return newErrIgnore("%s", err.Error())
+		}
+
+		return err
+	}
+
+	cmd, ignoreError, err = shell.getCommand(c)
+	if err != nil {
+		return nil, cmdError(err)
+	}
 
-		if errStopWalking, ok := err.(stopWalkingError); ok && errStopWalking.StopWalking() {
+	cmd.SetEnviron(buildenv(shell.Environ()))
+
+	args, err = shell.evalExprs(c.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.SetArgs(args)
+	if err != nil {
+		return nil, cmdError(err)
+	}
+
+	// The command and the loop body share a single pipe to the shell
+	// stderr if it is not a file. Otherwise it's written concurrently
+	// by the command and the commands of the loop body.
+	stderr, closeStderr, err := sharedWriter(shell.stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	bkStdout, bkStderr := shell.stdout, shell.stderr
+
+	defer func() {
+		shell.SetStdout(bkStdout)
+		shell.SetStderr(bkStderr)
+
+		for _, c := range closeStderr {
+			c.Close()
+		}
+	}()
+
+	if sameWriter(shell.stderr, shell.stdout) {
+		shell.SetStdout(stderr)
+	}
+
+	shell.SetStderr(stderr)
+	cmd.SetStderr(stderr)
+
+	closeAfterWait, err = shell.setRedirects(cmd, c.Redirects())
+	if err != nil {
+		return nil, cmdError(err)
+	}
+
+	// StdoutPipe complains if Stdout is already set
+	cmd.SetStdout(nil)
+
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, cmdError(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, cmdError(err)
+	}
+
+	lines := bufio.NewReader(stdout)
+
+	for i := 0; ; i++ {
+		line, rerr := lines.ReadString('\n')
+		if line == "" && rerr != nil {
+			break
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if n.ValueIdentifier() != "" {
+			// for index, line in <= cmd
+			shell.Newvar(n.Identifier(), sh.NewIntObj(i))
+			shell.Newvar(n.ValueIdentifier(), sh.NewStrObj(line))
+		} else {
+			shell.Newvar(n.Identifier(), sh.NewStrObj(line))
+		}
+
+		objs, stop, err := shell.executeForBody(n.Tree())
+		if stop || err != nil {
+			killCmd(cmd)
+			stdout.Close()
+			cmd.Wait()
 			return objs, err
 		}
+	}
 
-		shell.Lock()
+	err = cmd.Wait()
+	if err != nil {
+		return nil, cmdError(err)
+	}
 
-		if shell.getIntr() {
-			shell.setIntr(false)
-			shell.Unlock()
+	return nil, nil
+}
 
-			if err != nil {
-				return nil, newErrInterrupted(err.Error())
-			}
+// killCmd kills the process of cmd, if it's an external command.
+func killCmd(cmd sh.Runner) {
+	type killer interface {
+		Kill() error
+	}
+
+	if k, ok := cmd.(killer); ok {
+		k.Kill()
+	}
+}
+
+// forFnResult returns the object iterated by a for loop over a
+// function invocation. Functions with multiple returns iterate over
+// the first list returned.
+func forFnResult(objs []sh.Obj) (sh.Obj, error) {
+	if len(objs) == 1 {
+		return objs[0], nil
+	}
+
+	for _, obj := range objs {
+		if obj.Type() == sh.ListType {
+			return obj, nil
+		}
+	}
+
+	return nil, errors.NewError("Function returns %d values but none is a list to iterate",
+		len(objs))
+}
+
+// executeForPairs executes the loop over the list of pairs obj:
+//
+//	for (key, value) in (("k1" "v1") ("k2" "v2")) { ... }
+func (shell *Shell) executeForPairs(n *ast.ForNode, obj sh.Obj) ([]sh.Obj, error) {
+	list, ok := obj.(*sh.ListObj)
+	if !ok {
+		return nil, errors.NewEvalError(shell.filename, n.InExpr(),
+			"for (%s, %s) requires a list of pairs, but found %s",
+			n.Identifier(), n.ValueIdentifier(), obj.Type())
+	}
+
+	for i, elem := range list.List() {
+		pair, ok := elem.(*sh.ListObj)
+		if !ok {
+			return nil, errors.NewEvalError(shell.filename, n.InExpr(),
+				"for (%s, %s) requires a list of pairs, but element %d is %s",
+				n.Identifier(), n.ValueIdentifier(), i, elem.Type())
+		}
+
+		if pair.Len() != 2 {
+			return nil, errors.NewEvalError(shell.filename, n.InExpr(),
+				"for (%s, %s) requires a list of pairs, but element %d has %d values",
+				n.Identifier(), n.ValueIdentifier(), i, pair.Len())
+		}
+
+		shell.Newvar(n.Identifier(), pair.List()[0])
+		shell.Newvar(n.ValueIdentifier(), pair.List()[1])
 
-			return nil, newErrInterrupted("loop interrupted")
+		objs, stop, err := shell.executeForBody(n.Tree())
+		if stop || err != nil {
+			return objs, err
 		}
+	}
+
+	return nil, nil
+}
+
+// executeForBody executes one iteration of a for loop. The stop return
+// value reports if the loop must finish (eg.: a return or break was
+// executed inside the loop body).
+func (shell *Shell) executeForBody(tr *ast.Tree) ([]sh.Obj, bool, error) {
+	objs, err := shell.executeTree(tr, false)
+
+	type (
+		interruptedError interface {
+			Interrupted() bool
+		}
+
+		stopWalkingError interface {
+			StopWalking() bool
+		}
+
+		breakError interface {
+			Break() bool
+		}
+
+		continueError interface {
+			Continue() bool
+		}
+	)
 
+	if errBreak, ok := err.(breakError); ok && errBreak.Break() {
+		return nil, true, nil
+	}
+
+	if errContinue, ok := err.(continueError); ok && errContinue.Continue() {
+		err = nil
+	}
+
+	if errInterrupted, ok := err.(interruptedError); ok && errInterrupted.Interrupted() {
+		return nil, true, err
+	}
+
+	if errStopWalking, ok := err.(stopWalkingError); ok && errStopWalking.StopWalking() {
+		return objs, true, err
+	}
+
+	shell.Lock()
+
+	if shell.getIntr() {
+		shell.setIntr(false)
 		shell.Unlock()
 
+		if err != nil {
+			return nil, true, newErrInterrupted(err.Error())
+		}
+
+		return nil, true, newErrInterrupted("loop interrupted")
+	}
+
+	shell.Unlock()
+
+	if err != nil {
+		return nil, true, err
+	}
+
+	return nil, false, nil
+}
+
+// executeSwitch runs the block of the first case matching the value
+// of the switch. The default block runs if no case matches.
+func (shell *Shell) executeSwitch(n *ast.SwitchNode) ([]sh.Obj, error) {
+	value, err := shell.evalExpr(n.Value())
+	if err != nil {
+		return nil, err
+	} else if value == nil {
+		return nil, errors.NewEvalError(shell.filename,
+			n, "switch value doesn't yield value (%s)", n.Value())
+	}
+
+	if !isComparable(value) {
+		return nil, errors.NewEvalError(shell.filename,
+			n, "switch value is not comparable: (%v) -> %s.", value, value.Type())
+	}
+
+	for _, c := range n.Cases() {
+		ok, err := shell.matchCase(c, value)
 		if err != nil {
 			return nil, err
 		}
+
+		if ok {
+			return shell.executeTree(c.Tree(), false)
+		}
+	}
+
+	if n.DefaultTree() != nil {
+		return shell.executeTree(n.DefaultTree(), false)
 	}
 
 	return nil, nil
 }
 
+// matchCase tells if any pattern of the case matches the value. A
+// list pattern matches if the value is one of its elements.
+func (shell *Shell) matchCase(c *ast.CaseNode, value sh.Obj) (bool, error) {
+	for _, pattern := range c.Patterns() {
+		obj, err := shell.evalIfArgument(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		if !isComparable(obj) {
+			return false, errors.NewEvalError(shell.filename,
+				pattern, "case pattern is not comparable: (%v) -> %s.", obj, obj.Type())
+		}
+
+		var candidates []sh.Obj
+
+		if obj.Type() == sh.ListType && value.Type() != sh.ListType {
+			candidates = obj.(*sh.ListObj).List()
+		} else {
+			candidates = []sh.Obj{obj}
+		}
+
+		for _, candidate := range candidates {
+			if !c.IsGlob() {
+				if objEqual(value, candidate) {
+					return true, nil
+				}
+
+				continue
+			}
+
+			if value.Type() == sh.ListType || candidate.Type() == sh.ListType {
+				return false, errors.NewEvalError(shell.filename,
+					pattern, "glob patterns only match strings, but found %s and %s",
+					value.Type(), candidate.Type())
+			}
+
+			ok, err := path.Match(candidate.String(), value.String())
+			if err != nil {
+				return false, errors.NewEvalError(shell.filename,
+					pattern, "invalid glob pattern %q: %s", candidate, err)
+			}
+
+			if ok {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
 func (shell *Shell) executeFnDecl(n *ast.FnDeclNode) error {
-	fnDef, err := newUserFnDef(n.Name(), shell, n.Args(), n.Tree())
+	fnDef, err := newUserFnDef(n.Name(), shell, n.Args(),
+		n.ReturnTypes(), n.Tree())
 	if err != nil {
 		return err
 	}
@@ -2453,15 +3771,18 @@ func (shell *Shell) executeBindFn(n *ast.BindFnNode) error {
 }
 
 func (shell *Shell) executeIf(n *ast.IfNode) ([]sh.Obj, error) {
-	op := n.Op()
+	ok, err := shell.evalCond(n.Cond())
+	if err != nil {
+		return nil, err
+	}
 
-	if op == "==" {
-		return shell.executeIfEqual(n)
-	} else if op == "!=" {
-		return shell.executeIfNotEqual(n)
+	if ok {
+		return shell.executeTree(n.IfTree(), false)
+	} else if n.ElseTree() != nil {
+		return shell.executeTree(n.ElseTree(), false)
 	}
 
-	return nil, fmt.Errorf("invalid operation '%s'", op)
+	return nil, nil
 }
 
 func validateDirs(nashpath string, nashroot string) error {
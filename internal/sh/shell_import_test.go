@@ -103,6 +103,189 @@ func TestImportsLibFromWorkingDirBeforeLibAndStdlib(t *testing.T) {
 	`, "localcode\n")
 }
 
+func TestImportsLibAsModule(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	writeFile(t, filepath.Join(nashdirs.Lib, "counter.sh"), `
+		var count = "0"
+
+		fn counter_inc() {
+			count = $count+"1"
+			return $count
+		}
+
+		fn show() {
+			echo $count
+		}
+	`)
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+
+	shell.ExecCheckingOutput(t, `
+		var count = "main"
+		import counter as c
+		var res <= c.inc()
+		res <= c.counter_inc()
+		echo $res $count
+		c.show()
+	`, "011 main\n011\n")
+
+	shell.ExecCheckingOutput(t, `
+		import counter
+		var res <= counter_inc()
+		echo $res $count
+	`, "01 01\n")
+}
+
+func TestImportsModuleFnNotFound(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	writeFile(t, filepath.Join(nashdirs.Lib, "lib.sh"), `
+		fn test() {
+			echo "libcode"
+		}
+	`)
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+
+	for code, errmsg := range map[string]string{
+		`import lib as l
+		l.nope()`: "function 'nope' not found in module 'l'",
+		`lib.test()`: "module 'lib' not found",
+		`import lib as l
+		test()`: "function 'test' not found",
+	} {
+		err := shell.shell.Exec("moduletest", code)
+		if err == nil {
+			t.Fatalf("expected error %q, but got success", errmsg)
+		}
+
+		if !strings.HasSuffix(err.Error(), errmsg) {
+			t.Fatalf("expected error %q, but got %q", errmsg, err)
+		}
+	}
+}
+
+func TestImportsLibOnce(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	writeFile(t, filepath.Join(nashdirs.Lib, "lib.sh"), `
+		echo "imported"
+
+		fn test() {
+			echo "libcode"
+		}
+	`)
+
+	writeFile(t, filepath.Join(nashdirs.Lib, "other.sh"), `
+		import lib
+	`)
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+
+	shell.ExecCheckingOutput(t, `
+		import lib
+		import other
+		import lib
+		test()
+	`, "imported\nlibcode\n")
+
+	shell.ExecCheckingOutput(t, `
+		import lib as a
+		import lib as b
+		a.test()
+		b.test()
+	`, "imported\nlibcode\nlibcode\n")
+}
+
+func TestImportsCycle(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	a := filepath.Join(nashdirs.Lib, "a.sh")
+	b := filepath.Join(nashdirs.Lib, "b.sh")
+	main := filepath.Join(nashdirs.Lib, "main.sh")
+
+	writeFile(t, a, `import b`)
+	writeFile(t, b, `import a`)
+	writeFile(t, main, `import a`)
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+
+	a, _ = filepath.EvalSymlinks(a)
+	b, _ = filepath.EvalSymlinks(b)
+
+	for _, code := range []string{
+		`import a`,
+		`import a as mod`,
+	} {
+		err := shell.shell.Exec("cycletest", code)
+		if err == nil {
+			t.Fatalf("expected import cycle error on %q", code)
+		}
+
+		errmsg := "import cycle not allowed: <interactive> -> " +
+			a + " -> " + b + " -> " + a
+		if !strings.HasSuffix(err.Error(), errmsg) {
+			t.Fatalf("expected error %q, but got %q", errmsg, err)
+		}
+	}
+
+	main, _ = filepath.EvalSymlinks(main)
+
+	err := shell.shell.ExecFile(main)
+	if err == nil {
+		t.Fatalf("expected import cycle error on %q", main)
+	}
+
+	errmsg := "import cycle not allowed: " + main + " -> " +
+		a + " -> " + b + " -> " + a
+	if !strings.HasSuffix(err.Error(), errmsg) {
+		t.Fatalf("expected error %q, but got %q", errmsg, err)
+	}
+}
+
+func TestImportsCycleWithEntryScript(t *testing.T) {
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	a := filepath.Join(nashdirs.Lib, "a.sh")
+	b := filepath.Join(nashdirs.Lib, "b.sh")
+	c := filepath.Join(nashdirs.Lib, "c.sh")
+
+	writeFile(t, a, "echo a\nimport b")
+	writeFile(t, b, "import c")
+	writeFile(t, c, "import a")
+
+	a, _ = filepath.EvalSymlinks(a)
+	b, _ = filepath.EvalSymlinks(b)
+	c, _ = filepath.EvalSymlinks(c)
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+
+	err := shell.shell.ExecFile(a)
+	if err == nil {
+		t.Fatalf("expected import cycle error on %q", a)
+	}
+
+	errmsg := "import cycle not allowed: " + a + " -> " + b + " -> " +
+		c + " -> " + a
+	if !strings.HasSuffix(err.Error(), errmsg) {
+		t.Fatalf("expected error %q, but got %q", errmsg, err)
+	}
+
+	if out := shell.stdout.String(); out != "a\n" {
+		t.Fatalf("expected %q executed once, but got output %q", a, out)
+	}
+}
+
 func TestStdErrOnInvalidSearchPaths(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -529,6 +529,187 @@ var a = ".2"
 	}
 }
 
+func TestExecuteAppendRedirection(t *testing.T) {
+	pathobj, err := ioutil.TempFile("", "nash-append-redir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := pathobj.Name()
+	defer os.Remove(path)
+
+	_, err = pathobj.WriteString("zero\n")
+	pathobj.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testExec(t, execTestCase{
+		desc: "append",
+		code: `var log = "` + path + `"
+        echo one >> $log
+        echo two >>[1] $log
+        sh -c "echo three >&2" >>[2] $log
+        sh -c "echo four; echo five >&2" >>[1] $log >[2=1]
+        sh -c "echo six >&3" >>[3] $log
+        cat $log`,
+		expectedStdout: "zero\none\ntwo\nthree\nfour\nfive\nsix\n",
+	})
+
+	testExec(t, execTestCase{
+		desc: "truncate",
+		code: `echo seven > ` + path + `
+        cat ` + path,
+		expectedStdout: "seven\n",
+	})
+}
+
+func TestExecuteExtraFdRedirection(t *testing.T) {
+	pathobj, err := ioutil.TempFile("", "nash-fd-redir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := pathobj.Name()
+	pathobj.Close()
+	defer os.Remove(path)
+
+	for _, test := range []execTestCase{
+		{
+			desc: "fd to file",
+			code: `sh -c "echo hello >&3; echo world" >[3] ` + path + `
+        cat ` + path,
+			expectedStdout: "world\nhello\n",
+		},
+		{
+			desc:           "fd to stdout",
+			code:           `sh -c "echo hello >&3" >[3=1]`,
+			expectedStdout: "hello\n",
+		},
+		{
+			desc:           "fd to stderr",
+			code:           `sh -c "echo hello >&3" >[3=2]`,
+			expectedStderr: "hello\n",
+		},
+		{
+			desc: "fd captured",
+			code: `var out <= sh -c "echo hello >&3" >[3=1]
+        echo $out`,
+			expectedStdout: "hello\n",
+		},
+		{
+			desc:           "fd suppressed",
+			code:           `sh -c "echo hello >&3; echo world" >[3=]`,
+			expectedStdout: "world\n",
+		},
+		{
+			desc: "fd to fd",
+			code: `sh -c "echo hello >&4" >[3] ` + path + ` >[4=3]
+        cat ` + path,
+			expectedStdout: "hello\n",
+		},
+		{
+			desc:           "stdout to fd",
+			code:           `sh -c "echo hello" >[3=2] >[1=3]`,
+			expectedStderr: "hello\n",
+		},
+		{
+			desc:        "fd not open",
+			code:        `echo hello >[1=3]`,
+			expectedErr: "<interactive>:1:11: Invalid redirect mapping: 1 -> 3 (fd 3 is not open)",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteInputRedirection(t *testing.T) {
+	pathobj, err := ioutil.TempFile("", "nash-input-redir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := pathobj.Name()
+	defer os.Remove(path)
+
+	_, err = pathobj.WriteString("hello\nworld\n")
+	pathobj.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []execTestCase{
+		{
+			desc:           "file",
+			code:           `cat <[0] ` + path,
+			expectedStdout: "hello\nworld\n",
+		},
+		{
+			desc: "file from variable",
+			code: `var location = "` + path + `"
+        cat < $location | grep w`,
+			expectedStdout: "world\n",
+		},
+		{
+			desc: "here-string",
+			code: `var query = "select 1"
+        cat <<< $query`,
+			expectedStdout: "select 1",
+		},
+		{
+			desc: "here-string concat",
+			code: `var name = "nash"
+        var out <= tr a-z A-Z <<< "hello "+$name
+        echo $out`,
+			expectedStdout: "HELLO NASH\n",
+		},
+		{
+			desc: "here-string list",
+			code: `var l = (a b)
+        cat <<< $l`,
+			expectedErr: "<interactive>:2:16: Here-string requires a string, but found a b (ListType)",
+		},
+		{
+			desc:        "invalid fd",
+			code:        `cat <[1] ` + path,
+			expectedErr: "<interactive>:1:4: Invalid file descriptor redirection: fd=1",
+		},
+		{
+			desc:        "udp input",
+			code:        `cat < "udp://localhost:6667"`,
+			expectedErr: "<interactive>:1:7: Input redirection does not support udp: udp://localhost:6667",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestTCPInputRedirection(t *testing.T) {
+	message := "hello world"
+
+	l, err := net.Listen("tcp", ":4668")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		conn.Write([]byte(message))
+		conn.Close()
+	}()
+
+	testExec(t, execTestCase{
+		desc:           "tcp input",
+		code:           `cat <[0] "tcp://localhost:4668"`,
+		expectedStdout: message,
+	})
+}
+
 func TestExecuteRedirectionMap(t *testing.T) {
 	f, teardown := setup(t)
 	defer teardown()
@@ -795,6 +976,414 @@ func TestExecuteIfEqual(t *testing.T) {
 	}
 }
 
+func TestExecuteIfBooleanExpr(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "if and",
+			code: `var a, b = "1", "2"
+        if $a == "1" && $b == "2" {
+            echo -n "ok"
+        }
+        if $a == "1" && $b == "3" {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if or",
+			code: `var a, b = "1", "2"
+        if $a == "0" || $b == "2" {
+            echo -n "ok"
+        }
+        if $a == "0" || $b == "0" {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if not",
+			code: `var a = "1"
+        if !$a == "0" {
+            echo -n "ok"
+        }
+        if !($a == "1") {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if precedence",
+			code: `var a = "1"
+        if $a == "1" || $a == "0" && $a == "0" {
+            echo -n "ok"
+        }
+        if ($a == "1" || $a == "0") && $a == "0" {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if short circuit",
+			code: `fn fail() {
+            echo -n "fail"
+            return "1"
+        }
+        if "1" == "1" || fail() == "1" {
+            echo -n "ok"
+        }
+        if "1" == "0" && fail() == "1" {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if numeric comparison",
+			code: `var a = ("1" "2" "3" "4" "5" "6" "7" "8" "9" "10")
+        var l <= len($a)
+        if $l > "9" && "9" < $l && !($l < "10") {
+            echo -n "ok"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if numeric comparison with non numbers",
+			code: `
+        if "a" < "b" {
+            echo -n "fail"
+        }`,
+			expectedErr: "<interactive>:2:12: Expected a number, but found 'a'",
+		},
+		{
+			desc: "if list equality",
+			code: `var a = ("1" ("2" "3"))
+        var b = ("1" ("2" "3"))
+        if $a == $b && $a == ("1" ("2" "3")) {
+            echo -n "ok"
+        }
+        if $a != ("1" "2" "3") && $a != "1" {
+            echo -n "ok"
+        }`,
+			expectedStdout: "okok",
+		},
+		{
+			desc: "if map is not comparable",
+			code: `var a = {"a": "1"}
+        if $a == "1" {
+            echo -n "fail"
+        }`,
+			expectedErr: "<interactive>:2:11: lvalue is not comparable: ({a: 1}) -> MapType.",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteIfCommand(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "if command succeeds",
+			code: `
+        if true {
+            echo -n "ok"
+        }
+        if false {
+            echo -n "fail"
+        } else {
+            echo -n "ok"
+        }`,
+			expectedStdout: "okok",
+		},
+		{
+			desc: "if not command",
+			code: `
+        if !false {
+            echo -n "ok"
+        }
+        if !true {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if command not found",
+			code: `
+        if nonexistentcommand-nash-test {
+            echo -n "fail"
+        }
+        echo -n "ok"`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if pipe",
+			code: `
+        if echo hello | grep -q hell {
+            echo -n "ok"
+        }
+        if echo hello | grep -q world {
+            echo -n "fail"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "if command with boolean operators",
+			code: `var a = "1"
+        if $a == "1" && !false {
+            echo -n "ok"
+        }
+        if (false || echo -n "ok") && !(echo hello | grep -q world) {
+            echo -n "ok"
+        }`,
+			expectedStdout: "okokok",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteArithmetic(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "int assignment",
+			code: `var a, b = 1, -2
+        echo $a $b`,
+			expectedStdout: "1 -2\n",
+		},
+		{
+			desc: "int operations",
+			code: `var a = 7
+        var sum = $a + 3
+        var sub = $a - 10
+        var mul = $a * 3
+        var div = $a / 2
+        var mod = $a % 4
+        echo $sum $sub $mul $div $mod`,
+			expectedStdout: "10 -3 21 3 3\n",
+		},
+		{
+			desc: "int precedence",
+			code: `var a = 2 + 3 * 4 - 10 / 5 % 3
+        echo $a`,
+			expectedStdout: "12\n",
+		},
+		{
+			desc: "counter",
+			code: `var i = 0
+        for x in ("a" "b" "c") {
+            i = $i + 1
+        }
+        echo $i`,
+			expectedStdout: "3\n",
+		},
+		{
+			desc: "int function args and return",
+			code: `fn double(n) {
+            return $n * 2
+        }
+        var r <= double(21)
+        var r2 <= double($r + 1)
+        echo $r $r2`,
+			expectedStdout: "42 86\n",
+		},
+		{
+			desc: "int comparison",
+			code: `var a = 10
+        if $a > 9 && $a < 11 && $a == 10 && $a != 11 && $a - 1 == 9 {
+            echo -n "ok"
+        }`,
+			expectedStdout: "ok",
+		},
+		{
+			desc: "int and numeric string comparison",
+			code: `var i = 3
+        if $i == "3" && "3" == $i && $i != "4" && $i != "three" && $i != "03" {
+            echo -n "ok"
+        }
+        var l = (1 2)
+        if $l == ("1" "2") {
+            echo -n " list"
+        }`,
+			expectedStdout: "ok list",
+		},
+		{
+			desc: "loop index and switch on numeric strings",
+			code: `for i, v in (a b c) {
+            if $i == "1" {
+                echo $v
+            }
+            switch $i {
+                case "2" {
+                    echo "two"
+                }
+            }
+        }`,
+			expectedStdout: "b\ntwo\n",
+		},
+		{
+			desc: "len in arithmetic",
+			code: `var l = (a b c)
+        var n <= len($l)
+        var size <= atoi($n)
+        var last = $size - 1
+        echo $l[$last]`,
+			expectedStdout: "c\n",
+		},
+		{
+			desc: "int indexing",
+			code: `var l = ("a" "b" "c")
+        var i = 1
+        echo $l[$i]`,
+			expectedStdout: "b\n",
+		},
+		{
+			desc: "string concat",
+			code: `var a = "1" + "2"
+        echo $a`,
+			expectedStdout: "12\n",
+		},
+		{
+			desc:        "mixing int and string",
+			code:        `var a = 1 + "2"`,
+			expectedErr: "<interactive>:1:13: Invalid operation '+' between IntType and StringType: 1 + \"2\"",
+		},
+		{
+			desc:        "mixing string and int",
+			code:        `var a = "1" + 2`,
+			expectedErr: "<interactive>:1:14: Invalid operation '+' between StringType and IntType: \"1\" + 2",
+		},
+		{
+			desc:        "arithmetic on strings",
+			code:        `var a = "2" * 2`,
+			expectedErr: "<interactive>:1:9: Invalid operation '*' on StringType: \"2\" * 2",
+		},
+		{
+			desc: "sum overflow",
+			code: `var max = 9223372036854775807
+        var a = $max + 1`,
+			expectedErr: "<interactive>:2:23: Integer overflow in operation '+': $max + 1",
+		},
+		{
+			desc: "subtraction overflow",
+			code: `var min = -9223372036854775807 - 1
+        var a = $min - 1`,
+			expectedErr: "<interactive>:2:16: Integer overflow in operation '-': $min - 1",
+		},
+		{
+			desc: "multiplication overflow",
+			code: `var min = -9223372036854775807 - 1
+        var a = $min * -1`,
+			expectedErr: "<interactive>:2:16: Integer overflow in operation '*': $min * -1",
+		},
+		{
+			desc: "division by zero",
+			code: `var a = 0
+        var b = 1 / $a`,
+			expectedErr: "<interactive>:2:16: Division by zero: 1 / $a",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteForCond(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "count",
+			code: `var i = 0
+        for $i < 3 {
+            echo $i
+            i = $i + 1
+        }`,
+			expectedStdout: "0\n1\n2\n",
+		},
+		{
+			desc: "false condition",
+			code: `for "a" == "b" {
+            echo "never"
+        }
+        echo "done"`,
+			expectedStdout: "done\n",
+		},
+		{
+			desc: "command condition",
+			code: `var s = ""
+        for test -z $s {
+            echo "once"
+            s = "x"
+        }`,
+			expectedStdout: "once\n",
+		},
+		{
+			desc: "invalid condition",
+			code: `for "a" < 2 {
+            echo "never"
+        }`,
+			expectedErr: "<interactive>:1:5: Expected a number, but found 'a'",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteBreakContinue(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "break inf loop",
+			code: `var i = 0
+        for {
+            if $i == 3 {
+                break
+            }
+            echo $i
+            i = $i + 1
+        }`,
+			expectedStdout: "0\n1\n2\n",
+		},
+		{
+			desc: "continue",
+			code: `for i in (a b c d) {
+            if $i == "b" || $i == "c" {
+                continue
+            }
+            echo $i
+        }`,
+			expectedStdout: "a\nd\n",
+		},
+		{
+			desc: "break only innermost loop",
+			code: `for i in (a b) {
+            for j in (1 2 3) {
+                if $j == "2" {
+                    break
+                }
+                echo $i $j
+            }
+        }`,
+			expectedStdout: "a 1\nb 1\n",
+		},
+		{
+			desc: "break inside fn loop",
+			code: `fn first(l) {
+            var res = ""
+            for v in $l {
+                res = $v
+                break
+            }
+            return $res
+        }
+        var f <= first((x y z))
+        echo $f`,
+			expectedStdout: "x\n",
+		},
+		{
+			desc:        "break outside loop",
+			code:        `break`,
+			expectedErr: "break outside loop:1:0: Unexpected 'break' outside of for loop",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
 func TestExecuteIfElse(t *testing.T) {
 	f, teardown := setup(t)
 	defer teardown()
@@ -882,6 +1471,121 @@ func TestExecuteIfElseIf(t *testing.T) {
 	}
 }
 
+func TestExecuteSwitch(t *testing.T) {
+	dispatch := `
+		var exts = (".tar" ".zip")
+
+		fn dispatch(cmd) {
+			switch $cmd {
+				case "start", "run" {
+					echo "starting"
+				}
+				case glob "*.tar", "*.tgz" {
+					echo "archive"
+				}
+				case $exts, ("a" "b") {
+					echo "member"
+				}
+				case 1 {
+					echo "integer"
+				}
+				default {
+					echo "unknown" $cmd
+				}
+			}
+		}
+	`
+
+	for _, test := range []execTestCase{
+		{
+			desc: "switch literals",
+			code: dispatch + `
+				dispatch("start")
+				dispatch("run")
+				dispatch("stop")
+			`,
+			expectedStdout: "starting\nstarting\nunknown stop\n",
+		},
+		{
+			desc: "switch glob",
+			code: dispatch + `
+				dispatch("nash.tar")
+				dispatch("nash.tgz")
+				dispatch("nash.zip")
+			`,
+			expectedStdout: "archive\narchive\nunknown nash.zip\n",
+		},
+		{
+			desc: "switch list membership",
+			code: dispatch + `
+				dispatch(".zip")
+				dispatch("b")
+				dispatch(1)
+				dispatch("1")
+			`,
+			expectedStdout: "member\nmember\ninteger\ninteger\n",
+		},
+		{
+			desc: "switch without default",
+			code: `switch "x" {
+				case "y" {
+					echo "y"
+				}
+			}
+			echo "done"`,
+			expectedStdout: "done\n",
+		},
+		{
+			desc: "switch list value",
+			code: `var l = ("a" "b")
+			switch $l {
+				case ("a") {
+					echo "member"
+				}
+				case ("a" "b") {
+					echo "equal"
+				}
+			}`,
+			expectedStdout: "equal\n",
+		},
+		{
+			desc: "break inside switch",
+			code: `for i in ("1" "2" "3") {
+				switch $i {
+					case "2" {
+						break
+					}
+					default {
+						echo $i
+					}
+				}
+			}`,
+			expectedStdout: "1\n",
+		},
+		{
+			desc: "switch invalid glob",
+			code: `switch "x" {
+				case glob "[" {
+				}
+			}`,
+			expectedErr: "<interactive>:2:15: invalid glob pattern \"[\": syntax error in pattern",
+		},
+		{
+			desc: "switch map",
+			code: `var m = {}
+			switch $m {
+				default {
+				}
+			}`,
+			expectedErr: "<interactive>:2:3: switch value is not comparable: ({}) -> MapType.",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
 func TestExecuteFnDecl(t *testing.T) {
 	f, teardown := setup(t)
 	defer teardown()
@@ -1176,7 +1880,7 @@ func TestExecuteBindFn(t *testing.T) {
 				bindfn foo bar
 				bar test test
 			`,
-			expectedErr: "Wrong number of arguments for function foo. Expected 1 but found 2",
+			expectedErr: "Wrong number of arguments for function foo(line). Expected 1 but found 2",
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
@@ -1254,6 +1958,481 @@ func TestExecuteRedirectionPipe(t *testing.T) {
 	}
 }
 
+func TestExecutePipeFn(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "fn in the middle",
+			code: `fn filter_errors() {
+				grep ERROR
+			}
+			printf "a\nERROR b\nc\nERROR d\n" | filter_errors | wc -l | tr -d "[:space:]"`,
+			expectedStdout: "2",
+		},
+		{
+			desc: "fn first",
+			code: `fn gen(n) {
+				echo line $n
+				echo other $n
+			}
+			gen 1 | tr a-z A-Z`,
+			expectedStdout: "LINE 1\nOTHER 1\n",
+		},
+		{
+			desc: "fn last",
+			code: `fn upper() {
+				tr a-z A-Z
+			}
+			echo hello | upper`,
+			expectedStdout: "HELLO\n",
+		},
+		{
+			desc: "only fns",
+			code: `fn gen() {
+				echo hello
+				echo world
+			}
+			fn upper() {
+				tr a-z A-Z
+			}
+			fn count() {
+				wc -l | tr -d "[:space:]"
+			}
+			gen | upper | count`,
+			expectedStdout: "2",
+		},
+		{
+			desc: "fn reading large input",
+			code: `fn upper() {
+				tr a-z A-Z
+			}
+			seq 1 100000 | upper | wc -l | tr -d "[:space:]"`,
+			expectedStdout: "100000",
+		},
+		{
+			desc: "fn writing to finished stage",
+			code: `fn yes() {
+				for {
+					echo y
+				}
+			}
+			-yes | head -n 1`,
+			expectedStdout: "y\n",
+		},
+		{
+			desc: "fn error",
+			code: `fn fail() {
+				cat
+				false
+			}
+			echo hello | fail | cat`,
+			expectedStdout: "hello\n",
+			expectedErr:    "<interactive>:5:14: success|exit status 1|success",
+		},
+		{
+			desc: "fn capture",
+			code: `fn upper() {
+				tr a-z A-Z
+			}
+			var out <= echo hello | upper
+			echo $out`,
+			expectedStdout: "HELLO\n",
+		},
+		{
+			desc: "fn as standalone command",
+			code: `fn upper() {
+				tr a-z A-Z
+			}
+			fn greet(name) {
+				echo hello $name
+			}
+			greet nash | upper
+			upper <<< "abc"`,
+			expectedStdout: "HELLO NASH\nABC",
+		},
+		{
+			desc: "fn named as an executable",
+			code: `fn wc() {
+				echo "fn wc"
+				cat
+			}
+			echo hello | wc
+			wc -c <<< "ab"`,
+			expectedStdout: "fn wc\nhello\n2\n",
+		},
+		{
+			desc: "fn wrapping the command with its name",
+			code: `fn tr(args...) {
+				echo -n "wrapped "
+				tr $args
+			}
+			echo hello | tr a-z A-Z
+			tr a-z A-Z <<< "abc"`,
+			expectedStdout: "wrapped HELLO\nABC",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecutePipeStderr(t *testing.T) {
+	// the streams not piped are discarded to avoid concurrent
+	// writes of the stages into the same buffers.
+	for _, test := range []execTestCase{
+		{
+			desc:           "stderr only",
+			code:           `sh -c "echo out; echo err >&2" >[1=] |[2] tr a-z A-Z`,
+			expectedStdout: "ERR\n",
+		},
+		{
+			desc:           "stdout and stderr",
+			code:           `sh -c "echo out; echo err >&2" |[1,2] tr a-z A-Z`,
+			expectedStdout: "OUT\nERR\n",
+		},
+		{
+			desc:           "stdout explicit",
+			code:           `sh -c "echo out; echo err >&2" >[2=] |[1] tr a-z A-Z`,
+			expectedStdout: "OUT\n",
+		},
+		{
+			desc: "middle of pipe",
+			code: `(sh -c "echo out; echo err >&2" >[2=] |
+				sh -c "cat >&2" >[1=] |[2]
+				tr a-z A-Z)`,
+			expectedStdout: "OUT\n",
+		},
+		{
+			desc: "fn stderr",
+			code: `fn build() {
+				echo building
+				sh -c "echo failed >&2"
+			}
+			build >[1=] |[2] tr a-z A-Z`,
+			expectedStdout: "FAILED\n",
+		},
+		{
+			desc: "fn stdout and stderr",
+			code: `fn build() {
+				echo building
+				sh -c "echo failed >&2"
+			}
+			build |[1,2] tr a-z A-Z`,
+			expectedStdout: "BUILDING\nFAILED\n",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteJobControl(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "list jobs",
+			code: `var job <= sleep 0.5 >[1=] >[2=] &
+			jobs()
+			wait($job)`,
+			expectedStdout: "[1] Running\tsleep 0.5 >[1=] >[2=] &\n",
+		},
+		{
+			desc:           "no jobs",
+			code:           `jobs()`,
+			expectedStdout: "",
+		},
+		{
+			desc:        "jobs with arguments",
+			code:        `jobs("1")`,
+			expectedErr: "<interactive>:1:0: jobs expects no arguments, but received 1",
+		},
+		{
+			desc:        "fg without job control",
+			code:        `fg()`,
+			expectedErr: "<interactive>:1:0: fg: no job control",
+		},
+		{
+			desc:        "bg without job control",
+			code:        `bg("%1")`,
+			expectedErr: "<interactive>:1:0: bg: no job control",
+		},
+		{
+			desc:        "fg invalid job",
+			code:        `fg("a")`,
+			expectedErr: "<interactive>:1:0: fg: invalid job \"a\"",
+		},
+	} {
+		testExec(t, test)
+	}
+
+	t.Run("requires a terminal", func(t *testing.T) {
+		f, teardown := setup(t)
+		defer teardown()
+
+		f.shell.SetStdin(bytes.NewBufferString(""))
+
+		err := f.shell.SetJobControl(true)
+		if err == nil {
+			t.Fatal("expected error enabling job control")
+		}
+
+		if err.Error() != "job control requires a terminal" {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("notify finished jobs", func(t *testing.T) {
+		f, teardown := setup(t)
+		defer teardown()
+
+		var stdout, stderr bytes.Buffer
+
+		f.shell.SetStdout(&stdout)
+		f.shell.SetStderr(&stderr)
+
+		err := f.shell.Exec("notify", `true &
+			sleep 5 &`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 50; i++ {
+			stdout.Reset()
+
+			err = f.shell.Exec("notify", `jobs()`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.HasPrefix(stdout.String(), "[1] Done") {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		f.shell.NotifyJobs()
+
+		if stderr.String() != "[1]+ Done\ttrue &\n" {
+			t.Fatalf("unexpected notification: %q", stderr.String())
+		}
+
+		stdout.Reset()
+		stderr.Reset()
+
+		err = f.shell.Exec("notify", `jobs()`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stdout.String() != "[2] Running\tsleep 5 &\n" {
+			t.Fatalf("unexpected jobs: %q", stdout.String())
+		}
+
+		f.shell.NotifyJobs()
+
+		if stderr.String() != "" {
+			t.Fatalf("unexpected notification: %q", stderr.String())
+		}
+	})
+}
+
+func TestExecuteBackground(t *testing.T) {
+	// the outputs of jobs running at the same time are discarded to
+	// avoid concurrent writes into the same buffers.
+	for _, test := range []execTestCase{
+		{
+			desc: "wait job",
+			code: `var job <= sh -c "sleep 0.2; echo done" &
+			wait($job)
+			echo waited`,
+			expectedStdout: "done\nwaited\n",
+		},
+		{
+			desc: "parallel jobs",
+			code: `var a <= sleep 1 >[1=] >[2=] &
+			var b <= sleep 1 >[1=] >[2=] &
+			var start <= date "+%s"
+			wait($a, $b)
+			var end <= date "+%s"
+			var elapsed <= atoi($end)
+			var starttime <= atoi($start)
+			elapsed = $elapsed - $starttime
+			if $elapsed < 2 {
+				echo parallel
+			}`,
+			expectedStdout: "parallel\n",
+		},
+		{
+			desc: "wait all",
+			code: `var jobs = ()
+			for i in (1 2 3) {
+				var job <= sh -c "echo "+$i >[1=] >[2=] &
+				jobs <= append($jobs, $job)
+			}
+			sh -c "exit 0" >[2=] &
+			wait()
+			var l <= len($jobs)
+			echo $l`,
+			expectedStdout: "3\n",
+		},
+		{
+			desc: "background pipe",
+			code: `var job <= echo hello | tr a-z A-Z &
+			wait($job)`,
+			expectedStdout: "HELLO\n",
+		},
+		{
+			desc: "wait status",
+			code: `var job <= -sh -c "exit 4" &
+			var status <= wait($job)
+			echo $status`,
+			expectedStdout: "4\n",
+		},
+		{
+			desc: "failed job",
+			code: `var job <= sh -c "exit 3" &
+			wait($job)
+			echo not reached`,
+			expectedErr: "<interactive>:2:3: job 1 (sh -c \"exit 3\" &) failed: exit status 3",
+		},
+		{
+			desc: "command not found",
+			code: `var job <= command-does-not-exists &
+			wait($job)`,
+			expectedPrefixErr: "<interactive>:2:3: job 1 (command-does-not-exists &) failed: exec: ",
+		},
+		{
+			desc: "job waited twice",
+			code: `var job <= true &
+			wait($job)
+			wait($job)`,
+			expectedErr: "<interactive>:3:3: job 1 not found",
+		},
+		{
+			desc: "job of fn",
+			code: `fn download(url) {
+				var job <= echo $url &
+				return $job
+			}
+			var job <= download("http://nash")
+			wait($job)`,
+			expectedStdout: "http://nash\n",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteSpawn(t *testing.T) {
+	// only the main process writes into the outputs to avoid
+	// concurrent writes into the same buffers.
+	for _, test := range []execTestCase{
+		{
+			desc: "ping pong",
+			code: `var pid <= spawn fn (answer) {
+				var msg <= receive()
+				send($answer, $msg+" pong")
+			}(self())
+			send($pid, "ping")
+			var msg <= receive()
+			echo $msg`,
+			expectedStdout: "ping pong\n",
+		},
+		{
+			desc: "spawn declared fn",
+			code: `fn double(answer, n) {
+				var res = $n+$n
+				send($answer, $res)
+			}
+			spawn double(self(), "ab")
+			var res <= receive()
+			echo $res`,
+			expectedStdout: "abab\n",
+		},
+		{
+			desc: "self of main process",
+			code: `var pid <= self()
+			echo $pid`,
+			expectedStdout: "1\n",
+		},
+		{
+			desc: "receive timeout",
+			code: `var msg, status <= receive("100ms")
+			echo "["+$msg+"]" $status`,
+			expectedStdout: "[] 1\n",
+		},
+		{
+			desc: "receive before timeout",
+			code: `var me <= self()
+			send($me, "hello")
+			var msg, status <= receive("1s")
+			echo $msg $status`,
+			expectedStdout: "hello 0\n",
+		},
+		{
+			desc: "receive without timeout returns only the message",
+			code: `var me <= self()
+			send($me, "hello")
+			var msg, status <= receive()`,
+			expectedErr: "<interactive>:3:7: Functions returns 1 objects, but statement expects 2",
+		},
+		{
+			desc: "receive with timeout returns the status",
+			code: `var me <= self()
+			send($me, "hello")
+			var msg <= receive("1s")`,
+			expectedErr: "<interactive>:3:7: Functions returns 2 objects, but statement expects 1",
+		},
+		{
+			desc: "send to unknown process",
+			code: `var status <= send("100", "hello")
+			echo $status`,
+			expectedStdout: "1\n",
+		},
+		{
+			desc: "messages are copied",
+			code: `var list = (a b)
+			var pid <= spawn fn (answer) {
+				var l <= receive()
+				l[0] = "changed"
+				list[1] = "changed"
+				send($answer, $l)
+			}(self())
+			send($pid, $list)
+			var l <= receive()
+			echo $list $l`,
+			expectedStdout: "a b changed b\n",
+		},
+		{
+			desc: "fan out",
+			code: `var me <= self()
+			for i in (1 2 3) {
+				spawn fn (n) {
+					send($me, $n)
+				}($i)
+			}
+			var got = ()
+			for i in (1 2 3) {
+				var n <= receive()
+				got <= append($got, $n)
+			}
+			var l <= len($got)
+			echo $l`,
+			expectedStdout: "3\n",
+		},
+		{
+			desc: "send functions",
+			code: `fn f() {}
+			send("1", $f)`,
+			expectedErr: "<interactive>:2:3: send: functions can't be sent to processes",
+		},
+		{
+			desc:        "spawn command",
+			code:        `spawn echo hello`,
+			expectedErr: "spawn command:1:6: Unexpected token IDENT. Spawn expects a function invocation",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
 func testTCPRedirection(t *testing.T, port, command string) {
 	message := "hello world"
 	done := make(chan error)
@@ -1529,6 +2708,169 @@ echo -n $a`,
 	}
 }
 
+func TestExecuteDefer(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc:        "defer invalid",
+			code:        `defer echo hello`,
+			expectedErr: "<interactive>:1:0: Unexpected defer outside of function declaration.",
+		},
+		{
+			desc: "defer runs in reverse order",
+			code: `fn test() {
+	defer echo first
+	defer echo second
+	echo body
+}
+test()`,
+			expectedStdout: "body\nsecond\nfirst\n",
+		},
+		{
+			desc: "defer runs on return",
+			code: `fn test() {
+	defer echo cleanup
+	if "1" == "1" {
+		return "1"
+	}
+	echo unreachable
+}
+var res <= test()
+echo $res`,
+			expectedStdout: "cleanup\n1\n",
+		},
+		{
+			desc: "defer saves the variables",
+			code: `fn test() {
+	for i in (1 2 3) {
+		defer echo cleanup $i
+	}
+	var i = "4"
+}
+test()`,
+			expectedStdout: "cleanup 3\ncleanup 2\ncleanup 1\n",
+		},
+		{
+			desc: "defer fn",
+			code: `fn cleanup(name) {
+	echo cleanup $name
+}
+fn test() {
+	defer cleanup("test")
+}
+test()`,
+			expectedStdout: "cleanup test\n",
+		},
+		{
+			desc: "defer runs on error",
+			code: `fn test() {
+	defer echo cleanup
+	false
+	echo unreachable
+}
+test()`,
+			expectedStdout: "cleanup\n",
+			expectedErr:    "<interactive>:6:0: exit status 1",
+		},
+		{
+			desc: "defer preserves the error",
+			code: `fn test() {
+	defer echo cleanup
+	defer sh -c "exit 2"
+	false
+}
+test()`,
+			expectedStdout: "cleanup\n",
+			expectedStderr: "defer sh -c \"exit 2\": exit status 2\n",
+			expectedErr:    "<interactive>:6:0: exit status 1",
+		},
+		{
+			desc: "defer error",
+			code: `fn test() {
+	defer false
+	defer -false
+	echo body
+}
+test()`,
+			expectedStdout: "body\n",
+			expectedErr:    "<interactive>:6:0: exit status 1",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteTrap(t *testing.T) {
+	// waits the handler to set $handled, giving up after 10s
+	const waitHandled = `var tries = 0
+for $handled == "0" && $tries < 1000 {
+	sleep 0.01
+	tries = $tries + 1
+}`
+
+	for _, test := range []execTestCase{
+		{
+			desc: "trap signal",
+			code: `var handled = "0"
+fn handler() {
+	echo handled
+	handled = "1"
+}
+trap("USR1", $handler)
+sh -c "kill -USR1 $PPID"
+` + waitHandled + `
+echo after`,
+			expectedStdout: "handled\nafter\n",
+		},
+		{
+			desc: "trap signal with prefix",
+			code: `var handled = "0"
+fn handler() {
+	echo handled
+	handled = "1"
+}
+trap("SIGUSR1", $handler)
+sh -c "kill -USR1 $PPID"
+` + waitHandled + `
+trap("USR1")`,
+			expectedStdout: "handled\n",
+		},
+		{
+			desc:        "trap invalid signal",
+			code:        `trap("KILL")`,
+			expectedErr: `<interactive>:1:0: trap: invalid signal "KILL". Expected one of: EXIT, HUP, INT, TERM, USR1`,
+		},
+		{
+			desc:        "trap without function",
+			code:        `trap("TERM", "cleanup")`,
+			expectedErr: "<interactive>:1:0: trap expects a function, but a StringType was provided",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
+func TestExecuteTrapExit(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	err := f.shell.Exec("trap exit", `fn bye() {
+	echo bye
+}
+trap("EXIT", $bye)
+echo hello`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.shell.RunExitTrap()
+	f.shell.RunExitTrap()
+
+	if out := f.shellOut.String(); out != "hello\nbye\n" {
+		t.Fatalf("Unexpected output: %q", out)
+	}
+}
+
 func TestExecuteFnAsFirstClass(t *testing.T) {
 
 	f, teardown := setup(t)
@@ -1633,6 +2975,172 @@ loop`
 
 }
 
+func TestExecuteForPairs(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "index and value",
+			code: `var files = ("a" "b" "c")
+			for i, f in $files {
+				echo $i $f $files[$i]
+			}`,
+			expectedStdout: "0 a a\n1 b b\n2 c c\n",
+		},
+		{
+			desc: "list of pairs by index",
+			code: `var points = (("1" "2") ("3" "4"))
+			for i, p in $points {
+				echo $i $p
+			}`,
+			expectedStdout: "0 1 2\n1 3 4\n",
+		},
+		{
+			desc: "list of pairs",
+			code: `var pairs = (("user" "nash") ("shell" "rc"))
+			for (k, v) in $pairs {
+				echo $k $v
+			}`,
+			expectedStdout: "user nash\nshell rc\n",
+		},
+		{
+			desc: "list of pairs literal",
+			code: `for (k, v) in (("a" "b") ("c" "d")) {
+				echo $k $v
+			}`,
+			expectedStdout: "a b\nc d\n",
+		},
+		{
+			desc: "malformed pair",
+			code: `var pairs = (("a" "b") ("c" "d" "e"))
+			for (k, v) in $pairs {
+				echo $k $v
+			}`,
+			expectedStdout: "a b\n",
+			expectedErr:    "<interactive>:2:17: for (k, v) requires a list of pairs, but element 1 has 3 values",
+		},
+		{
+			desc: "pairs of a string",
+			code: `var pairs = "a"
+			for (k, v) in $pairs {
+				echo $k $v
+			}`,
+			expectedErr: "<interactive>:2:17: for (k, v) requires a list of pairs, but found StringType",
+		},
+		{
+			desc: "grouped condition",
+			code: `var i = 0
+			for ($i < 2) && $i != 5 {
+				echo $i
+				i = $i + 1
+			}`,
+			expectedStdout: "0\n1\n",
+		},
+		{
+			desc: "multiple returns",
+			code: `fn files() {
+				return ("a" "b"), "0"
+			}
+
+			for f in files() {
+				echo $f
+			}
+
+			for i, f in files() {
+				echo $i $f
+			}`,
+			expectedStdout: "a\nb\n0 a\n1 b\n",
+		},
+		{
+			desc: "multiple returns without list",
+			code: `fn values() {
+				return "a", "b"
+			}
+
+			for v in values() {
+				echo $v
+			}`,
+			expectedErr: "<interactive>:5:12: Function returns 2 values but none is a list to iterate: values()",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
+func TestExecuteForCmd(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "lines",
+			code: `for line in <= printf "a\nb c\nd" {
+				echo "line:" $line
+			}`,
+			expectedStdout: "line: a\nline: b c\nline: d\n",
+		},
+		{
+			desc: "index and line",
+			code: `for i, line in <= seq 3 5 {
+				echo $i $line
+			}`,
+			expectedStdout: "0 3\n1 4\n2 5\n",
+		},
+		{
+			desc: "no output",
+			code: `for line in <= true {
+				echo $line
+			}
+			echo done`,
+			expectedStdout: "done\n",
+		},
+		{
+			desc: "break kills the command",
+			code: `for line in <= yes {
+				echo $line
+				break
+			}
+			echo done`,
+			expectedStdout: "y\ndone\n",
+		},
+		{
+			desc: "return kills the command",
+			code: `fn first() {
+				for line in <= seq 1 1000000 {
+					return $line
+				}
+			}
+
+			var v <= first()
+			echo $v`,
+			expectedStdout: "1\n",
+		},
+		{
+			desc: "failing body kills the command",
+			code: `for line in <= sh -c "echo a; exec sleep 10" {
+				echo $line
+				false
+			}`,
+			expectedStdout: "a\n",
+			expectedErr:    "exit status 1",
+		},
+		{
+			desc:        "failing command",
+			code:        `for line in <= false { echo $line }`,
+			expectedErr: "exit status 1",
+		},
+		{
+			desc: "ignoring the command failure",
+			code: `for line in <= -sh -c "echo a; exit 1" {
+				echo $line
+			}
+			echo done`,
+			expectedStdout: "a\ndone\n",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
 func TestExecuteInfiniteLoop(t *testing.T) {
 	f, teardown := setup(t)
 	defer teardown()
@@ -1775,6 +3283,92 @@ echo -n $list[$a[0]]`)
 	}
 }
 
+func TestExecuteVariableSlicing(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "list ranges",
+			code: `var l = (a b c d e)
+        echo $l[1:3]
+        echo $l[2:]
+        echo $l[:2]
+        echo $l[:]`,
+			expectedStdout: "b c\nc d e\na b\na b c d e\n",
+		},
+		{
+			desc: "string ranges",
+			code: `var s = "hello world"
+        echo $s[:5]
+        echo $s[6:]
+        echo $s[3:3]`,
+			expectedStdout: "hello\nworld\n\n",
+		},
+		{
+			desc: "negative indexes",
+			code: `var l = (a b c d e)
+        var s = "nash"
+        echo $l[-1] $s[-1]
+        echo $l[-3:-1] $s[:-2]
+        l[-1] = "z"
+        echo $l`,
+			expectedStdout: "e h\nc d na\na b c d z\n",
+		},
+		{
+			desc: "variable ranges",
+			code: `var l = (a b c d e)
+        var i, j = 1, "3"
+        var tail = $l[$i:]
+        echo $tail $l[$i:$j]`,
+			expectedStdout: "b c d e b c\n",
+		},
+		{
+			desc: "head and tail of split",
+			code: `var parts <= split("a:b:c", ":")
+        var head, tail = $parts[0], $parts[1:]
+        echo $head
+        echo $tail`,
+			expectedStdout: "a\nb c\n",
+		},
+		{
+			desc: "list end out of range",
+			code: `var l = (a b)
+        echo $l[1:3]`,
+			expectedErr: "<interactive>:2:13: IndexError: Index out of bounds, index[3] but list size[2]",
+		},
+		{
+			desc: "list negative index out of range",
+			code: `var l = (a b)
+        echo $l[-3]`,
+			expectedErr: "<interactive>:2:13: IndexError: Index out of bounds, index[-3] but list size[2]",
+		},
+		{
+			desc: "string start out of range",
+			code: `var s = "ab"
+        echo $s[3:]`,
+			expectedErr: "<interactive>:2:13: IndexError: Index[3] out of range, string size[2]",
+		},
+		{
+			desc: "inverted range",
+			code: `var l = (a b c)
+        echo $l[2:1]`,
+			expectedErr: "<interactive>:2:13: IndexError: invalid slice 2:1",
+		},
+		{
+			desc: "inverted range from the end",
+			code: `var l = (a b c)
+        echo $l[3:1]`,
+			expectedErr: "<interactive>:2:13: IndexError: invalid slice 3:1",
+		},
+		{
+			desc: "inverted string range",
+			code: `var s = "nash"
+        echo $s[-1:-2]`,
+			expectedErr: "<interactive>:2:13: IndexError: invalid slice -1:-2",
+		},
+	} {
+		testExec(t, test)
+	}
+}
+
 func TestExecuteSubShellDoesNotOverwriteparentEnv(t *testing.T) {
 	f, teardown := setup(t)
 	defer teardown()
@@ -2124,13 +3718,240 @@ a("test")`,
     print($b, $c...)
 }
 a()`,
-			expectedErr: "<interactive>:4:0: Wrong number of arguments for function a. Expected at least 1 arguments but found 0",
+			expectedErr: "<interactive>:4:0: Wrong number of arguments for function a(b, c...). Expected at least 1 arguments but found 0",
 		},
 	} {
 		testExec(t, test)
 	}
 }
 
+func TestExecuteFnDefaultArgs(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "default value",
+			code: `fn deploy(env, region = "us-east-1") {
+	echo $env $region
+}
+deploy("prod")
+deploy("prod", "eu-west-1")`,
+			expectedStdout: "prod us-east-1\nprod eu-west-1\n",
+		},
+		{
+			desc: "default refers to previous args",
+			code: `fn backup(dir, dest = $dir+".bkp", opts = ("-r" $dest)) {
+	echo $dest $opts
+}
+backup("/etc")`,
+			expectedStdout: "/etc.bkp -r /etc.bkp\n",
+		},
+		{
+			desc: "default function call",
+			code: `fn next() {
+	return "x"
+}
+fn tag(t = next()) {
+	echo $t
+}
+tag()
+tag("y")`,
+			expectedStdout: "x\ny\n",
+		},
+		{
+			desc: "default with variadic",
+			code: `fn run(cmd, sudo = "no", args...) {
+	echo $cmd $sudo $args
+}
+run("ls")
+run("ls", "yes", "-l", "-a")`,
+			expectedStdout: "ls no\nls yes -l -a\n",
+		},
+		{
+			desc: "required after optional",
+			code: `fn deploy(region = "us-east-1", env) {
+}`,
+			expectedErr: "<interactive>:1:32: Argument 'env' without default value follows optional argument 'region'",
+		},
+		{
+			desc: "too many arguments",
+			code: `fn deploy(env, region = "us-east-1") {
+}
+deploy("a", "b", "c")`,
+			expectedErr: `<interactive>:3:0: Wrong number of arguments for function deploy(env, region = "us-east-1"). Expected 1 to 2 arguments but found 3`,
+		},
+		{
+			desc: "too few arguments",
+			code: `fn deploy(env, region = "us-east-1") {
+}
+deploy()`,
+			expectedErr: `<interactive>:3:0: Wrong number of arguments for function deploy(env, region = "us-east-1"). Expected 1 to 2 arguments but found 0`,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
+func TestExecuteFnNamedArgs(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "named args",
+			code: `fn deploy(env, region = "us-east-1", replicas = "1") {
+	echo $env $region $replicas
+}
+deploy("prod", replicas = "3")
+deploy(region = "eu-west-1", env = "staging")
+deploy("dev", region = "sa-east-1", replicas = "2")`,
+			expectedStdout: "prod us-east-1 3\nstaging eu-west-1 1\ndev sa-east-1 2\n",
+		},
+		{
+			desc: "named args of any type",
+			code: `fn show(l, m) {
+	echo $l $m["k"]
+}
+show(m = {"k": "v"}, l = ("a" "b"))`,
+			expectedStdout: "a b v\n",
+		},
+		{
+			desc: "named args with return values",
+			code: `fn join(a, b, sep = " ") {
+	return $a+$sep+$b
+}
+var v <= join("a", sep = "-", b = "b")
+echo $v`,
+			expectedStdout: "a-b\n",
+		},
+		{
+			desc: "missing argument",
+			code: `fn deploy(env, region = "us-east-1") {
+}
+deploy(region = "eu-west-1")`,
+			expectedErr: `<interactive>:3:0: Missing argument 'env' for function deploy(env, region = "us-east-1")`,
+		},
+		{
+			desc: "unknown argument",
+			code: `fn deploy(env) {
+}
+deploy("prod", zone = "a")`,
+			expectedErr: "<interactive>:3:0: Unknown argument 'zone' for function deploy(env)",
+		},
+		{
+			desc: "argument given twice",
+			code: `fn deploy(env) {
+}
+deploy("prod", env = "dev")`,
+			expectedErr: "<interactive>:3:0: Argument 'env' of function deploy(env) given twice",
+		},
+		{
+			desc: "variadic argument by name",
+			code: `fn deploy(env, tags...) {
+}
+deploy("prod", tags = ("a"))`,
+			expectedErr: "<interactive>:3:0: Variadic argument 'tags' of function deploy(env, tags...) can't be passed by name",
+		},
+		{
+			desc:        "builtin function",
+			code:        `print(fmt = "a")`,
+			expectedErr: "<interactive>:1:0: Function print doesn't accept arguments by name",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
+func TestExecuteFnTypes(t *testing.T) {
+	for _, test := range []execTestCase{
+		{
+			desc: "typed args and returns",
+			code: `fn pair(a: str, b: int): list {
+	return ($a $b)
+}
+fn count(l: list, m: map, f: fn, rest...: str): int {
+	var n = 0
+	for v in $l {
+		n = $n + 1
+	}
+	return $n
+}
+var p <= pair("a", 1)
+var n <= count($p, {}, $pair, "x", "y")
+echo $p $n`,
+			expectedStdout: "a 1 2\n",
+		},
+		{
+			desc: "wrong argument type",
+			code: `fn get(m: map, key: str): str {
+	return $m[$key]
+}
+get(("a" "b"), "a")`,
+			expectedErr: "<interactive>:4:0: Wrong type for argument 'm' of function get(m: map, key: str): str. Expected map but found list",
+		},
+		{
+			desc: "wrong variadic argument type",
+			code: `fn join(sep: str, parts...: str) {
+}
+join("-", "a", 1)`,
+			expectedErr: "<interactive>:3:0: Wrong type for argument 'parts' of function join(sep: str, parts...: str). Expected str but found int",
+		},
+		{
+			desc: "wrong default value type",
+			code: `fn retry(times: int = "3") {
+}
+retry()`,
+			expectedErr: `<interactive>:3:0: Wrong type for argument 'times' of function retry(times: int = "3"). Expected int but found str`,
+		},
+		{
+			desc: "wrong named argument type",
+			code: `fn retry(cmd: str, times: int = 3) {
+}
+retry("ls", times = "5")`,
+			expectedErr: "<interactive>:3:0: Wrong type for argument 'times' of function retry(cmd: str, times: int = 3). Expected int but found str",
+		},
+		{
+			desc: "wrong return type",
+			code: `fn get(m: map, key: str): str {
+	return 1
+}
+var v <= get({}, "a")`,
+			expectedErr: "<interactive>:2:1: Wrong type for return value 1 of function get(m: map, key: str): str. Expected str but found int",
+		},
+		{
+			desc: "wrong number of return values",
+			code: `fn split(s: str): str, str {
+	return $s
+}
+var a, b <= split("a")`,
+			expectedErr: "<interactive>:2:1: Wrong number of return values for function split(s: str): str, str. Expected 2 but found 1",
+		},
+		{
+			desc: "wrong return type of nested call",
+			code: `fn get(): str {
+	return 1
+}
+fn wrapper() {
+	var v <= get()
+}
+wrapper()`,
+			expectedErr: "<interactive>:2:1: Wrong type for return value 1 of function get(): str. Expected str but found int",
+		},
+		{
+			desc: "missing return",
+			code: `fn f(): str {
+	echo hi
+}
+f()`,
+			expectedStdout: "hi\n",
+			expectedErr:    "<interactive>:4:0: Wrong number of return values for function f(): str. Expected 1 but found 0",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			testExec(t, test)
+		})
+	}
+}
+
 func setup(t *testing.T) (testFixture, func()) {
 	dirs := fixture.SetupNashDirs(t)
 	shell, err := sh.NewAbortShell(dirs.Path, dirs.Root)
@@ -2172,6 +3993,145 @@ func testExecuteFile(t *testing.T, path, expected string, before string) {
 	}
 }
 
+func TestExecuteDump(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "nash-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.shell.SetInteractive(true)
+	f.shell.Newvar("dumpfile", shtypes.NewStrObj(filepath.Join(tmpdir, "session.sh")))
+
+	testShellExec(t, f.shell, execTestCase{
+		desc: "dump session",
+		code: `
+			var msg = "hello \"world\""
+			var count = 3
+			var list = ("a" "b c")
+			var dict = {"k": ("1" "2"), "n": {"x": "y"}}
+			setenv DUMPENV = "exported"
+			var ARGS = ("script.sh" "arg")
+			PWD = "/dumped"
+
+			fn greet(name, rest...) {
+				echo $msg $name $rest
+			}
+
+			bindfn greet hi
+			dump $dumpfile
+		`,
+	})
+
+	testExec(t, execTestCase{
+		desc: "restore session",
+		code: `
+			import ` + filepath.Join(tmpdir, "session.sh") + `
+			greet "nash" "rocks"
+			var n = $dict["n"]
+			echo $count $list $dict["k"] $n["x"]
+			echo $DUMPENV
+			env | grep DUMPENV
+			echo $PWD
+		`,
+		expectedStdout: "hello \"world\" nash rocks\n" +
+			"3 a b c 1 2 y\n" +
+			"exported\n" +
+			"DUMPENV=exported\n" +
+			cwd + "\n",
+	})
+
+	content, err := ioutil.ReadFile(filepath.Join(tmpdir, "session.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unexpected := range []string{
+		"var _ ",
+		"var PATH ",
+		"var HOME ",
+		"bindfn ",
+	} {
+		if strings.Contains(string(content), unexpected) {
+			t.Errorf("dump file %q contains %q", string(content), unexpected)
+		}
+	}
+
+	var out bytes.Buffer
+
+	f.shell.SetStdout(&out)
+
+	err = f.shell.Exec("dump stdout", `dump`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"var DUMPENV = \"exported\"\nsetenv DUMPENV\n",
+		"var count = 3\n",
+		"fn greet(name, rest...) {\n",
+		"bindfn greet hi\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("dump output %q doesn't contain %q", out.String(), expected)
+		}
+	}
+
+	for _, name := range []string{"PID", "PWD", "SHELL", "argv", "ARGS"} {
+		if strings.Contains(out.String(), "var "+name+" ") {
+			t.Errorf("dump output %q contains the variable %s", out.String(), name)
+		}
+	}
+
+	testExec(t, execTestCase{
+		desc:        "dump invalid file",
+		code:        `dump ("a" "b")`,
+		expectedErr: "dump invalid file:1:5: Unexpected token (. Expected IDENT, STRING, VARIABLE or ARG",
+	})
+
+	testExec(t, execTestCase{
+		desc: "dump list file",
+		code: `var files = ("a" "b")
+			dump $files`,
+		expectedErr: "<interactive>:2:3: dump expects a file name, but a ListType was provided",
+	})
+}
+
+func TestExecuteShowEnv(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	var out bytes.Buffer
+
+	f.shell.SetStdout(&out)
+
+	err := f.shell.Exec("showenv", `
+		setenv SHOWENV = "exported"
+		var notexported = "local"
+		showenv
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "\nSHOWENV=exported\n") {
+		t.Errorf("showenv output %q doesn't contain SHOWENV", out.String())
+	}
+
+	if strings.Contains(out.String(), "notexported") {
+		t.Errorf("showenv output %q contains local variable", out.String())
+	}
+}
+
 func testShellExec(t *testing.T, shell *sh.Shell, testcase execTestCase) {
 	t.Helper()
 
@@ -0,0 +1,273 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/internal/sh/builtin"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// trapTable stores the functions trapping signals. The signals
+	// are received by a buffered channel and the functions run by
+	// the interpreter between statements, never concurrently with
+	// the script. The table is shared by the shell and its
+	// subshells.
+	trapTable struct {
+		sync.Mutex
+
+		handlers map[string]sh.FnDef
+		sigs     chan os.Signal
+		running  bool // a handler is running
+	}
+
+	// trapFn is the trap builtin function. It sets or removes the
+	// function trapping a signal.
+	trapFn struct {
+		traps *trapTable
+		name  string
+		fnDef sh.FnDef // nil removes the trap
+	}
+
+	// exitFn is the exit builtin function. It runs the function
+	// trapping EXIT before exiting.
+	exitFn struct {
+		builtin.Fn
+
+		traps *trapTable
+	}
+)
+
+// trapExit is the pseudo signal trapped when the shell exits.
+const trapExit = "EXIT"
+
+func newTrapTable() *trapTable {
+	return &trapTable{
+		handlers: make(map[string]sh.FnDef),
+		sigs:     make(chan os.Signal, 16),
+	}
+}
+
+// signalName returns the name of the signal as used by trap. The
+// "SIG" prefix is optional.
+func signalName(name string) (string, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+
+	if _, ok := trapSignals[name]; ok || name == trapExit {
+		return name, nil
+	}
+
+	names := []string{trapExit}
+
+	for name := range trapSignals {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return "", errors.NewError("trap: invalid signal %q. Expected one of: %s",
+		name, strings.Join(names, ", "))
+}
+
+// set sets the function trapping the signal name. If fnDef is nil
+// the trap is removed and the signal has its default behaviour
+// again.
+func (t *trapTable) set(name string, fnDef sh.FnDef) {
+	t.Lock()
+	defer t.Unlock()
+
+	if fnDef == nil {
+		delete(t.handlers, name)
+	} else {
+		t.handlers[name] = fnDef
+	}
+
+	signal.Stop(t.sigs)
+
+	var sigs []os.Signal
+
+	for name := range t.handlers {
+		if sig, ok := trapSignals[name]; ok {
+			sigs = append(sigs, sig)
+		}
+	}
+
+	if len(sigs) > 0 {
+		signal.Notify(t.sigs, sigs...)
+	}
+}
+
+// trapped returns true if the signal name is trapped.
+func (t *trapTable) trapped(name string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	_, ok := t.handlers[name]
+	return ok
+}
+
+// handler returns the function trapping the signal name, if any.
+// The function is removed from the table if remove is true.
+func (t *trapTable) handler(name string, remove bool) (sh.FnDef, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	fnDef, ok := t.handlers[name]
+	if ok && remove {
+		delete(t.handlers, name)
+	}
+
+	return fnDef, ok
+}
+
+// run runs the functions trapping the signals received since last
+// call. Signals received while a function runs are handled after it
+// finishes.
+func (t *trapTable) run(stderr io.Writer) {
+	t.Lock()
+
+	if t.running {
+		t.Unlock()
+		return
+	}
+
+	t.running = true
+	t.Unlock()
+
+	defer func() {
+		t.Lock()
+		t.running = false
+		t.Unlock()
+	}()
+
+	for {
+		select {
+		case sig := <-t.sigs:
+			for name, trapSig := range trapSignals {
+				if trapSig != sig {
+					continue
+				}
+
+				if fnDef, ok := t.handler(name, false); ok {
+					runTrap(name, fnDef, stderr)
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+// runExit runs the function trapping EXIT. It runs only once.
+func (t *trapTable) runExit(stderr io.Writer) {
+	if fnDef, ok := t.handler(trapExit, true); ok {
+		runTrap(trapExit, fnDef, stderr)
+	}
+}
+
+func runTrap(name string, fnDef sh.FnDef, stderr io.Writer) {
+	fn := fnDef.Build()
+
+	err := fn.SetArgs(nil)
+	if err == nil {
+		err = fn.Start()
+	}
+
+	if err == nil {
+		err = fn.Wait()
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "trap %s: %s\n", name, err)
+	}
+}
+
+// runTraps runs the functions trapping the signals received. It's
+// called by the interpreter between statements.
+func (shell *Shell) runTraps() {
+	if shell.traps != nil {
+		shell.traps.run(shell.stderr)
+	}
+}
+
+// RunExitTrap runs the function trapping EXIT, if any, after the
+// functions trapping signals still not handled. It must be called
+// when the script finishes.
+func (shell *Shell) RunExitTrap() {
+	if shell.traps != nil {
+		shell.traps.run(shell.stderr)
+		shell.traps.runExit(shell.stderr)
+	}
+}
+
+func newTrapConstructor(traps *trapTable) builtin.Constructor {
+	return func() builtin.Fn {
+		return &trapFn{traps: traps}
+	}
+}
+
+func (t *trapFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("signal", false),
+		sh.NewFnArg("fn", true),
+	}
+}
+
+func (t *trapFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	t.traps.set(t.name, t.fnDef)
+	return nil, nil
+}
+
+func (t *trapFn) SetArgs(args []sh.Obj) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.NewError("trap expects a signal and a function, but received %d arguments",
+			len(args))
+	}
+
+	if args[0].Type() != sh.StringType {
+		return errors.NewError("trap expects a signal, but a %s was provided",
+			args[0].Type())
+	}
+
+	name, err := signalName(args[0].String())
+	if err != nil {
+		return err
+	}
+
+	t.name = name
+
+	if len(args) == 1 {
+		return nil
+	}
+
+	if args[1].Type() != sh.FnType {
+		return errors.NewError("trap expects a function, but a %s was provided",
+			args[1].Type())
+	}
+
+	t.fnDef = args[1].(*sh.FnObj).Fn()
+	return nil
+}
+
+func newExitConstructor(traps *trapTable) builtin.Constructor {
+	exit := builtin.Constructors()["exit"]
+
+	return func() builtin.Fn {
+		return &exitFn{
+			Fn:    exit(),
+			traps: traps,
+		}
+	}
+}
+
+func (e *exitFn) Run(in io.Reader, out io.Writer, ioerr io.Writer) ([]sh.Obj, error) {
+	e.traps.runExit(ioerr)
+	return e.Fn.Run(in, out, ioerr)
+}
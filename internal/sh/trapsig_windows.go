@@ -0,0 +1,13 @@
+package sh
+
+import (
+	"os"
+	"syscall"
+)
+
+// trapSignals are the signals that can be trapped by name.
+var trapSignals = map[string]os.Signal{
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+}
@@ -10,7 +10,25 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/madlambda/nash/ast"
 	"github.com/madlambda/nash/sh"
+	"github.com/madlambda/nash/token"
+)
+
+type (
+	// extraFiler is implemented by runners supporting file
+	// descriptors greater than 2.
+	extraFiler interface {
+		SetExtraFile(fd int, f *os.File)
+		ExtraFile(fd int) *os.File
+	}
+
+	// pipeCloser closes the write end of a pipe and waits until
+	// everything written was copied from the read end.
+	pipeCloser struct {
+		w    *os.File
+		done chan struct{}
+	}
 )
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -36,7 +54,8 @@ func buildenv(e Env) []string {
 		}
 
 		if v.Type() != sh.ListType &&
-			v.Type() != sh.StringType {
+			v.Type() != sh.StringType &&
+			v.Type() != sh.IntType {
 			continue
 		}
 
@@ -44,28 +63,155 @@ func buildenv(e Env) []string {
 			vlist := v.(*sh.ListObj)
 			env = append(env, k+"=("+vlist.String()+")")
 		} else {
-			vstr := v.(*sh.StrObj)
-			env = append(env, k+"="+vstr.String())
+			env = append(env, k+"="+v.String())
 		}
 	}
 
 	return env
 }
 
+// printVar prints the declaration of the variable name as nash
+// code. Values with functions can't be printed.
 func printVar(out io.Writer, name string, val sh.Obj) {
-	if val.Type() == sh.StringType {
-		valstr := val.(*sh.StrObj)
-		fmt.Fprintf(out, "%s = \"%s\"\n", name, valstr.Str())
-	} else if val.Type() == sh.ListType {
-		vallist := val.(*sh.ListObj)
-		fmt.Fprintf(out, "%s = (%s)\n", name, vallist.String())
+	expr, ok := objExpr(val)
+	if !ok {
+		return
 	}
+
+	fmt.Fprintf(out, "var %s = %s\n", name, expr)
+}
+
+// objExpr returns the expression evaluating to obj. It returns false
+// if obj is or has a function.
+func objExpr(obj sh.Obj) (ast.Expr, bool) {
+	var info token.FileInfo
+
+	switch o := obj.(type) {
+	case *sh.StrObj:
+		return ast.NewStringExpr(info, o.Str(), true), true
+	case *sh.IntObj:
+		return ast.NewIntExpr(info, o.Int()), true
+	case *sh.ListObj:
+		values := make([]ast.Expr, 0, o.Len())
+
+		for _, value := range o.List() {
+			expr, ok := objExpr(value)
+			if !ok {
+				return nil, false
+			}
+
+			values = append(values, expr)
+		}
+
+		return ast.NewListExpr(info, values), true
+	case *sh.MapObj:
+		var keys, values []ast.Expr
+
+		for _, key := range o.Keys() {
+			expr, ok := objExpr(o.Map()[key])
+			if !ok {
+				return nil, false
+			}
+
+			keys = append(keys, ast.NewStringExpr(info, key, true))
+			values = append(values, expr)
+		}
+
+		return ast.NewMapExpr(info, keys, values), true
+	}
+
+	return nil, false
 }
 
 func printEnv(out io.Writer, name string) {
 	fmt.Fprintf(out, "setenv %s\n", name)
 }
 
+// writerFile returns a file that writes to out. If out is not a file
+// then a pipe is created and the returned closer must be called after
+// the process using the file finishes.
+func writerFile(out io.Writer) (*os.File, io.Closer, error) {
+	if out == nil {
+		file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		return file, file, err
+	}
+
+	if file, ok := out.(*os.File); ok {
+		return file, nil, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closer := &pipeCloser{
+		w:    w,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		io.Copy(out, r)
+		r.Close()
+		close(closer.done)
+	}()
+
+	return w, closer, nil
+}
+
+// sharedWriter returns a writer to out that can be shared by many
+// commands. If out is not a file, then a pipe is created as in
+// writerFile and the returned closers must be called after the
+// commands finish.
+func sharedWriter(out io.Writer) (io.Writer, []io.Closer, error) {
+	if _, ok := out.(*os.File); ok || out == nil {
+		return out, nil, nil
+	}
+
+	file, closer, err := writerFile(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, []io.Closer{closer}, nil
+}
+
+// sameWriter tells if a and b are the same writer. Writers of
+// uncomparable types are never the same.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+
+	return a == b
+}
+
+func (p *pipeCloser) Close() error {
+	err := p.w.Close()
+	<-p.done
+	return err
+}
+
+// locationFile returns the file of an opened redirection location.
+// Network connections are duplicated into a new file.
+func locationFile(location io.ReadWriteCloser) (*os.File, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	if file, ok := location.(*os.File); ok {
+		return file, nil
+	}
+
+	if conn, ok := location.(filer); ok {
+		return conn.File()
+	}
+
+	return nil, fmt.Errorf("Redirection location does not support file descriptors: %T", location)
+}
+
 func getErrStatus(err error, def string) string {
 	status := def
 
@@ -58,6 +58,24 @@ func (nash *Shell) SetInteractive(b bool) {
 	nash.interp.SetInteractive(b)
 }
 
+// SetJobControl enables the job control of interactive shells. The
+// stdin of the shell must be a terminal.
+func (nash *Shell) SetJobControl(b bool) error {
+	return nash.interp.SetJobControl(b)
+}
+
+// NotifyJobs reports the background jobs that finished since the
+// last call and removes them from the table of jobs.
+func (nash *Shell) NotifyJobs() {
+	nash.interp.NotifyJobs()
+}
+
+// RunExitTrap runs the function trapping EXIT, if any. It must be
+// called when the script finishes.
+func (nash *Shell) RunExitTrap() {
+	nash.interp.RunExitTrap()
+}
+
 func (nash *Shell) NashPath() string {
 	return nash.interp.NashPath()
 }
@@ -5,6 +5,8 @@ import (
 	"runtime"
 
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/madlambda/nash/ast"
 	"github.com/madlambda/nash/errors"
@@ -22,6 +24,8 @@ type (
 		openblocks int
 
 		insidePipe bool
+		insideCond bool
+		openloops  int
 
 		keywordParsers map[token.Token]parserFn
 	}
@@ -45,17 +49,24 @@ func NewParser(name, content string) *Parser {
 	}
 
 	p.keywordParsers = map[token.Token]parserFn{
-		token.For:     p.parseFor,
-		token.If:      p.parseIf,
-		token.Fn:      p.parseFnDecl,
-		token.Var:     p.parseVar,
-		token.Return:  p.parseReturn,
-		token.Import:  p.parseImport,
-		token.SetEnv:  p.parseSetenv,
-		token.Rfork:   p.parseRfork,
-		token.BindFn:  p.parseBindFn,
-		token.Comment: p.parseComment,
-		token.Illegal: p.parseError,
+		token.For:      p.parseFor,
+		token.If:       p.parseIf,
+		token.Switch:   p.parseSwitch,
+		token.Fn:       p.parseFnDecl,
+		token.Var:      p.parseVar,
+		token.Return:   p.parseReturn,
+		token.Break:    p.parseLoopCtl,
+		token.Continue: p.parseLoopCtl,
+		token.Import:   p.parseImport,
+		token.SetEnv:   p.parseSetenv,
+		token.Rfork:    p.parseRfork,
+		token.BindFn:   p.parseBindFn,
+		token.ShowEnv:  p.parseShowEnv,
+		token.Dump:     p.parseDump,
+		token.Spawn:    p.parseSpawn,
+		token.Defer:    p.parseDefer,
+		token.Comment:  p.parseComment,
+		token.Illegal:  p.parseError,
 	}
 
 	return p
@@ -212,44 +223,148 @@ func (p *Parser) parseStatement() (ast.Node, error) {
 	return nil, newParserError(it, p.name, "Unexpected token parsing statement '%+v'", it)
 }
 
+// parseIndexing parses the index part of an indexed variable. The
+// index can be a map key ("key"), a number (negative numbers index
+// from the end) or variable, or a slice range in the forms: i:j, i:,
+// :j and :.
 func (p *Parser) parseIndexing() (ast.Expr, error) {
-	it := p.next()
+	var (
+		parts []ast.Expr // nil entries are the ':' of slice ranges
+		first = p.peek()
+		it    scanner.Token
+	)
+
+	if first.Type() == token.String {
+		// map key
+		p.ignore()
+
+		it := p.next()
+		if it.Type() != token.RBrack {
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting ']'", it)
+		}
+
+		return ast.NewStringExpr(first.FileInfo, first.Value(), true), nil
+	}
+
+	for it = p.next(); it.Type() != token.RBrack; it = p.next() {
+		switch it.Type() {
+		case token.Number:
+			// only supports base10
+			intval, err := strconv.Atoi(it.Value())
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, ast.NewIntExpr(it.FileInfo, intval))
+		case token.Variable:
+			index, err := p.parseVariable(&it, false)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, index)
+		case token.Arg:
+			argParts, err := p.splitIndexArg(it)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, argParts...)
+		default:
+			if len(parts) == 0 {
+				return nil, newParserError(it, p.name,
+					"Expected number, string or variable in index. Found %v", it)
+			}
+
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting ']'", it)
+		}
+	}
 
-	if it.Type() != token.Number && it.Type() != token.Variable {
+	if len(parts) == 0 {
 		return nil, newParserError(it, p.name,
-			"Expected number or variable in index. Found %v", it)
+			"Expected number, string or variable in index. Found %v", it)
 	}
 
-	var (
-		index ast.Expr
-		err   error
-	)
+	colon := -1
 
-	if it.Type() == token.Number {
-		// only supports base10
-		intval, err := strconv.Atoi(it.Value())
+	for i, part := range parts {
+		if part != nil {
+			continue
+		}
 
-		if err != nil {
-			return nil, err
+		if colon >= 0 {
+			return nil, newParserError(first, p.name,
+				"Invalid slice range, only one ':' is allowed")
 		}
 
-		index = ast.NewIntExpr(it.FileInfo, intval)
-	} else {
-		index, err = p.parseVariable(&it, false)
+		colon = i
+	}
 
-		if err != nil {
-			return nil, err
+	if colon < 0 {
+		if len(parts) > 1 {
+			return nil, newParserError(first, p.name,
+				"Invalid index, expected ':' between %s and %s", parts[0], parts[1])
 		}
+
+		return parts[0], nil
 	}
 
-	it = p.next()
+	before, after := parts[:colon], parts[colon+1:]
+	if len(before) > 1 || len(after) > 1 {
+		return nil, newParserError(first, p.name,
+			"Invalid slice range, expected a single index at each side of ':'")
+	}
 
-	if it.Type() != token.RBrack {
-		return nil, newParserError(it, p.name,
-			"Unexpected token %v. Expecting ']'", it)
+	var start, end ast.Expr
+
+	if len(before) == 1 {
+		start = before[0]
+	}
+
+	if len(after) == 1 {
+		end = after[0]
+	}
+
+	return ast.NewSliceExpr(first.FileInfo, start, end), nil
+}
+
+// splitIndexArg splits an index argument token (eg.: "-1", "1:3", ":2")
+// into the integer and ':' parts of a slice range. Colons are
+// represented by nil entries.
+func (p *Parser) splitIndexArg(it scanner.Token) ([]ast.Expr, error) {
+	var (
+		parts []ast.Expr
+		val   = it.Value()
+	)
+
+	for i := 0; i < len(val); {
+		if val[i] == ':' {
+			parts = append(parts, nil)
+			i++
+			continue
+		}
+
+		j := strings.IndexByte(val[i:], ':')
+		if j < 0 {
+			j = len(val)
+		} else {
+			j += i
+		}
+
+		intval, err := strconv.Atoi(val[i:j])
+		if err != nil {
+			return nil, newParserError(it, p.name,
+				"Expected number, string or variable in index. Found %v", it)
+		}
+
+		info := token.NewFileInfo(it.Line(), it.Column()+i)
+		parts = append(parts, ast.NewIntExpr(info, intval))
+		i = j
 	}
 
-	return index, nil
+	return parts, nil
 }
 
 func (p *Parser) parseVariable(tok *scanner.Token, allowVararg bool) (ast.Expr, error) {
@@ -309,6 +424,11 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 	n := ast.NewPipeNode(it.FileInfo, first.IsMulti())
 	first.SetMulti(false)
 
+	err := p.parsePipeMap(first)
+	if err != nil {
+		return nil, err
+	}
+
 	n.AddCmd(first)
 
 	for it = p.peek(); it.Type() == token.Ident || it.Type() == token.Arg; it = p.peek() {
@@ -319,6 +439,13 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 			return nil, err
 		}
 
+		for _, redir := range cmd.(*ast.CommandNode).Redirects() {
+			if redir.IsInput() {
+				return nil, newParserError(it, p.name,
+					"Input redirection only allowed in the first command of a pipe")
+			}
+		}
+
 		n.AddCmd(cmd.(*ast.CommandNode))
 
 		if !p.insidePipe {
@@ -341,7 +468,14 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 
 	it = p.peek()
 
-	if it.Type() == token.RBrace {
+	if it.Type() == token.Amp && !p.insideCond {
+		n.SetBackground(true)
+		p.ignore()
+
+		it = p.peek()
+	}
+
+	if it.Type() == token.RBrace || p.insideCond {
 		return n, nil
 	}
 
@@ -354,6 +488,57 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 	return n, nil
 }
 
+// parsePipeMap parses the optional list of file descriptors
+// following a pipe, eg.: |[2] or |[1,2]
+func (p *Parser) parsePipeMap(cmd *ast.CommandNode) error {
+	var fds []int
+
+	if p.peek().Type() != token.LBrack {
+		return nil
+	}
+
+	p.ignore()
+
+	for {
+		it := p.next()
+
+		if it.Type() != token.Number {
+			return newParserError(it, p.name,
+				"Expected file descriptor in pipe map, but found '%s'",
+				it.Value())
+		}
+
+		fd, err := strconv.Atoi(it.Value())
+		if err != nil || (fd != 1 && fd != 2) {
+			return newParserError(it, p.name,
+				"Pipe map expects 1 or 2. Found: %s", it.Value())
+		}
+
+		for _, other := range fds {
+			if other == fd {
+				return newParserError(it, p.name,
+					"Duplicated file descriptor %d in pipe map", fd)
+			}
+		}
+
+		fds = append(fds, fd)
+
+		it = p.next()
+
+		if it.Type() == token.RBrack {
+			break
+		}
+
+		if it.Type() != token.Comma {
+			return newParserError(it, p.name,
+				"Unexpected token %v. Expecting ',' or ]", it)
+		}
+	}
+
+	cmd.SetPipeFds(fds)
+	return nil
+}
+
 func (p *Parser) parseCommand(it scanner.Token) (ast.Node, error) {
 	isMulti := false
 
@@ -405,7 +590,8 @@ cmdLoop:
 		case typ == token.Plus:
 			return nil, newParserError(it, p.name,
 				"Unexpected '+'")
-		case typ == token.Gt:
+		case typ == token.Gt || typ == token.Append ||
+			typ == token.Lt || typ == token.HereStr:
 			p.next()
 			redir, err := p.parseRedirection(it)
 
@@ -417,6 +603,12 @@ cmdLoop:
 		case typ == token.Pipe:
 			if p.insidePipe {
 				p.next()
+
+				err := p.parsePipeMap(n)
+				if err != nil {
+					return nil, err
+				}
+
 				// TODO(i4k): test against pipes and multiline cmds
 				return n, nil
 			}
@@ -453,6 +645,21 @@ cmdLoop:
 		return n, nil
 	}
 
+	if p.insideCond {
+		return n, nil
+	}
+
+	if it.Type() == token.Amp {
+		n.SetBackground(true)
+		p.ignore()
+
+		it = p.peek()
+
+		if it.Type() == token.RBrace && p.openblocks > 0 {
+			return n, nil
+		}
+	}
+
 	if it.Type() != token.Semicolon {
 		return nil, newParserError(it, p.name, "Unexpected symbol '%s'", it)
 	}
@@ -470,6 +677,16 @@ func (p *Parser) parseRedirection(it scanner.Token) (*ast.RedirectNode, error) {
 
 	redir := ast.NewRedirectNode(it.FileInfo)
 
+	switch it.Type() {
+	case token.Append:
+		redir.SetKind(ast.RedirAppend)
+	case token.Lt:
+		redir.SetKind(ast.RedirInput)
+	case token.HereStr:
+		redir.SetKind(ast.RedirHereStr)
+		return p.parseRedirLocation(redir)
+	}
+
 	it = p.peek()
 
 	if !isValidArgument(it) && it.Type() != token.LBrack {
@@ -503,6 +720,11 @@ func (p *Parser) parseRedirection(it scanner.Token) (*ast.RedirectNode, error) {
 
 		// [xxx=
 		if it.Type() == token.Assign {
+			if redir.IsInput() {
+				return nil, newParserError(it, p.name,
+					"Unexpected '=', input redirection does not support mapping")
+			}
+
 			p.next()
 			it = p.peek()
 
@@ -536,11 +758,19 @@ func (p *Parser) parseRedirection(it scanner.Token) (*ast.RedirectNode, error) {
 		it = p.peek()
 	}
 
-	if !isValidArgument(it) {
-		if rval != ast.RedirMapNoValue || lval != ast.RedirMapNoValue {
-			return redir, nil
-		}
+	// output redirections like >[2=1] do not need a location
+	if !isValidArgument(it) && !redir.IsInput() &&
+		(rval != ast.RedirMapNoValue || lval != ast.RedirMapNoValue) {
+		return redir, nil
+	}
 
+	return p.parseRedirLocation(redir)
+}
+
+func (p *Parser) parseRedirLocation(redir *ast.RedirectNode) (*ast.RedirectNode, error) {
+	it := p.peek()
+
+	if !isValidArgument(it) {
 		return nil, newParserError(it, p.name, "Unexpected token %v. Expecting STRING or ARG or VARIABLE", it)
 	}
 
@@ -576,11 +806,26 @@ func (p *Parser) parseImport(importToken scanner.Token) (ast.Node, error) {
 		return nil, newParserError(it, p.name, "Parser error: Invalid token '%v' for import path", it)
 	}
 
+	n := ast.NewImportNode(importToken.FileInfo, arg)
+
+	// import <path> as <name>
+	if it = p.peek(); it.Type() == token.Ident && it.Value() == "as" {
+		p.ignore()
+
+		it = p.next()
+		if it.Type() != token.Ident || strings.Contains(it.Value(), ".") {
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting module name", it)
+		}
+
+		n.Name = it.Value()
+	}
+
 	if p.peek().Type() == token.Semicolon {
 		p.ignore()
 	}
 
-	return ast.NewImportNode(importToken.FileInfo, arg), nil
+	return n, nil
 }
 
 func (p *Parser) parseSetenv(it scanner.Token) (ast.Node, error) {
@@ -718,6 +963,97 @@ hasConcat:
 	return ast.NewConcatExpr(token.NewFileInfo(firstArg.Line(), firstArg.Column()), parts), nil
 }
 
+// parseExpr parses an expression with integer arithmetic. The '+'
+// operator creates a concatenation (sum of integers or concatenation
+// of strings) and the operators '*', '/' and '%' have higher
+// precedence than '+' and '-'. The operators must be separated
+// by spaces.
+func (p *Parser) parseExpr(tok *scanner.Token, cfg exprConfig) (ast.Expr, error) {
+	lvalue, err := p.parseTerm(tok, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		it := p.peek()
+
+		if it.Type() == token.Plus {
+			p.ignore()
+
+			rvalue, err := p.parseTerm(nil, cfg)
+			if err != nil {
+				return nil, err
+			}
+
+			if concat, ok := lvalue.(*ast.ConcatExpr); ok {
+				concat.PushExpr(rvalue)
+			} else {
+				lvalue = ast.NewConcatExpr(exprInfo(lvalue),
+					[]ast.Expr{lvalue, rvalue})
+			}
+
+			continue
+		}
+
+		if isArithOp(it, "-") {
+			p.ignore()
+
+			rvalue, err := p.parseTerm(nil, cfg)
+			if err != nil {
+				return nil, err
+			}
+
+			lvalue = ast.NewBinaryExpr(exprInfo(lvalue), lvalue, "-", rvalue)
+			continue
+		}
+
+		return lvalue, nil
+	}
+}
+
+func (p *Parser) parseTerm(tok *scanner.Token, cfg exprConfig) (ast.Expr, error) {
+	lvalue, err := p.parseOperand(tok, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for it := p.peek(); isArithOp(it, "*", "/", "%"); it = p.peek() {
+		p.ignore()
+
+		rvalue, err := p.parseOperand(nil, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		lvalue = ast.NewBinaryExpr(exprInfo(lvalue), lvalue, it.Value(), rvalue)
+	}
+
+	return lvalue, nil
+}
+
+func (p *Parser) parseOperand(tok *scanner.Token, cfg exprConfig) (ast.Expr, error) {
+	var it scanner.Token
+
+	if tok != nil {
+		it = *tok
+	} else {
+		it = p.next()
+	}
+
+	if isIntLiteral(it) {
+		val, err := strconv.Atoi(it.Value())
+		if err != nil {
+			return nil, newParserError(it, p.name, "Invalid integer %s: %s",
+				it.Value(), err)
+		}
+
+		return ast.NewIntExpr(it.FileInfo, val), nil
+	}
+
+	cfg.allowConcat = false
+	return p.getArgument(&it, cfg)
+}
+
 func (p *Parser) parseAssignment(ident scanner.Token) (ast.Node, error) {
 	// we're here
 	// |
@@ -858,25 +1194,8 @@ func (p *Parser) parseAssignValues(names []*ast.NameNode) (ast.Node, error) {
 		return nil, newParserError(p.peek(), p.name, "parser error: expect names non nil")
 	}
 
-	for it := p.peek(); isExpr(it.Type()); it = p.peek() {
-		var (
-			value ast.Expr
-			err   error
-		)
-
-		if it.Type() == token.Variable || it.Type() == token.String {
-			value, err = p.getArgument(nil, exprConfig{
-				allowArg:      false,
-				allowFuncall:  true,
-				allowVariadic: false,
-				allowConcat:   true,
-			})
-		} else if it.Type() == token.LParen { // list
-			value, err = p.parseList(nil)
-		} else {
-			return nil, newParserError(it, p.name, "Unexpected token %v. Expecting VARIABLE or STRING or (", it)
-		}
-
+	for it := p.peek(); isExpr(it.Type()) || isIntLiteral(it); it = p.peek() {
+		value, err := p.parseValue()
 		if err != nil {
 			return nil, err
 		}
@@ -904,45 +1223,174 @@ func (p *Parser) parseAssignValues(names []*ast.NameNode) (ast.Node, error) {
 	return ast.NewAssignNode(names[0].FileInfo, names, values), nil
 }
 
-func (p *Parser) parseAssignCmdOut(identifiers []*ast.NameNode) (ast.Node, error) {
-	var (
-		exec ast.Node
-		err  error
-	)
-
-	it := p.next()
+// parseValue parses the values allowed in assignments and map literals:
+// strings, integers, variables (optionally in expressions), lists
+// and maps.
+func (p *Parser) parseValue() (ast.Expr, error) {
+	it := p.peek()
 
-	if it.Type() != token.Ident && it.Type() != token.Arg && it.Type() != token.Variable && it.Type() != token.LParen {
-		return nil, newParserError(it, p.name,
-			"Invalid token %v. Expected command or function invocation", it)
+	if isIntLiteral(it) {
+		return p.parseExpr(nil, exprConfig{
+			allowFuncall: true,
+		})
 	}
 
-	if it.Type() == token.LParen {
-		// command invocation
-		exec, err = p.parseCommand(it)
-	} else {
-		nextIt := p.peek()
-
-		if nextIt.Type() != token.LParen {
-			// it == (Ident || Arg)
-			exec, err = p.parseCommand(it)
-		} else {
-			// <ident>()
-			// <arg>()
-			// <var>()
-			exec, err = p.parseFnInv(it, true)
-		}
+	switch it.Type() {
+	case token.Variable, token.String:
+		return p.parseExpr(nil, exprConfig{
+			allowArg:      false,
+			allowFuncall:  true,
+			allowVariadic: false,
+			allowConcat:   true,
+		})
+	case token.LParen:
+		return p.parseList(nil)
+	case token.LBrace:
+		return p.parseMap(nil)
 	}
 
-	if err != nil {
-		return nil, err
-	}
+	return nil, newParserError(it, p.name, "Unexpected token %v. Expecting VARIABLE, STRING, NUMBER, ( or {", it)
+}
+
+func (p *Parser) parseMap(tok *scanner.Token) (ast.Node, error) {
+	var lit scanner.Token
+
+	if tok != nil {
+		lit = *tok
+	} else {
+		lit = p.next()
+	}
+
+	if lit.Type() != token.LBrace {
+		return nil, newParserError(lit, p.name, "Unexpected token %v. Expecting {", lit)
+	}
+
+	n := ast.NewMapExpr(lit.FileInfo, nil, nil)
+
+	for {
+		it := p.peek()
+
+		// map literals can span multiple lines
+		if it.Type() == token.Semicolon {
+			p.ignore()
+			continue
+		}
+
+		if it.Type() == token.RBrace {
+			p.ignore()
+			break
+		}
+
+		if it.Type() == token.EOF {
+			return nil, errors.NewUnfinishedMapError(p.name, it)
+		}
+
+		if it.Type() != token.String && it.Type() != token.Variable {
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting STRING or VARIABLE as map key", it)
+		}
+
+		key, err := p.getArgument(nil, exprConfig{
+			allowArg:      false,
+			allowFuncall:  true,
+			allowVariadic: false,
+			allowConcat:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		it = p.next()
+		if it.Type() != token.Arg || it.Value() != ":" {
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting ':' after map key", it)
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		n.PushEntry(key, value)
+
+		it = p.peek()
+		if it.Type() == token.Comma {
+			p.ignore()
+			continue
+		}
+
+		if it.Type() == token.Semicolon {
+			p.ignore()
+			it = p.peek()
+		}
+
+		if it.Type() != token.RBrace {
+			if it.Type() == token.EOF {
+				return nil, errors.NewUnfinishedMapError(p.name, it)
+			}
+
+			return nil, newParserError(it, p.name, "Expected ',' or '}' but found %v", it)
+		}
+	}
+
+	return n, nil
+}
+
+func (p *Parser) parseAssignCmdOut(identifiers []*ast.NameNode) (ast.Node, error) {
+	var (
+		exec ast.Node
+		err  error
+	)
+
+	it := p.next()
+
+	if it.Type() != token.Ident && it.Type() != token.Arg &&
+		it.Type() != token.Variable && it.Type() != token.LParen &&
+		it.Type() != token.Spawn {
+		return nil, newParserError(it, p.name,
+			"Invalid token %v. Expected command or function invocation", it)
+	}
+
+	if it.Type() == token.Spawn {
+		exec, err = p.parseSpawn(it)
+	} else if it.Type() == token.LParen {
+		// command invocation
+		exec, err = p.parseCommand(it)
+	} else {
+		nextIt := p.peek()
+
+		if nextIt.Type() != token.LParen {
+			// it == (Ident || Arg)
+			exec, err = p.parseCommand(it)
+		} else {
+			// <ident>()
+			// <arg>()
+			// <var>()
+			exec, err = p.parseFnInv(it, true)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
 	if len(identifiers) == 0 {
 		// should not happen... pray
 		panic("internal error parsing assignment")
 	}
 
+	if exec.Type() == ast.NodeSpawn && len(identifiers) != 1 {
+		return nil, newParserError(it, p.name,
+			"Spawn only returns the process id, but statement expects %d values",
+			len(identifiers))
+	}
+
+	if ast.IsBackground(exec) && len(identifiers) != 1 {
+		return nil, newParserError(it, p.name,
+			"Background commands only return the job, but statement expects %d values",
+			len(identifiers))
+	}
+
 	return ast.NewExecAssignNode(identifiers[0].FileInfo, identifiers, exec)
 }
 
@@ -985,14 +1433,21 @@ func (p *Parser) parseRfork(it scanner.Token) (ast.Node, error) {
 	return n, nil
 }
 
-func (p *Parser) parseIfExpr() (ast.Node, error) {
-	it := p.peek()
+func (p *Parser) parseIfExpr(tok *scanner.Token) (ast.Node, error) {
+	var it scanner.Token
+
+	if tok != nil {
+		it = *tok
+	} else {
+		it = p.peek()
+	}
+
 	if it.Type() != token.Ident && it.Type() != token.String &&
-		it.Type() != token.Variable {
-		return nil, newParserError(it, p.name, "if requires lhs/rhs of type string, variable or function invocation. Found %v", it)
+		it.Type() != token.Variable && !isIntLiteral(it) {
+		return nil, newParserError(it, p.name, "if requires lhs/rhs of type string, integer, variable or function invocation. Found %v", it)
 	}
 
-	return p.getArgument(nil, exprConfig{
+	return p.parseExpr(tok, exprConfig{
 		allowArg:      false,
 		allowVariadic: false,
 		allowFuncall:  true,
@@ -1000,36 +1455,170 @@ func (p *Parser) parseIfExpr() (ast.Node, error) {
 	})
 }
 
-func (p *Parser) parseIf(it scanner.Token) (ast.Node, error) {
-	n := ast.NewIfNode(it.FileInfo)
+// parseCondition parses boolean expressions used by if and for
+// statements. The operators from lower to higher precedence are: '||',
+// '&&', '!' and the comparisons ('==', '!=', '<', '>'). Parenthesis can
+// be used to group conditions. If tok is not nil it is the first token
+// of the condition, already consumed by the caller.
+func (p *Parser) parseCondition(tok *scanner.Token) (ast.Expr, error) {
+	lvalue, err := p.parseAndCondition(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().Type() == token.Or {
+		p.ignore()
+
+		rvalue, err := p.parseAndCondition(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		lvalue = ast.NewBinaryExpr(exprInfo(lvalue), lvalue, "||", rvalue)
+	}
 
-	lvalue, err := p.parseIfExpr()
+	return lvalue, nil
+}
+
+func (p *Parser) parseAndCondition(tok *scanner.Token) (ast.Expr, error) {
+	lvalue, err := p.parseUnaryCondition(tok)
 	if err != nil {
 		return nil, err
 	}
 
-	n.SetLvalue(lvalue)
+	for p.peek().Type() == token.And {
+		p.ignore()
 
-	it = p.next()
+		rvalue, err := p.parseUnaryCondition(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		lvalue = ast.NewBinaryExpr(exprInfo(lvalue), lvalue, "&&", rvalue)
+	}
+
+	return lvalue, nil
+}
+
+func (p *Parser) parseUnaryCondition(tok *scanner.Token) (ast.Expr, error) {
+	if tok != nil {
+		if tok.Type() == token.LParen {
+			return p.parseGroupCondition()
+		}
+
+		if tok.Type() == token.Ident || tok.Type() == token.Arg {
+			return p.parseIdentCondition(*tok)
+		}
+
+		return p.parseComparison(tok)
+	}
+
+	it := p.peek()
 
-	if it.Type() != token.Equal && it.Type() != token.NotEqual {
+	if it.Type() == token.Arg && it.Value() == "!" {
+		p.ignore()
+
+		value, err := p.parseUnaryCondition(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewUnaryExpr(it.FileInfo, "!", value), nil
+	}
+
+	if it.Type() == token.LParen {
+		p.ignore()
+		return p.parseGroupCondition()
+	}
+
+	if it.Type() == token.Ident || it.Type() == token.Arg {
+		p.ignore()
+		return p.parseIdentCondition(it)
+	}
+
+	return p.parseComparison(nil)
+}
+
+// parseGroupCondition parses a condition grouped by parenthesis. The
+// opening parenthesis was already consumed.
+func (p *Parser) parseGroupCondition() (ast.Expr, error) {
+	cond, err := p.parseCondition(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	it := p.next()
+	if it.Type() != token.RParen {
+		return nil, newParserError(it, p.name, "Expected ')' but found %v", it)
+	}
+
+	return cond, nil
+}
+
+// parseIdentCondition parses a condition starting with an identifier,
+// that could be a function invocation being compared or a command.
+func (p *Parser) parseIdentCondition(it scanner.Token) (ast.Expr, error) {
+	if p.peek().Type() == token.LParen {
+		return p.parseComparison(&it)
+	}
+
+	return p.parseCondCommand(it)
+}
+
+// parseCondCommand parses a command (or pipe) used as condition. The
+// condition is true if the command succeeds.
+func (p *Parser) parseCondCommand(it scanner.Token) (ast.Expr, error) {
+	p.insideCond = true
+	defer func() {
+		p.insideCond = false
+	}()
+
+	return p.parseCommand(it)
+}
+
+func (p *Parser) parseComparison(tok *scanner.Token) (ast.Expr, error) {
+	lvalue, err := p.parseIfExpr(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	it := p.next()
+
+	switch it.Type() {
+	case token.Equal, token.NotEqual, token.Gt, token.Lt:
+	default:
 		return nil, newParserError(it, p.name, "Expected comparison, but found %v", it)
 	}
 
-	if it.Value() != "==" && it.Value() != "!=" {
-		return nil, newParserError(it, p.name, "Invalid if operator '%s'. Valid comparison operators are '==' and '!='",
-			it.Value())
+	var rvalue ast.Expr
+
+	// in the rhs a parenthesis can only start a list
+	if p.peek().Type() == token.LParen {
+		rvalue, err = p.parseList(nil)
+	} else {
+		rvalue, err = p.parseIfExpr(nil)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	n.SetOp(it.Value())
+	return ast.NewBinaryExpr(exprInfo(lvalue), lvalue, it.Value(), rvalue), nil
+}
+
+func exprInfo(expr ast.Expr) token.FileInfo {
+	return token.NewFileInfo(expr.Line(), expr.Column())
+}
 
-	rvalue, err := p.parseIfExpr()
+func (p *Parser) parseIf(it scanner.Token) (ast.Node, error) {
+	n := ast.NewIfNode(it.FileInfo)
 
+	cond, err := p.parseCondition(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	n.SetRvalue(rvalue)
+	n.SetCond(cond)
 
 	it = p.next()
 
@@ -1063,11 +1652,241 @@ func (p *Parser) parseIf(it scanner.Token) (ast.Node, error) {
 		elseTree := ast.NewTree("else tree")
 		elseTree.Root = elseBlock
 
-		n.SetElseif(elseIf)
-		n.SetElseTree(elseTree)
+		n.SetElseif(elseIf)
+		n.SetElseTree(elseTree)
+	}
+
+	return n, nil
+}
+
+// parseForPairs parses the loop over a list of pairs:
+//
+//	for (key, value) in (("k1" "v1") ("k2" "v2")) { ... }
+//
+// The opening parenthesis and the key identifier were consumed by
+// the caller.
+func (p *Parser) parseForPairs(forStmt *ast.ForNode, key scanner.Token) error {
+	var inExpr ast.Expr
+
+	p.ignore() // comma
+
+	value := p.next()
+	if value.Type() != token.Ident {
+		return newParserError(value, p.name,
+			"Expected identifier but found %q", value)
+	}
+
+	it := p.next()
+	if it.Type() != token.RParen {
+		return newParserError(it, p.name, "Expected ')' but found %q", it)
+	}
+
+	it = p.next()
+	if it.Type() != token.Ident || it.Value() != "in" {
+		return newParserError(it, p.name,
+			"Expected 'in' but found %q", it)
+	}
+
+	forStmt.SetPairs(true)
+	forStmt.SetIdentifier(key.Value())
+	forStmt.SetValueIdentifier(value.Value())
+
+	it = p.next()
+	next := p.peek()
+
+	var err error
+
+	switch {
+	case (it.Type() == token.Ident || it.Type() == token.Variable) &&
+		next.Type() == token.LParen:
+		inExpr, err = p.parseFnInv(it, false)
+	case it.Type() == token.Variable:
+		inExpr, err = p.parseVariable(&it, false)
+	case it.Type() == token.LParen:
+		inExpr, err = p.parseList(&it)
+	default:
+		return newParserError(it, p.name,
+			"Expected (variable, list or fn invocation) but found %q", it)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	forStmt.SetInExpr(inExpr)
+	return nil
+}
+
+// parseForCmd parses the command whose output lines are iterated by
+// the for statement.
+func (p *Parser) parseForCmd(forStmt *ast.ForNode) error {
+	it := p.next()
+
+	if it.Type() != token.Ident && it.Type() != token.Arg &&
+		it.Type() != token.LParen {
+		return newParserError(it, p.name,
+			"Unexpected token %v. Expected command", it)
+	}
+
+	cmd, err := p.parseCondCommand(it)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Type() != ast.NodeCommand {
+		return newParserError(it, p.name,
+			"Unexpected %s. Only the output of a single command can be iterated", cmd)
+	}
+
+	if cmd.(*ast.CommandNode).IsBackground() {
+		return newParserError(it, p.name,
+			"Unexpected '&'. Commands iterated by for can't run in background")
+	}
+
+	forStmt.SetInCmd(cmd)
+	return nil
+}
+
+// parseSwitch parses the switch statement:
+//
+//	switch <value> {
+//		case <pattern>, <pattern> { ... }
+//		case glob <pattern> { ... }
+//		default { ... }
+//	}
+//
+// The words case, default and glob are keywords only inside the
+// switch block.
+func (p *Parser) parseSwitch(it scanner.Token) (ast.Node, error) {
+	value, err := p.parseSwitchExpr("switch")
+	if err != nil {
+		return nil, err
+	}
+
+	n := ast.NewSwitchNode(it.FileInfo, value)
+
+	it = p.next()
+	if it.Type() != token.LBrace {
+		return nil, newParserError(it, p.name, "Expected '{' but found %v", it)
+	}
+
+	for {
+		it = p.next()
+
+		switch {
+		case it.Type() == token.Semicolon:
+			continue
+		case it.Type() == token.RBrace:
+			return n, nil
+		case it.Type() == token.EOF:
+			return nil, errors.NewUnfinishedBlockError(p.name, it)
+		case it.Type() == token.Ident && it.Value() == "case":
+			c, err := p.parseCase(it)
+			if err != nil {
+				return nil, err
+			}
+
+			n.AddCase(c)
+		case it.Type() == token.Ident && it.Value() == "default":
+			if n.DefaultTree() != nil {
+				return nil, newParserError(it, p.name,
+					"Multiple defaults in switch")
+			}
+
+			tree, err := p.parseCaseBlock("default block")
+			if err != nil {
+				return nil, err
+			}
+
+			n.SetDefaultTree(tree)
+		default:
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Expecting case, default or '}'", it)
+		}
+	}
+}
+
+func (p *Parser) parseCase(caseIt scanner.Token) (*ast.CaseNode, error) {
+	glob := false
+
+	if it := p.peek(); it.Type() == token.Ident && it.Value() == "glob" {
+		p.ignore()
+		glob = true
+	}
+
+	c := ast.NewCaseNode(caseIt.FileInfo, glob)
+
+	for {
+		var (
+			pattern ast.Node
+			err     error
+		)
+
+		// list membership
+		if p.peek().Type() == token.LParen {
+			pattern, err = p.parseList(nil)
+		} else {
+			pattern, err = p.parseSwitchExpr("case")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.AddPattern(pattern)
+
+		if p.peek().Type() != token.Comma {
+			break
+		}
+
+		p.ignore()
+	}
+
+	tree, err := p.parseCaseBlock("case block")
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetTree(tree)
+	return c, nil
+}
+
+func (p *Parser) parseCaseBlock(name string) (*ast.Tree, error) {
+	it := p.next()
+	if it.Type() != token.LBrace {
+		return nil, newParserError(it, p.name, "Expected '{' but found %v", it)
+	}
+
+	p.openblocks++
+
+	r, err := p.parseBlock(it.Line(), it.Column())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := ast.NewTree(name)
+	tree.Root = r
+	return tree, nil
+}
+
+// parseSwitchExpr parses the value of the switch or a pattern of a
+// case.
+func (p *Parser) parseSwitchExpr(stmt string) (ast.Node, error) {
+	it := p.peek()
+
+	if it.Type() != token.Ident && it.Type() != token.String &&
+		it.Type() != token.Variable && !isIntLiteral(it) {
+		return nil, newParserError(it, p.name,
+			"%s requires a string, integer, variable or function invocation. Found %v",
+			stmt, it)
 	}
 
-	return n, nil
+	return p.parseExpr(nil, exprConfig{
+		allowArg:      false,
+		allowVariadic: false,
+		allowFuncall:  true,
+		allowConcat:   true,
+	})
 }
 
 func (p *Parser) parseFnArgs() ([]*ast.FnArgNode, error) {
@@ -1081,15 +1900,43 @@ func (p *Parser) parseFnArgs() ([]*ast.FnArgNode, error) {
 
 	for {
 		it := p.next()
-		if it.Type() == token.Ident {
+		if it.Type() == token.Ident || isTypedArg(it) {
 			argName := it.Value()
 			isVariadic := false
-			if p.peek().Type() == token.Dotdotdot {
+
+			if it.Type() == token.Arg {
+				// the lexer reads "name:type" as a single token
+				argName = argName[:strings.IndexByte(argName, ':')]
+			} else if p.peek().Type() == token.Dotdotdot {
 				isVariadic = true
 				p.ignore()
 			}
-			args = append(args, ast.NewFnArgNode(it.FileInfo,
-				argName, isVariadic))
+
+			arg := ast.NewFnArgNode(it.FileInfo, argName, isVariadic)
+
+			if it.Type() == token.Arg || isTypeColon(p.peek()) {
+				// type annotation: fn get(m: map, key: str)
+				argType, err := p.parseTypeAnnotation(it)
+				if err != nil {
+					return nil, err
+				}
+
+				arg.ArgType = argType
+			}
+
+			if !isVariadic && p.peek().Type() == token.Assign {
+				// optional argument: fn deploy(env, region = "us-east-1")
+				p.ignore()
+
+				value, err := p.parseFnArgValue(p.next())
+				if err != nil {
+					return nil, err
+				}
+
+				arg.Default = value
+			}
+
+			args = append(args, arg)
 		} else {
 			return nil, newParserError(it, p.name, "Unexpected token %v. Expected identifier or ')'", it)
 		}
@@ -1186,6 +2033,18 @@ func (p *Parser) parseFnDecl(it scanner.Token) (ast.Node, error) {
 	}
 
 	it = p.next()
+
+	if isTypeColon(it) {
+		// return types: fn get(m: map, key: str): str
+		returnTypes, err := p.parseReturnTypes(it)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetReturnTypes(returnTypes)
+		it = p.next()
+	}
+
 	if it.Type() != token.LBrace {
 		return nil, newParserError(it, p.name,
 			"Unexpected token %v. Expected '{'", it)
@@ -1193,6 +2052,10 @@ func (p *Parser) parseFnDecl(it scanner.Token) (ast.Node, error) {
 
 	p.openblocks++
 
+	// break/continue cannot cross function boundaries
+	openloops := p.openloops
+	p.openloops = 0
+
 	tree := ast.NewTree(fmt.Sprintf("fn %s body", n.Name()))
 	r, err := p.parseBlock(it.Line(), it.Column())
 
@@ -1200,6 +2063,8 @@ func (p *Parser) parseFnDecl(it scanner.Token) (ast.Node, error) {
 		return nil, err
 	}
 
+	p.openloops = openloops
+
 	tree.Root = r
 	n.SetTree(tree)
 	return n, nil
@@ -1216,9 +2081,24 @@ func (p *Parser) parseFnInv(ident scanner.Token, allowSemicolon bool) (ast.Node,
 	for {
 		it = p.next()
 		next := p.peek()
-		if isFuncall(it.Type(), next.Type()) ||
+
+		if it.Type() == token.Ident && next.Type() == token.Assign {
+			// argument passed by name: deploy("prod", region = "eu")
+			p.ignore()
+
+			value, err := p.parseFnArgValue(p.next())
+			if err != nil {
+				return nil, err
+			}
+
+			n.AddNamedArg(ast.NewFnNamedArg(it.FileInfo, it.Value(), value))
+		} else if len(n.NamedArgs()) > 0 && it.Type() != token.RParen &&
+			it.Type() != token.EOF {
+			return nil, newParserError(it, p.name,
+				"Unexpected token %v. Positional arguments must come before named arguments", it)
+		} else if isFuncall(it.Type(), next.Type()) ||
 			isValidArgument(it) {
-			arg, err := p.getArgument(&it, exprConfig{
+			arg, err := p.parseExpr(&it, exprConfig{
 				allowArg:      false,
 				allowFuncall:  true,
 				allowConcat:   true,
@@ -1235,6 +2115,12 @@ func (p *Parser) parseFnInv(ident scanner.Token, allowSemicolon bool) (ast.Node,
 				return nil, err
 			}
 			n.AddArg(listArg)
+		} else if it.Type() == token.LBrace {
+			mapArg, err := p.parseMap(&it)
+			if err != nil {
+				return nil, err
+			}
+			n.AddArg(mapArg)
 		} else if it.Type() == token.RParen {
 			//			p.next()
 			break
@@ -1269,6 +2155,138 @@ parseError:
 		"Unexpected token %v. Expecting STRING, VARIABLE or )", it)
 }
 
+// parseTypeAnnotation parses the type of a function argument or
+// return value. The token it is the argument name or the colon, as the
+// lexer reads "name:type", "name:", ":type" and ":" as single tokens.
+func (p *Parser) parseTypeAnnotation(it scanner.Token) (string, error) {
+	if it.Type() != token.Arg {
+		it = p.next()
+	}
+
+	val := it.Value()
+	typeName := val[strings.IndexByte(val, ':')+1:]
+
+	if typeName == "" {
+		return p.parseTypeName()
+	}
+
+	if !isTypeName(typeName) {
+		return "", p.unknownTypeError(it, typeName)
+	}
+
+	return typeName, nil
+}
+
+// parseTypeName parses the type name of an annotation.
+func (p *Parser) parseTypeName() (string, error) {
+	it := p.next()
+
+	if it.Type() != token.Ident && it.Type() != token.Fn {
+		return "", newParserError(it, p.name,
+			"Unexpected token %v. Expected type name", it)
+	}
+
+	if !isTypeName(it.Value()) {
+		return "", p.unknownTypeError(it, it.Value())
+	}
+
+	return it.Value(), nil
+}
+
+func (p *Parser) unknownTypeError(it scanner.Token, typeName string) error {
+	return newParserError(it, p.name,
+		"Unknown type '%s'. Expected one of: %s", typeName,
+		strings.Join(typeNames, ", "))
+}
+
+// parseReturnTypes parses the comma separated list of return types
+// of a function declaration.
+func (p *Parser) parseReturnTypes(colon scanner.Token) ([]string, error) {
+	retType, err := p.parseTypeAnnotation(colon)
+	if err != nil {
+		return nil, err
+	}
+
+	returnTypes := []string{retType}
+
+	for p.peek().Type() == token.Comma {
+		p.ignore()
+
+		retType, err = p.parseTypeName()
+		if err != nil {
+			return nil, err
+		}
+
+		returnTypes = append(returnTypes, retType)
+	}
+
+	return returnTypes, nil
+}
+
+// parseFnArgValue parses the value of an argument passed by name or
+// the default value of an optional argument.
+func (p *Parser) parseFnArgValue(it scanner.Token) (ast.Expr, error) {
+	next := p.peek()
+
+	switch {
+	case it.Type() == token.LParen:
+		return p.parseList(&it)
+	case it.Type() == token.LBrace:
+		return p.parseMap(&it)
+	case it.Type() != token.Dotdotdot &&
+		(isFuncall(it.Type(), next.Type()) || isValidArgument(it)):
+		return p.parseExpr(&it, exprConfig{
+			allowArg:     false,
+			allowFuncall: true,
+			allowConcat:  true,
+		})
+	}
+
+	return nil, newParserError(it, p.name,
+		"Unexpected token %v. Expecting STRING, NUMBER, VARIABLE, list or map", it)
+}
+
+// parseSpawn parses the function invocation of spawn. The function
+// can be a named one, a variable or an anonymous function.
+func (p *Parser) parseSpawn(spawnTok scanner.Token) (ast.Node, error) {
+	var fnDecl *ast.FnDeclNode
+
+	it := p.next()
+
+	if it.Type() == token.Fn {
+		decl, err := p.parseFnDecl(it)
+		if err != nil {
+			return nil, err
+		}
+
+		fnDecl = decl.(*ast.FnDeclNode)
+
+		if fnDecl.Name() != "" {
+			return nil, newParserError(it, p.name,
+				"Unexpected function name %s. Spawn expects an anonymous function",
+				fnDecl.Name())
+		}
+	} else if !isFuncall(it.Type(), p.peek().Type()) {
+		return nil, newParserError(it, p.name,
+			"Unexpected token %v. Spawn expects a function invocation", it)
+	}
+
+	inv, err := p.parseFnInv(it, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fnInv := inv.(*ast.FnInvNode)
+
+	if fnDecl != nil {
+		fnInv.SetName("")
+	}
+
+	n := ast.NewSpawnNode(spawnTok.FileInfo, fnInv)
+	n.SetFnDecl(fnDecl)
+	return n, nil
+}
+
 func (p *Parser) parseElse() (*ast.BlockNode, bool, error) {
 	it := p.next()
 
@@ -1322,6 +2340,78 @@ func (p *Parser) parseBindFn(bindIt scanner.Token) (ast.Node, error) {
 	return n, nil
 }
 
+func (p *Parser) parseShowEnv(it scanner.Token) (ast.Node, error) {
+	next := p.peek()
+
+	if next.Type() == token.Semicolon {
+		p.ignore()
+	} else if next.Type() != token.RBrace && next.Type() != token.EOF {
+		return nil, newParserError(next, p.name,
+			"Unexpected token %v, expected semicolon (;) or EOL", next)
+	}
+
+	return ast.NewShowEnvNode(it.FileInfo), nil
+}
+
+func (p *Parser) parseDump(dumpIt scanner.Token) (ast.Node, error) {
+	var filename ast.Expr
+
+	// dump
+	// dump <file>
+	// dump "<file>"
+	// dump $file
+	next := p.peek()
+
+	if next.Type() != token.Semicolon && next.Type() != token.RBrace &&
+		next.Type() != token.EOF {
+		arg, err := p.getArgument(nil, exprConfig{
+			allowArg:      true,
+			allowConcat:   true,
+			allowFuncall:  false,
+			allowVariadic: false,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		filename = arg
+	}
+
+	if p.peek().Type() == token.Semicolon {
+		p.ignore()
+	}
+
+	return ast.NewDumpNode(dumpIt.FileInfo, filename), nil
+}
+
+func (p *Parser) parseDefer(deferTok scanner.Token) (ast.Node, error) {
+	var (
+		stmt ast.Node
+		err  error
+	)
+
+	it := p.next()
+
+	// defer <fn name>(...)
+	// defer <command> ...
+	// defer (<command> | <command>)
+	if isFuncall(it.Type(), p.peek().Type()) {
+		stmt, err = p.parseFnInv(it, true)
+	} else if it.Type() == token.Ident || it.Type() == token.Arg ||
+		it.Type() == token.LParen {
+		stmt, err = p.parseCommand(it)
+	} else {
+		return nil, newParserError(it, p.name,
+			"Unexpected token %v. Defer expects a command or function invocation", it)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.NewDeferNode(deferTok.FileInfo, stmt), nil
+}
+
 func (p *Parser) parseReturn(retTok scanner.Token) (ast.Node, error) {
 	ret := ast.NewReturnNode(retTok.FileInfo)
 
@@ -1332,16 +2422,20 @@ func (p *Parser) parseReturn(retTok scanner.Token) (ast.Node, error) {
 	// return $v
 	// return "<some>"
 	// return ( ... values ... )
+	// return { ... entries ... }
 	// return <fn name>()
+	// return $i + 1
 	// return "val1", "val2", $val3, test()
 	if tok.Type() != token.Semicolon &&
 		tok.Type() != token.RBrace &&
 		tok.Type() != token.Variable &&
 		tok.Type() != token.String &&
 		tok.Type() != token.LParen &&
-		tok.Type() != token.Ident {
+		tok.Type() != token.LBrace &&
+		tok.Type() != token.Ident &&
+		!isIntLiteral(tok) {
 		return nil, newParserError(tok, p.name,
-			"Expected ';', STRING, VARIABLE, FUNCALL or LPAREN, but found %v",
+			"Expected ';', STRING, NUMBER, VARIABLE, FUNCALL, LPAREN or LBRACE, but found %v",
 			tok)
 	}
 
@@ -1364,6 +2458,12 @@ func (p *Parser) parseReturn(retTok scanner.Token) (ast.Node, error) {
 				return nil, err
 			}
 			returnExprs = append(returnExprs, listArg)
+		} else if tok.Type() == token.LBrace {
+			mapArg, err := p.parseMap(nil)
+			if err != nil {
+				return nil, err
+			}
+			returnExprs = append(returnExprs, mapArg)
 		} else if tok.Type() == token.Ident {
 			p.next()
 			next := p.peek()
@@ -1374,14 +2474,19 @@ func (p *Parser) parseReturn(retTok scanner.Token) (ast.Node, error) {
 					tok.Value(), next)
 			}
 
-			arg, err := p.parseFnInv(tok, true)
+			arg, err := p.parseExpr(&tok, exprConfig{
+				allowArg:      false,
+				allowConcat:   true,
+				allowFuncall:  true,
+				allowVariadic: false,
+			})
 			if err != nil {
 				return nil, err
 			}
 
 			returnExprs = append(returnExprs, arg)
 		} else {
-			arg, err := p.getArgument(nil, exprConfig{
+			arg, err := p.parseExpr(nil, exprConfig{
 				allowArg:      false,
 				allowConcat:   true,
 				allowFuncall:  true,
@@ -1413,9 +2518,32 @@ func (p *Parser) parseReturn(retTok scanner.Token) (ast.Node, error) {
 	return ret, nil
 }
 
+func (p *Parser) parseLoopCtl(it scanner.Token) (ast.Node, error) {
+	if p.openloops <= 0 {
+		return nil, newParserError(it, p.name,
+			"Unexpected '%s' outside of for loop", it.Value())
+	}
+
+	next := p.peek()
+
+	if next.Type() == token.Semicolon {
+		p.ignore()
+	} else if next.Type() != token.RBrace {
+		return nil, newParserError(next, p.name,
+			"Unexpected token %v after '%s'", next, it.Value())
+	}
+
+	if it.Type() == token.Break {
+		return ast.NewBreakNode(it.FileInfo), nil
+	}
+
+	return ast.NewContinueNode(it.FileInfo), nil
+}
+
 func (p *Parser) parseFor(it scanner.Token) (ast.Node, error) {
 	var (
 		inExpr ast.Expr
+		cond   ast.Expr
 		err    error
 		next   scanner.Token
 	)
@@ -1424,16 +2552,62 @@ func (p *Parser) parseFor(it scanner.Token) (ast.Node, error) {
 
 	it = p.peek()
 
-	if it.Type() != token.Ident {
+	if it.Type() == token.LBrace {
 		goto forBlockParse
 	}
 
+	if it.Type() == token.LParen {
+		// for (key, value) in ... or a grouped condition
+		p.ignore()
+
+		ident := p.next()
+
+		if ident.Type() == token.Ident && p.peek().Type() == token.Comma {
+			err = p.parseForPairs(forStmt, ident)
+			if err != nil {
+				return nil, err
+			}
+
+			goto forBlockParse
+		}
+
+		p.backup(ident)
+		cond, err = p.parseCondition(&it)
+		goto forCondSet
+	}
+
+	if it.Type() != token.Ident {
+		goto forCondParse
+	}
+
 	p.next()
 
+	next = p.peek()
+
+	if next.Type() != token.Comma &&
+		(next.Type() != token.Ident || next.Value() != "in") {
+		// for <cond> { ... }
+		cond, err = p.parseCondition(&it)
+		goto forCondSet
+	}
+
 	forStmt.SetIdentifier(it.Value())
 
 	it = p.next()
 
+	if it.Type() == token.Comma {
+		// for key, value in ...
+		it = p.next()
+
+		if it.Type() != token.Ident {
+			return nil, newParserError(it, p.name,
+				"Expected identifier but found %q", it)
+		}
+
+		forStmt.SetValueIdentifier(it.Value())
+		it = p.next()
+	}
+
 	if it.Type() != token.Ident || it.Value() != "in" {
 		return nil, newParserError(it, p.name,
 			"Expected 'in' but found %q", it)
@@ -1445,6 +2619,16 @@ func (p *Parser) parseFor(it scanner.Token) (ast.Node, error) {
 	it = p.next()
 	next = p.peek()
 
+	if it.Type() == token.AssignCmd {
+		// for line in <= cmd args { ... }
+		err = p.parseForCmd(forStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		goto forBlockParse
+	}
+
 	if it.Type() != token.Variable &&
 		(it.Type() != token.Ident || (it.Type() == token.Ident && next.Type() != token.LParen)) &&
 		it.Type() != token.LParen {
@@ -1465,6 +2649,18 @@ func (p *Parser) parseFor(it scanner.Token) (ast.Node, error) {
 	}
 
 	forStmt.SetInExpr(inExpr)
+	goto forBlockParse
+
+forCondParse:
+	cond, err = p.parseCondition(nil)
+
+forCondSet:
+	if err != nil {
+		return nil, err
+	}
+
+	forStmt.SetCond(cond)
+
 forBlockParse:
 	it = p.peek()
 
@@ -1480,7 +2676,9 @@ forBlockParse:
 
 	tree := ast.NewTree("for block")
 
+	p.openloops++
 	r, err := p.parseBlock(blockPos.Line(), blockPos.Column())
+	p.openloops--
 
 	if err != nil {
 		return nil, err
@@ -1511,6 +2709,40 @@ func newParserError(item scanner.Token, name, format string, args ...interface{}
 	return errors.NewError("%s:%d:%d: %s", name, item.Line(), item.Column(), errstr)
 }
 
+// isIntLiteral tells if the token is an integer literal. Negative
+// integers are lexed as arguments.
+func isIntLiteral(t scanner.Token) bool {
+	if t.Type() == token.Number {
+		return true
+	}
+
+	if t.Type() != token.Arg || len(t.Value()) < 2 || t.Value()[0] != '-' {
+		return false
+	}
+
+	for _, r := range t.Value()[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isArithOp(t scanner.Token, ops ...string) bool {
+	if t.Type() != token.Arg {
+		return false
+	}
+
+	for _, op := range ops {
+		if t.Value() == op {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isValidArgument(t scanner.Token) bool {
 	if t.Type() == token.String ||
 		t.Type() == token.Number ||
@@ -1525,6 +2757,45 @@ func isValidArgument(t scanner.Token) bool {
 	return false
 }
 
+// typeNames are the types allowed in type annotations.
+var typeNames = []string{"str", "int", "list", "map", "fn"}
+
+func isTypeName(name string) bool {
+	for _, t := range typeNames {
+		if t == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTypeColon tells if it starts a type annotation, as ":" or ":str".
+func isTypeColon(it scanner.Token) bool {
+	return it.Type() == token.Arg && strings.HasPrefix(it.Value(), ":")
+}
+
+// isTypedArg tells if it is an argument with type annotation read by
+// the lexer as a single token, as "name:" or "name:str".
+func isTypedArg(it scanner.Token) bool {
+	if it.Type() != token.Arg {
+		return false
+	}
+
+	i := strings.IndexByte(it.Value(), ':')
+	if i <= 0 {
+		return false
+	}
+
+	for _, r := range it.Value()[:i] {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
 func isFuncall(tok, next token.Token) bool {
 	return (tok == token.Ident || tok == token.Variable) &&
 		next == token.LParen
@@ -1540,5 +2811,6 @@ func isAssignment(tok token.Token) bool {
 func isExpr(tok token.Token) bool {
 	return tok == token.Variable ||
 		tok == token.String ||
-		tok == token.LParen
+		tok == token.LParen ||
+		tok == token.LBrace
 }
@@ -89,6 +89,46 @@ testb = "b"`},
 	testFmtTable(testTable, t)
 }
 
+func TestFmtMaps(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`test = {}`, `test = {}`},
+		{`test={"a":"1"}`, `test = {"a": "1"}`},
+		{`test = {"a": "1", $b: (1 2),}`, `test = {"a": "1", $b: (1 2)}`},
+		{`test = {
+	"a": "1",
+	"b": "2"
+}`, `test = {"a": "1", "b": "2"}`},
+		{`test = {"plan9": "bell labs", "linux": "torvalds", "openbsd": "de raadt"}`, `test = {
+	"plan9": "bell labs",
+	"linux": "torvalds",
+	"openbsd": "de raadt",
+}`},
+		{`for k,v in $m { echo $k $v }`, `for k, v in $m {
+	echo $k $v
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtArithmetic(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`test = 1`, `test = 1`},
+		{`test = -1`, `test = -1`},
+		{`test = $a   +   1`, `test = $a + 1`},
+		{`test = $a * 2 + 1 - $b / 3 % 2`, `test = $a * 2 + 1 - $b / 3 % 2`},
+		{`test = $a+$b`, `test = $a+$b`},
+		{`if $a + 1 > 2 { pwd }`, `if $a + 1 > 2 {
+	pwd
+}`},
+		{`fn f(a) { return $a * 2 }`, `fn f(a) {
+	return $a * 2
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
 func TestFmtGroupVariables(t *testing.T) {
 	testTable := []fmtTestTable{
 		{
@@ -145,6 +185,28 @@ fn t() {
 	testFmtTable(testTable, t)
 }
 
+func TestFmtFnArgs(t *testing.T) {
+	testTable := []fmtTestTable{
+		{
+			`fn deploy(env,region="us-east-1",  zones = (a b)) { echo $env }
+deploy("prod",region=$r)`,
+			`fn deploy(env, region = "us-east-1", zones = (a b)) {
+	echo $env
+}
+
+deploy("prod", region = $r)`,
+		},
+		{
+			`fn get(m:map,key :str = "a", f : fn, tags... : str):str,int { return $m[$key], 1 }`,
+			`fn get(m: map, key: str = "a", f: fn, tags...: str): str, int {
+	return $m[$key], 1
+}`,
+		},
+	}
+
+	testFmtTable(testTable, t)
+}
+
 func TestFmtImports(t *testing.T) {
 	testTable := []fmtTestTable{
 		{
@@ -155,6 +217,13 @@ import test`,
 			`import test
 import test
 import test`,
+		},
+		{
+			`import "map"   as   m
+var v <= m.get($a, "b")`,
+			`import "map" as m
+
+var v <= m.get($a, "b")`,
 		},
 		{
 			`import nashlib/all
@@ -300,12 +369,222 @@ create_prod()`,
 	testFmtTable(testTable, t)
 }
 
+func TestFmtIfConditions(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`if $a =="1"&&  $b !="2" { pwd }`, `if $a == "1" && $b != "2" {
+	pwd
+}`},
+		{`if $a == "1" || $b == "2" && $c == "3" { pwd }`, `if $a == "1" || $b == "2" && $c == "3" {
+	pwd
+}`},
+		{`if ($a == "1" || $b == "2") && $c == "3" { pwd }`, `if ($a == "1" || $b == "2") && $c == "3" {
+	pwd
+}`},
+		{`if $a == "1" && ($b == "2" && $c == "3") { pwd }`, `if $a == "1" && ($b == "2" && $c == "3") {
+	pwd
+}`},
+		{`if !$a == "1" { pwd }`, `if !($a == "1") {
+	pwd
+}`},
+		{`if !(!($a > "1")) { pwd }`, `if !!($a > "1") {
+	pwd
+}`},
+		{`if $l == (a b) { pwd }`, `if $l == (a b) {
+	pwd
+}`},
+		{`if   !grep -q a $file ||echo a|grep -q b { pwd }`, `if !grep -q a $file || echo a | grep -q b {
+	pwd
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtForCond(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`for $i <10 &&  !test -f $file { echo $i }`, `for $i < 10 && !test -f $file {
+	echo $i
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtForPairs(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`for ( k,v ) in $pairs { echo $k $v }`, `for (k, v) in $pairs {
+	echo $k $v
+}`},
+		{`for ($i < 10) && $ok == "1" { echo $i }`, `for $i < 10 && $ok == "1" {
+	echo $i
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtForCmd(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`for line in   <=  cat   /etc/passwd { echo $line }`, `for line in <= cat /etc/passwd {
+	echo $line
+}`},
+		{`for i,l in <= -ls $dir { echo $i $l }`, `for i, l in <= -ls $dir {
+	echo $i $l
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtBreakContinue(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`for { if $a == "1" { continue }
+break }`, `for {
+	if $a == "1" {
+		continue
+	}
+
+	break
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtAppendRedirect(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`echo hello >>   /tmp/log`, `echo hello >> /tmp/log`},
+		{`echo hello >>[1]   /tmp/log  >>[2] /tmp/err`, `echo hello >>[1] /tmp/log >>[2] /tmp/err`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtInputRedirect(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`cat <[0]   /tmp/file`, `cat <[0] /tmp/file`},
+		{`cat <   "unix:///tmp/sock" | grep a`, `cat < "unix:///tmp/sock" | grep a`},
+		{`psql <<<   $query >[2=]`, `psql <<< $query >[2=]`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtBackground(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`sleep 10   &`, `sleep 10 &`},
+		{`var job <=   ls | wc -l &`, `var job <= ls | wc -l &`},
+		{`(make all) &`, `(make all) &`},
+		{`(make |[2] grep error)   &`, `(make |[2] grep error) &`},
+		{`if $ok == "0" {
+	download &
+}`, `if $ok == "0" {
+	download &
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtSpawn(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`spawn   worker($a,   "b")`, `spawn worker($a, "b")`},
+		{`var pid <=   spawn $worker()`, `var pid <= spawn $worker()`},
+		{`var pid <= spawn fn(msg) { echo $msg }("hi")`, `var pid <= spawn fn (msg) {
+	echo $msg
+}("hi")`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtDefer(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`fn test() {
+	defer   rm -rf $dir
+	defer cleanup($a,   "b")
+	defer (ls | wc -l)
+}`, `fn test() {
+	defer rm -rf $dir
+	defer cleanup($a, "b")
+	defer (ls | wc -l)
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtSwitch(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`switch $ARGS[1]   {
+case "start",   "run" {
+start()
+}
+case   glob "*.tar" {}
+case ("a"   "b") {
+if $a == "b" { echo b }
+echo c
+}
+default {
+usage()
+}
+}`, `switch $ARGS[1] {
+	case "start", "run" {
+		start()
+	}
+	case glob "*.tar" {
+
+	}
+	case ("a" "b") {
+		if $a == "b" {
+			echo b
+		}
+
+		echo c
+	}
+	default {
+		usage()
+	}
+}`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
+func TestFmtDump(t *testing.T) {
+	testTable := []fmtTestTable{
+		{`showenv`, `showenv`},
+		{`dump`, `dump`},
+		{`dump   session.sh`, `dump session.sh`},
+		{`dump $HOME+"/session.sh"`, `dump $HOME+"/session.sh"`},
+	}
+
+	testFmtTable(testTable, t)
+}
+
 func TestFmtPipes(t *testing.T) {
 	testTable := []fmtTestTable{
 		{
 			`echo hello | grep "he" > test`,
 			`echo hello | grep "he" > test`,
 		},
+		{
+			`make   |[2]   grep error |[1,2] wc -l`,
+			`make |[2] grep error |[1,2] wc -l`,
+		},
+		{
+			`(make |[2] grep error)`,
+			`(make |[2] grep error)`,
+		},
+		{
+			`(make all >[1=] |[2] grep -v warning >[1] /tmp/errors.txt)`,
+			`(
+	make all
+		>[1=] |[2]
+	grep -v warning
+		>[1] /tmp/errors.txt
+)`,
+		},
 		{
 			`(echo hello | sed "s/he/wo/g" >[1] /tmp/test >[2] /dev/null)`,
 			`(
@@ -126,6 +126,157 @@ func TestParsePipe(t *testing.T) {
 	parserTest("parser pipe", `echo "hello world" | awk "{print $1}"`, expected, t, true)
 }
 
+func TestParsePipeStderr(t *testing.T) {
+	expected := ast.NewTree("parser pipe stderr")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	first := ast.NewCommandNode(token.NewFileInfo(1, 0), "make", false)
+	first.SetPipeFds([]int{2})
+
+	second := ast.NewCommandNode(token.NewFileInfo(1, 10), "grep", false)
+	second.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 15), "error", false))
+	second.SetPipeFds([]int{1, 2})
+
+	third := ast.NewCommandNode(token.NewFileInfo(1, 28), "wc", false)
+	third.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 31), "-l", false))
+
+	pipe := ast.NewPipeNode(token.NewFileInfo(1, 5), false)
+	pipe.AddCmd(first)
+	pipe.AddCmd(second)
+	pipe.AddCmd(third)
+
+	ln.Push(pipe)
+
+	expected.Root = ln
+
+	parserTest("parser pipe stderr", `make |[2] grep error |[1,2] wc -l`, expected, t, true)
+
+	for _, test := range []string{
+		`make |[] grep error`,
+		`make |[0] grep error`,
+		`make |[3] grep error`,
+		`make |[2,2] grep error`,
+		`make |[1 2] grep error`,
+		`make |[2=1] grep error`,
+		`make |[2 grep error`,
+	} {
+		parserTestFail(t, test)
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	expected := ast.NewTree("background command")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cmd := ast.NewCommandNode(token.NewFileInfo(1, 0), "sleep", false)
+	cmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 6), "10", false))
+	cmd.SetBackground(true)
+	ln.Push(cmd)
+
+	expected.Root = ln
+
+	parserTest("background command", `sleep 10 &`, expected, t, true)
+
+	expected = ast.NewTree("background pipe")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	first := ast.NewCommandNode(token.NewFileInfo(1, 7), "ls", false)
+	second := ast.NewCommandNode(token.NewFileInfo(1, 12), "wc", false)
+	second.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 15), "-l", false))
+
+	pipe := ast.NewPipeNode(token.NewFileInfo(1, 10), false)
+	pipe.AddCmd(first)
+	pipe.AddCmd(second)
+	pipe.SetBackground(true)
+
+	assign, err := ast.NewExecAssignNode(token.NewFileInfo(1, 0),
+		[]*ast.NameNode{
+			ast.NewNameNode(token.NewFileInfo(1, 0), "job", nil),
+		},
+		pipe,
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Push(assign)
+
+	expected.Root = ln
+
+	parserTest("background pipe", `job <= ls | wc -l &`, expected, t, true)
+
+	expected = ast.NewTree("mid-line &")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cmd = ast.NewCommandNode(token.NewFileInfo(1, 0), "echo", false)
+	cmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 5), "a", false))
+	cmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 7), "&", false))
+	cmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 9), "b", false))
+	ln.Push(cmd)
+
+	expected.Root = ln
+
+	parserTest("mid-line &", `echo a & b`, expected, t, true)
+
+	for _, test := range []string{
+		`out, status <= sleep 10 &`,
+		`if sleep 10 & }`,
+	} {
+		parserTestFail(t, test)
+	}
+}
+
+func TestParseSpawn(t *testing.T) {
+	expected := ast.NewTree("spawn fn")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	fnInv := ast.NewFnInvNode(token.NewFileInfo(1, 17), "worker")
+	fnInv.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 25), "a", true))
+
+	assign, err := ast.NewExecAssignNode(token.NewFileInfo(1, 4),
+		[]*ast.NameNode{
+			ast.NewNameNode(token.NewFileInfo(1, 4), "pid", nil),
+		},
+		ast.NewSpawnNode(token.NewFileInfo(1, 11), fnInv),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Push(ast.NewVarExecAssignDecl(token.NewFileInfo(1, 0), assign))
+	expected.Root = ln
+
+	parserTest("spawn fn", `var pid <= spawn worker("a")`, expected, t, true)
+
+	expected = ast.NewTree("spawn anonymous fn")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	fn := ast.NewFnDeclNode(token.NewFileInfo(1, 9), "")
+	fn.AddArg(ast.NewFnArgNode(token.NewFileInfo(1, 10), "msg", false))
+	tree := ast.NewTree("fn body")
+	lnBody := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	echo := ast.NewCommandNode(token.NewFileInfo(1, 17), "echo", false)
+	echo.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 22), "$msg"))
+	lnBody.Push(echo)
+	tree.Root = lnBody
+	fn.SetTree(tree)
+
+	fnInv = ast.NewFnInvNode(token.NewFileInfo(1, 6), "")
+	fnInv.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 30), "hi", true))
+
+	spawn := ast.NewSpawnNode(token.NewFileInfo(1, 0), fnInv)
+	spawn.SetFnDecl(fn)
+	ln.Push(spawn)
+	expected.Root = ln
+
+	parserTest("spawn anonymous fn", `spawn fn (msg) { echo $msg }("hi")`,
+		expected, t, false)
+
+	for _, test := range []string{
+		`spawn echo hello`,
+		`spawn fn worker() {}()`,
+		`var a, b <= spawn worker()`,
+	} {
+		parserTestFail(t, test)
+	}
+}
+
 func TestBasicSetEnvAssignment(t *testing.T) {
 	expected := ast.NewTree("simple set assignment")
 	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
@@ -218,15 +369,61 @@ func TestBasicAssignment(t *testing.T) {
 	for _, test := range []string{
 		"test=hello",
 		"test = hello",
-		"test = 1",
 		"test = false",
-		"test = -1",
 		`test = "1", "2"`,
 	} {
 		parserTestFail(t, test)
 	}
 }
 
+func TestParseIntAssignment(t *testing.T) {
+	expected := ast.NewTree("int assignment")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	assign := ast.NewSingleAssignNode(token.NewFileInfo(1, 0),
+		ast.NewNameNode(token.NewFileInfo(1, 0), "test", nil),
+		ast.NewIntExpr(token.NewFileInfo(1, 7), -1),
+	)
+	ln.Push(assign)
+	expected.Root = ln
+
+	parserTest("int assignment", `test = -1`, expected, t, true)
+
+	// test = (($i * 2) + 1) - 3
+	mul := ast.NewBinaryExpr(token.NewFileInfo(1, 7),
+		ast.NewVarExpr(token.NewFileInfo(1, 7), "$i"),
+		"*",
+		ast.NewIntExpr(token.NewFileInfo(1, 12), 2),
+	)
+	sum := ast.NewConcatExpr(token.NewFileInfo(1, 7), []ast.Expr{
+		mul,
+		ast.NewIntExpr(token.NewFileInfo(1, 16), 1),
+	})
+	sub := ast.NewBinaryExpr(token.NewFileInfo(1, 7),
+		sum,
+		"-",
+		ast.NewIntExpr(token.NewFileInfo(1, 20), 3),
+	)
+
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	assign = ast.NewSingleAssignNode(token.NewFileInfo(1, 0),
+		ast.NewNameNode(token.NewFileInfo(1, 0), "test", nil),
+		sub,
+	)
+	ln.Push(assign)
+	expected.Root = ln
+
+	parserTest("int assignment", `test = $i * 2 + 1 - 3`, expected, t, true)
+
+	for _, test := range []string{
+		"test = 1 +",
+		"test = 1 *",
+		"test = 1 - -",
+		"test = $i * a",
+	} {
+		parserTestFail(t, test)
+	}
+}
+
 func TestVarAssignment(t *testing.T) {
 	expected := ast.NewTree("var assignment")
 	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
@@ -245,7 +442,6 @@ func TestVarAssignment(t *testing.T) {
 		"var",
 		"var test",
 		"var test = false",
-		"var test = -1",
 		`var test = "1", "2"`,
 	} {
 		parserTestFail(t, test)
@@ -339,7 +535,7 @@ func TestParseInvalidIndexing(t *testing.T) {
 	if err == nil {
 		t.Error("Parse must fail")
 		return
-	} else if err.Error() != "invalid:1:5: Expected number or variable in index. Found ARG" {
+	} else if err.Error() != "invalid:1:5: Expected number, string or variable in index. Found ARG" {
 		t.Error("Invalid err msg")
 		return
 	}
@@ -351,7 +547,7 @@ func TestParseInvalidIndexing(t *testing.T) {
 	if err == nil {
 		t.Error("Parse must fail")
 		return
-	} else if err.Error() != "invalid:1:5: Expected number or variable in index. Found ]" {
+	} else if err.Error() != "invalid:1:5: Expected number, string or variable in index. Found ]" {
 		t.Error("Invalid err msg")
 		return
 	}
@@ -363,7 +559,7 @@ func TestParseInvalidIndexing(t *testing.T) {
 	if err == nil {
 		t.Error("Parse must fail")
 		return
-	} else if err.Error() != "invalid:1:5: Expected number or variable in index. Found ARG" {
+	} else if err.Error() != "invalid:1:5: Expected number, string or variable in index. Found ARG" {
 		t.Error("Invalid err msg")
 		return
 	}
@@ -400,6 +596,34 @@ func TestParseListAssignment(t *testing.T) {
 )`, expected, t, false)
 }
 
+func TestParseMapAssignment(t *testing.T) {
+	expected := ast.NewTree("map assignment")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+
+	elem := ast.NewMapExpr(token.NewFileInfo(1, 7), nil, nil)
+	elem.PushEntry(
+		ast.NewStringExpr(token.NewFileInfo(1, 9), "plan9", true),
+		ast.NewStringExpr(token.NewFileInfo(1, 18), "bell labs", true),
+	)
+	elem.PushEntry(
+		ast.NewVarExpr(token.NewFileInfo(1, 30), "$os"),
+		ast.NewListExpr(token.NewFileInfo(1, 35), []ast.Expr{
+			ast.NewStringExpr(token.NewFileInfo(1, 36), "a", false),
+			ast.NewStringExpr(token.NewFileInfo(1, 38), "b", false),
+		}),
+	)
+
+	assign := ast.NewSingleAssignNode(token.NewFileInfo(1, 0),
+		ast.NewNameNode(token.NewFileInfo(1, 0), "test", nil),
+		elem,
+	)
+
+	ln.Push(assign)
+	expected.Root = ln
+
+	parserTest("map assignment", `test = {"plan9": "bell labs", $os: (a b)}`, expected, t, true)
+}
+
 func TestParseListOfListsAssignment(t *testing.T) {
 	expected := ast.NewTree("list assignment")
 	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
@@ -578,6 +802,74 @@ func TestParseRedirectMultiples(t *testing.T) {
 	parserTest("multiple redirects", `cmd >[1=2] >[2=]`, expected, t, true)
 }
 
+func TestParseRedirectAppend(t *testing.T) {
+	expected := ast.NewTree("append redirect")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cmd := ast.NewCommandNode(token.NewFileInfo(1, 0), "cmd", false)
+	redir1 := ast.NewRedirectNode(token.NewFileInfo(1, 4))
+	redir1.SetKind(ast.RedirAppend)
+	redir1.SetMap(1, ast.RedirMapNoValue)
+	redir1.SetLocation(ast.NewStringExpr(token.NewFileInfo(1, 10), "/var/log/cmd.log", false))
+	redir2 := ast.NewRedirectNode(token.NewFileInfo(1, 27))
+	redir2.SetMap(2, 1)
+	cmd.AddRedirect(redir1)
+	cmd.AddRedirect(redir2)
+	ln.Push(cmd)
+
+	expected.Root = ln
+
+	parserTest("append redirect", `cmd >>[1] /var/log/cmd.log >[2=1]`, expected, t, true)
+
+	expected = ast.NewTree("append redirect")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cmd = ast.NewCommandNode(token.NewFileInfo(1, 0), "cmd", false)
+	redir1 = ast.NewRedirectNode(token.NewFileInfo(1, 4))
+	redir1.SetKind(ast.RedirAppend)
+	redir1.SetLocation(ast.NewVarExpr(token.NewFileInfo(1, 7), "$log"))
+	cmd.AddRedirect(redir1)
+	ln.Push(cmd)
+
+	expected.Root = ln
+
+	parserTest("append redirect", `cmd >> $log`, expected, t, true)
+}
+
+func TestParseRedirectInput(t *testing.T) {
+	expected := ast.NewTree("input redirect")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cmd := ast.NewCommandNode(token.NewFileInfo(1, 0), "cat", false)
+	redir := ast.NewRedirectNode(token.NewFileInfo(1, 4))
+	redir.SetKind(ast.RedirInput)
+	redir.SetMap(0, ast.RedirMapNoValue)
+	redir.SetLocation(ast.NewStringExpr(token.NewFileInfo(1, 9), "/tmp/file", false))
+	cmd.AddRedirect(redir)
+	ln.Push(cmd)
+
+	expected.Root = ln
+
+	parserTest("input redirect", `cat <[0] /tmp/file`, expected, t, true)
+
+	redir.SetMap(ast.RedirMapNoValue, ast.RedirMapNoValue)
+	redir.SetLocation(ast.NewStringExpr(token.NewFileInfo(1, 7), "tcp://localhost:6666", true))
+
+	parserTest("input redirect", `cat < "tcp://localhost:6666"`, expected, t, true)
+
+	redir.SetKind(ast.RedirHereStr)
+	redir.SetLocation(ast.NewVarExpr(token.NewFileInfo(1, 8), "$data"))
+
+	parserTest("here-string", `cat <<< $data`, expected, t, true)
+
+	for _, code := range []string{
+		`cat <[0]`,
+		`cat <[0=1] /tmp/file`,
+		`cat <<<`,
+		`echo | cat <[0] /tmp/file`,
+		`echo | cat <<< $data`,
+	} {
+		parserTestFail(t, code)
+	}
+}
+
 func TestParseCommandWithStringsEqualsNot(t *testing.T) {
 	expected := ast.NewTree("strings works as expected")
 	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
@@ -852,6 +1144,28 @@ func TestParseImport(t *testing.T) {
 	expected.Root = ln
 
 	parserTest("test import", `import "env.sh"`, expected, t, true)
+
+	expected = ast.NewTree("test import as module")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	importStmt = ast.NewImportNode(token.NewFileInfo(1, 0),
+		ast.NewStringExpr(token.NewFileInfo(1, 8), "map", true))
+	importStmt.Name = "m"
+	ln.Push(importStmt)
+	fnInv := ast.NewFnInvNode(token.NewFileInfo(2, 0), "m.get")
+	fnInv.AddArg(ast.NewVarExpr(token.NewFileInfo(2, 6), "$a"))
+	ln.Push(fnInv)
+	expected.Root = ln
+
+	parserTest("test import as module", `import "map" as m
+m.get($a)`, expected, t, false)
+
+	for _, test := range []string{
+		`import map as`,
+		`import map as "m"`,
+		`import map as m.n`,
+	} {
+		parserTestFail(t, test)
+	}
 }
 
 func TestParseIf(t *testing.T) {
@@ -1443,6 +1757,220 @@ func TestParseReturn(t *testing.T) {
 	parserTest("return", `return "1", "2", "3"`, expected, t, true)
 }
 
+func TestParseDefer(t *testing.T) {
+	expected := ast.NewTree("defer command")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	rm := ast.NewCommandNode(token.NewFileInfo(1, 6), "rm", false)
+	rm.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 9), "-rf", false))
+	rm.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 13), "$dir"))
+	ln.Push(ast.NewDeferNode(token.NewFileInfo(1, 0), rm))
+	expected.Root = ln
+
+	parserTest("defer command", `defer rm -rf $dir`, expected, t, true)
+
+	expected = ast.NewTree("defer fn")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	cleanup := ast.NewFnInvNode(token.NewFileInfo(1, 6), "cleanup")
+	cleanup.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 14), "$dir"))
+	ln.Push(ast.NewDeferNode(token.NewFileInfo(1, 0), cleanup))
+	expected.Root = ln
+
+	parserTest("defer fn", `defer cleanup($dir)`, expected, t, true)
+
+	for _, test := range []string{
+		`defer`,
+		`defer "rm"`,
+		`defer var a = "1"`,
+	} {
+		parserTestFail(t, test)
+	}
+}
+
+func TestParseSwitch(t *testing.T) {
+	expected := ast.NewTree("switch")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	sw := ast.NewSwitchNode(token.NewFileInfo(1, 0),
+		ast.NewVarExpr(token.NewFileInfo(1, 7), "$x"))
+
+	caseAB := ast.NewCaseNode(token.NewFileInfo(2, 1), false)
+	caseAB.AddPattern(ast.NewStringExpr(token.NewFileInfo(2, 7), "a", true))
+	caseAB.AddPattern(ast.NewStringExpr(token.NewFileInfo(2, 12), "b", true))
+	abBlock := ast.NewBlockNode(token.NewFileInfo(2, 15))
+	abBlock.Push(ast.NewCommandNode(token.NewFileInfo(3, 2), "ls", false))
+	abTree := ast.NewTree("case block")
+	abTree.Root = abBlock
+	caseAB.SetTree(abTree)
+	sw.AddCase(caseAB)
+
+	caseGlob := ast.NewCaseNode(token.NewFileInfo(5, 1), true)
+	caseGlob.AddPattern(ast.NewStringExpr(token.NewFileInfo(5, 12), "*.tar", true))
+	caseGlob.AddPattern(ast.NewListExpr(token.NewFileInfo(5, 20), []ast.Expr{
+		ast.NewVarExpr(token.NewFileInfo(5, 21), "$ext"),
+	}))
+	globTree := ast.NewTree("case block")
+	globTree.Root = ast.NewBlockNode(token.NewFileInfo(5, 27))
+	caseGlob.SetTree(globTree)
+	sw.AddCase(caseGlob)
+
+	defaultBlock := ast.NewBlockNode(token.NewFileInfo(8, 9))
+	defaultBlock.Push(ast.NewCommandNode(token.NewFileInfo(9, 2), "pwd", false))
+	defaultTree := ast.NewTree("default block")
+	defaultTree.Root = defaultBlock
+	sw.SetDefaultTree(defaultTree)
+
+	ln.Push(sw)
+	expected.Root = ln
+
+	parserTest("switch", `switch $x {
+	case "a", "b" {
+		ls
+	}
+	case glob "*.tar", ($ext) {
+
+	}
+	default {
+		pwd
+	}
+}`, expected, t, true)
+
+	for _, test := range []string{
+		`switch {}`,
+		`switch $x { echo }`,
+		`switch $x { case {} }`,
+		`switch $x { case "a" }`,
+		`switch $x { default {} default {} }`,
+	} {
+		parserTestFail(t, test)
+	}
+}
+
+func TestParseDump(t *testing.T) {
+	expected := ast.NewTree("dump")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(ast.NewDumpNode(token.NewFileInfo(1, 0), nil))
+	expected.Root = ln
+
+	parserTest("dump", `dump`, expected, t, true)
+
+	expected = ast.NewTree("dump file")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(ast.NewDumpNode(token.NewFileInfo(1, 0),
+		ast.NewStringExpr(token.NewFileInfo(1, 5), "/tmp/session.sh", false)))
+	expected.Root = ln
+
+	parserTest("dump file", `dump /tmp/session.sh`, expected, t, true)
+
+	expected = ast.NewTree("dump variable")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(ast.NewDumpNode(token.NewFileInfo(1, 0),
+		ast.NewConcatExpr(token.NewFileInfo(1, 5), []ast.Expr{
+			ast.NewVarExpr(token.NewFileInfo(1, 5), "$HOME"),
+			ast.NewStringExpr(token.NewFileInfo(1, 12), "/session.sh", true),
+		})))
+	expected.Root = ln
+
+	parserTest("dump variable", `dump $HOME+"/session.sh"`, expected, t, true)
+}
+
+func TestParseShowEnv(t *testing.T) {
+	expected := ast.NewTree("showenv")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(ast.NewShowEnvNode(token.NewFileInfo(1, 0)))
+	expected.Root = ln
+
+	parserTest("showenv", `showenv`, expected, t, true)
+
+	parserTestFail(t, `showenv PATH`)
+}
+
+func TestParseIfBooleanExpr(t *testing.T) {
+	expected := ast.NewTree("test if with boolean expression")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ifDecl := ast.NewIfNode(token.NewFileInfo(1, 0))
+
+	// !$a == "x" && ($b > "1" || $c < "2")
+	notExpr := ast.NewUnaryExpr(token.NewFileInfo(1, 3), "!",
+		ast.NewBinaryExpr(token.NewFileInfo(1, 4),
+			ast.NewVarExpr(token.NewFileInfo(1, 4), "$a"),
+			"==",
+			ast.NewStringExpr(token.NewFileInfo(1, 11), "x", true),
+		),
+	)
+	orExpr := ast.NewBinaryExpr(token.NewFileInfo(1, 18),
+		ast.NewBinaryExpr(token.NewFileInfo(1, 18),
+			ast.NewVarExpr(token.NewFileInfo(1, 18), "$b"),
+			">",
+			ast.NewStringExpr(token.NewFileInfo(1, 24), "1", true),
+		),
+		"||",
+		ast.NewBinaryExpr(token.NewFileInfo(1, 30),
+			ast.NewVarExpr(token.NewFileInfo(1, 30), "$c"),
+			"<",
+			ast.NewStringExpr(token.NewFileInfo(1, 36), "2", true),
+		),
+	)
+	ifDecl.SetCond(ast.NewBinaryExpr(token.NewFileInfo(1, 3), notExpr, "&&", orExpr))
+
+	subBlock := ast.NewBlockNode(token.NewFileInfo(1, 40))
+	cmd := ast.NewCommandNode(token.NewFileInfo(2, 1), "pwd", false)
+	subBlock.Push(cmd)
+
+	ifTree := ast.NewTree("if block")
+	ifTree.Root = subBlock
+
+	ifDecl.SetIfTree(ifTree)
+
+	ln.Push(ifDecl)
+	expected.Root = ln
+
+	parserTest("test if", `if !$a == "x" && ($b > "1" || $c < "2") {
+	pwd
+}`, expected, t, false)
+}
+
+func TestParseIfCommand(t *testing.T) {
+	expected := ast.NewTree("test if with commands")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ifDecl := ast.NewIfNode(token.NewFileInfo(1, 0))
+
+	testCmd := ast.NewCommandNode(token.NewFileInfo(1, 4), "test", false)
+	testCmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 9), "-d", false))
+	testCmd.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 12), "$dir"))
+
+	echo := ast.NewCommandNode(token.NewFileInfo(1, 20), "echo", false)
+	echo.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 25), "$a"))
+
+	grep := ast.NewCommandNode(token.NewFileInfo(1, 30), "grep", false)
+	grep.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 35), "-q", false))
+	grep.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 38), "b", false))
+
+	pipe := ast.NewPipeNode(token.NewFileInfo(1, 28), false)
+	pipe.AddCmd(echo)
+	pipe.AddCmd(grep)
+
+	ifDecl.SetCond(ast.NewBinaryExpr(token.NewFileInfo(1, 3),
+		ast.NewUnaryExpr(token.NewFileInfo(1, 3), "!", testCmd),
+		"||",
+		pipe,
+	))
+
+	subBlock := ast.NewBlockNode(token.NewFileInfo(1, 40))
+	cmd := ast.NewCommandNode(token.NewFileInfo(2, 1), "pwd", false)
+	subBlock.Push(cmd)
+
+	ifTree := ast.NewTree("if block")
+	ifTree.Root = subBlock
+
+	ifDecl.SetIfTree(ifTree)
+
+	ln.Push(ifDecl)
+	expected.Root = ln
+
+	parserTest("test if", `if !test -d $dir || echo $a | grep -q b {
+	pwd
+}`, expected, t, true)
+}
+
 func TestParseIfInvalid(t *testing.T) {
 	parser := NewParser("if invalid", `if a == b { pwd }`)
 	_, err := parser.Parse()
@@ -1453,6 +1981,22 @@ func TestParseIfInvalid(t *testing.T) {
 	}
 }
 
+func TestParseIfInvalidCondition(t *testing.T) {
+	for _, code := range []string{
+		`if $a == "b" && { pwd }`,
+		`if ($a == "b" { pwd }`,
+		`if $a == "b" || ! { pwd }`,
+		`if $a = "b" { pwd }`,
+	} {
+		parser := NewParser("if invalid condition", code)
+		_, err := parser.Parse()
+
+		if err == nil {
+			t.Errorf("Must fail: %s", code)
+		}
+	}
+}
+
 func TestParseFor(t *testing.T) {
 	expected := ast.NewTree("for")
 
@@ -1503,6 +2047,177 @@ func TestParseFor(t *testing.T) {
 	parserTest("for", `for f in (1 2 3 4 5) {
 
 }`, expected, t, true)
+
+	forStmt.SetIdentifier("k")
+	forStmt.SetValueIdentifier("v")
+	forStmt.SetInExpr(ast.NewVarExpr(token.NewFileInfo(1, 12), "$m"))
+
+	parserTest("for", `for k, v in $m {
+
+}`, expected, t, true)
+
+	forStmt.SetPairs(true)
+	forStmt.SetInExpr(ast.NewVarExpr(token.NewFileInfo(1, 14), "$pairs"))
+
+	parserTest("for", `for (k, v) in $pairs {
+
+}`, expected, t, true)
+
+	for _, tc := range []string{
+		`for (k) in $pairs {}`,
+		`for (k, v in $pairs {}`,
+		`for (k, v) $pairs {}`,
+		`for (k, v) in <= ls {}`,
+	} {
+		parserTestFail(t, tc)
+	}
+}
+
+func TestParseForCond(t *testing.T) {
+	expected := ast.NewTree("for")
+
+	forStmt := ast.NewForNode(token.NewFileInfo(1, 0))
+	forTree := ast.NewTree("for block")
+	forTree.Root = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	forStmt.SetTree(forTree)
+
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(forStmt)
+	expected.Root = ln
+
+	forStmt.SetCond(ast.NewBinaryExpr(token.NewFileInfo(1, 4),
+		ast.NewVarExpr(token.NewFileInfo(1, 4), "$i"),
+		"<",
+		ast.NewIntExpr(token.NewFileInfo(1, 9), 10),
+	))
+
+	parserTest("for cond", `for $i < 10 {
+
+}`, expected, t, true)
+
+	testCmd := ast.NewCommandNode(token.NewFileInfo(1, 5), "test", false)
+	testCmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 10), "-f", false))
+	testCmd.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 13), "$file"))
+
+	forStmt.SetCond(ast.NewUnaryExpr(token.NewFileInfo(1, 4), "!", testCmd))
+
+	parserTest("for cond", `for !test -f $file {
+
+}`, expected, t, true)
+
+	testCmd = ast.NewCommandNode(token.NewFileInfo(1, 4), "test", false)
+	testCmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 9), "-d", false))
+	testCmd.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 12), "$dir"))
+
+	fnInv := ast.NewFnInvNode(token.NewFileInfo(1, 20), "status")
+
+	forStmt.SetCond(ast.NewBinaryExpr(token.NewFileInfo(1, 4),
+		testCmd,
+		"&&",
+		ast.NewBinaryExpr(token.NewFileInfo(1, 20),
+			fnInv,
+			"!=",
+			ast.NewStringExpr(token.NewFileInfo(1, 33), "done", true),
+		),
+	))
+
+	parserTest("for cond", `for test -d $dir && status() != "done" {
+
+}`, expected, t, true)
+}
+
+func TestParseForCmd(t *testing.T) {
+	expected := ast.NewTree("for")
+
+	forStmt := ast.NewForNode(token.NewFileInfo(1, 0))
+	forTree := ast.NewTree("for block")
+	forTree.Root = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	forStmt.SetTree(forTree)
+
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(forStmt)
+	expected.Root = ln
+
+	catCmd := ast.NewCommandNode(token.NewFileInfo(1, 15), "cat", false)
+	catCmd.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 19), "/etc/passwd", false))
+
+	forStmt.SetIdentifier("line")
+	forStmt.SetInCmd(catCmd)
+
+	parserTest("for cmd", `for line in <= cat /etc/passwd {
+
+}`, expected, t, true)
+
+	lsCmd := ast.NewCommandNode(token.NewFileInfo(1, 15), "ls", false)
+	lsCmd.AddArg(ast.NewVarExpr(token.NewFileInfo(1, 18), "$dir"))
+
+	forStmt.SetIdentifier("i")
+	forStmt.SetValueIdentifier("f")
+	forStmt.SetInCmd(lsCmd)
+
+	parserTest("for cmd", `for i, f in <= ls $dir {
+
+}`, expected, t, true)
+
+	parserTestFail(t, `for line in <= cat /etc/passwd | grep root {
+
+}`)
+	parserTestFail(t, `for line in <= {
+
+}`)
+}
+
+func TestParseBreakContinue(t *testing.T) {
+	expected := ast.NewTree("for")
+
+	forStmt := ast.NewForNode(token.NewFileInfo(1, 0))
+	forTree := ast.NewTree("for block")
+	forBlock := ast.NewBlockNode(token.NewFileInfo(1, 4))
+
+	ifDecl := ast.NewIfNode(token.NewFileInfo(2, 1))
+	ifDecl.SetLvalue(ast.NewVarExpr(token.NewFileInfo(2, 4), "$a"))
+	ifDecl.SetOp("==")
+	ifDecl.SetRvalue(ast.NewStringExpr(token.NewFileInfo(2, 11), "1", true))
+
+	ifBlock := ast.NewBlockNode(token.NewFileInfo(2, 15))
+	ifBlock.Push(ast.NewContinueNode(token.NewFileInfo(3, 2)))
+	ifTree := ast.NewTree("if block")
+	ifTree.Root = ifBlock
+	ifDecl.SetIfTree(ifTree)
+
+	forBlock.Push(ifDecl)
+	forBlock.Push(ast.NewBreakNode(token.NewFileInfo(5, 1)))
+	forTree.Root = forBlock
+	forStmt.SetTree(forTree)
+
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	ln.Push(forStmt)
+	expected.Root = ln
+
+	parserTest("for", `for {
+	if $a == "1" {
+		continue
+	}
+
+	break
+}`, expected, t, true)
+}
+
+func TestParseBreakContinueOutsideLoop(t *testing.T) {
+	for _, code := range []string{
+		`break`,
+		`continue`,
+		`if $a == "1" { break }`,
+		`for { fn a() { break } }`,
+		`for {
+	fn a() {
+		continue
+	}
+}`,
+		`for { break echo }`,
+	} {
+		parserTestFail(t, code)
+	}
 }
 
 func TestParseVariableIndexing(t *testing.T) {
@@ -1550,6 +2265,71 @@ func TestParseVariableIndexing(t *testing.T) {
 }`, expected, t, true)
 }
 
+func TestParseVariableSlicing(t *testing.T) {
+	for _, test := range []struct {
+		code  string
+		index ast.Expr
+	}{
+		{
+			code:  `echo $values[-1]`,
+			index: ast.NewIntExpr(token.NewFileInfo(1, 13), -1),
+		},
+		{
+			code: `echo $values[1:3]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13),
+				ast.NewIntExpr(token.NewFileInfo(1, 13), 1),
+				ast.NewIntExpr(token.NewFileInfo(1, 15), 3)),
+		},
+		{
+			code: `echo $values[2:]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13),
+				ast.NewIntExpr(token.NewFileInfo(1, 13), 2), nil),
+		},
+		{
+			code: `echo $values[:-5]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13),
+				nil, ast.NewIntExpr(token.NewFileInfo(1, 14), -5)),
+		},
+		{
+			code: `echo $values[$a:$b]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13),
+				ast.NewVarExpr(token.NewFileInfo(1, 13), "$a"),
+				ast.NewVarExpr(token.NewFileInfo(1, 16), "$b")),
+		},
+		{
+			code: `echo $values[1:$b]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13),
+				ast.NewIntExpr(token.NewFileInfo(1, 13), 1),
+				ast.NewVarExpr(token.NewFileInfo(1, 15), "$b")),
+		},
+		{
+			code:  `echo $values[:]`,
+			index: ast.NewSliceExpr(token.NewFileInfo(1, 13), nil, nil),
+		},
+	} {
+		expected := ast.NewTree("variable slicing")
+		ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+		cmd := ast.NewCommandNode(token.NewFileInfo(1, 0), "echo", false)
+		cmd.AddArg(ast.NewIndexExpr(token.NewFileInfo(1, 5),
+			ast.NewVarExpr(token.NewFileInfo(1, 5), "$values"),
+			test.index))
+		ln.Push(cmd)
+		expected.Root = ln
+
+		parserTest("variable slicing", test.code, expected, t, true)
+	}
+
+	for _, code := range []string{
+		`echo $values[]`,
+		`echo $values[1:2:3]`,
+		`echo $values[1 2]`,
+		`echo $values[$a$b]`,
+		`echo $values[a:b]`,
+	} {
+		parserTestFail(t, code)
+	}
+}
+
 func TestParseMultilineCmdExec(t *testing.T) {
 	expected := ast.NewTree("parser simple")
 	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
@@ -1685,6 +2465,116 @@ func TestFnVariadic(t *testing.T) {
 }`, expected, t, true)
 }
 
+func TestParseFnDefaultArgs(t *testing.T) {
+	expected := ast.NewTree("fn")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+
+	fn := ast.NewFnDeclNode(token.NewFileInfo(1, 3), "deploy")
+	fn.AddArg(ast.NewFnArgNode(token.NewFileInfo(1, 10), "env", false))
+
+	region := ast.NewFnArgNode(token.NewFileInfo(1, 15), "region", false)
+	region.Default = ast.NewStringExpr(token.NewFileInfo(1, 25), "us-east-1", true)
+	fn.AddArg(region)
+
+	zones := ast.NewFnArgNode(token.NewFileInfo(1, 37), "zones", false)
+	zones.Default = ast.NewListExpr(token.NewFileInfo(1, 45), []ast.Expr{
+		ast.NewStringExpr(token.NewFileInfo(1, 46), "a", false),
+		ast.NewStringExpr(token.NewFileInfo(1, 48), "b", false),
+	})
+	fn.AddArg(zones)
+
+	fn.AddArg(ast.NewFnArgNode(token.NewFileInfo(1, 52), "tags", true))
+
+	tree := ast.NewTree("fn body")
+	tree.Root = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	fn.SetTree(tree)
+
+	ln.Push(fn)
+	expected.Root = ln
+
+	parserTest("fn", `fn deploy(env, region = "us-east-1", zones = (a b), tags...) {
+
+}`, expected, t, true)
+
+	for _, tc := range []string{
+		`fn deploy(env =) {}`,
+		`fn deploy(env = ) {}`,
+		`fn deploy(tags... = "a") {}`,
+	} {
+		parserTestFail(t, tc)
+	}
+}
+
+func TestParseFnNamedArgs(t *testing.T) {
+	expected := ast.NewTree("fn inv")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+
+	deploy := ast.NewFnInvNode(token.NewFileInfo(1, 0), "deploy")
+	deploy.AddArg(ast.NewStringExpr(token.NewFileInfo(1, 8), "prod", true))
+	deploy.AddNamedArg(ast.NewFnNamedArg(token.NewFileInfo(1, 15), "region",
+		ast.NewVarExpr(token.NewFileInfo(1, 24), "$region")))
+	deploy.AddNamedArg(ast.NewFnNamedArg(token.NewFileInfo(1, 33), "zones",
+		ast.NewListExpr(token.NewFileInfo(1, 41), []ast.Expr{
+			ast.NewStringExpr(token.NewFileInfo(1, 42), "a", false),
+		})))
+
+	ln.Push(deploy)
+	expected.Root = ln
+
+	parserTest("fn inv", `deploy("prod", region = $region, zones = (a))`, expected, t, true)
+
+	for _, tc := range []string{
+		`deploy(region = "eu", "prod")`,
+		`deploy(region = )`,
+		`deploy(region = $r...)`,
+	} {
+		parserTestFail(t, tc)
+	}
+}
+
+func TestParseFnTypes(t *testing.T) {
+	expected := ast.NewTree("fn")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+
+	fn := ast.NewFnDeclNode(token.NewFileInfo(1, 3), "get")
+
+	m := ast.NewFnArgNode(token.NewFileInfo(1, 7), "m", false)
+	m.ArgType = "map"
+	fn.AddArg(m)
+
+	key := ast.NewFnArgNode(token.NewFileInfo(1, 15), "key", false)
+	key.ArgType = "str"
+	key.Default = ast.NewStringExpr(token.NewFileInfo(1, 27), "a", true)
+	fn.AddArg(key)
+
+	tags := ast.NewFnArgNode(token.NewFileInfo(1, 31), "tags", true)
+	tags.ArgType = "str"
+	fn.AddArg(tags)
+
+	fn.SetReturnTypes([]string{"str", "int"})
+
+	tree := ast.NewTree("fn body")
+	tree.Root = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	fn.SetTree(tree)
+
+	ln.Push(fn)
+	expected.Root = ln
+
+	parserTest("fn", `fn get(m: map, key: str = "a", tags...: str): str, int {
+
+}`, expected, t, true)
+
+	for _, tc := range []string{
+		`fn get(m: dict) {}`,
+		`fn get(m:) {}`,
+		`fn get(m: map): {}`,
+		`fn get(m: map): str, {}`,
+		`fn get(m: map): string {}`,
+	} {
+		parserTestFail(t, tc)
+	}
+}
+
 func TestParseValidDotdotdot(t *testing.T) {
 	for _, tc := range []string{
 		// things that should not break
@@ -39,6 +39,12 @@ type (
 
 		openParens int
 
+		// map literals and [index] expressions are the only places
+		// where a variable can be followed by ':'.
+		openBrackets int
+		mapBraces    []bool // for each open brace, tells if it's a map
+		last         Token  // last emitted token
+
 		addSemicolon bool
 	}
 )
@@ -78,26 +84,30 @@ func (l *Lexer) run() {
 }
 
 func (l *Lexer) emitVal(t token.Token, val string, line, column int) {
-	l.Tokens <- Token{
+	l.last = Token{
 		FileInfo: token.NewFileInfo(line, column),
 
 		typ: t,
 		val: val,
 	}
 
+	l.Tokens <- l.last
+
 	l.start = l.pos
 	l.lineStart = l.line
 	l.columnStart = l.column
 }
 
 func (l *Lexer) emit(t token.Token) {
-	l.Tokens <- Token{
+	l.last = Token{
 		FileInfo: token.NewFileInfo(l.lineStart, l.columnStart),
 
 		typ: t,
 		val: l.input[l.start:l.pos],
 	}
 
+	l.Tokens <- l.last
+
 	l.start = l.pos
 	l.lineStart = l.line
 	l.columnStart = l.column
@@ -154,6 +164,25 @@ func (l *Lexer) backup() {
 	}
 }
 
+// isMapStart tells if a '{' read now starts a map literal instead of
+// a block. Maps are values, then they follow an assignment, a comma,
+// a '(' of function arguments, a return or the ':' of a map entry.
+func (l *Lexer) isMapStart() bool {
+	switch l.last.typ {
+	case token.Assign, token.Comma, token.LParen, token.Return:
+		return true
+	case token.Arg:
+		return l.last.val == ":"
+	}
+
+	return false
+}
+
+// inMap tells if the lexer is inside a map literal.
+func (l *Lexer) inMap() bool {
+	return len(l.mapBraces) > 0 && l.mapBraces[len(l.mapBraces)-1]
+}
+
 // acceptRun consumes a run of runes from the valid setup
 func (l *Lexer) acceptRun(valid string) {
 	for strings.IndexRune(valid, l.next()) >= 0 {
@@ -243,6 +272,7 @@ func lexStart(l *Lexer) stateFn {
 
 		return lexStart
 	case r == ';':
+		l.openBrackets = 0
 		l.emit(token.Semicolon)
 		return lexStart
 	case isSpace(r):
@@ -251,6 +281,9 @@ func lexStart(l *Lexer) stateFn {
 	case isEndOfLine(r):
 		l.ignore()
 
+		// index expressions can't span lines
+		l.openBrackets = 0
+
 		if l.addSemicolon && l.openParens == 0 {
 			l.emitVal(token.Semicolon, ";", l.line, l.column)
 		}
@@ -268,11 +301,34 @@ func lexStart(l *Lexer) stateFn {
 		l.emit(token.Plus)
 		return lexStart
 	case r == '>':
-		l.emit(token.Gt)
+		if l.peek() == '>' {
+			l.next()
+			l.emit(token.Append)
+		} else {
+			l.emit(token.Gt)
+		}
+
 		return lexStart
 	case r == '|':
+		if l.peek() == '|' {
+			l.next()
+			l.emit(token.Or)
+
+			// conditions can continue in the next line
+			l.addSemicolon = false
+			return lexStart
+		}
+
 		l.emit(token.Pipe)
 		return lexStart
+	case r == '&' && l.peek() == '&':
+		l.next()
+		l.emit(token.And)
+		l.addSemicolon = false
+		return lexStart
+	case r == '&' && l.isBackgroundEnd():
+		l.emit(token.Amp)
+		return lexStart
 	case r == '$':
 		r = l.next()
 
@@ -287,7 +343,9 @@ func lexStart(l *Lexer) stateFn {
 			!isEndOfLine(next) && next != ';' &&
 			next != ')' && next != ',' && next != '+' &&
 			next != '[' && next != ']' && next != '(' &&
-			next != '.' {
+			next != '.' &&
+			(next != ':' || (l.openBrackets == 0 && !l.inMap())) &&
+			(next != '}' || !l.inMap()) {
 			l.errorf("Unrecognized character in action: %#U", next)
 			return nil
 		}
@@ -316,23 +374,41 @@ func lexStart(l *Lexer) stateFn {
 		if l.peek() == '=' {
 			l.next()
 			l.emit(token.AssignCmd)
+		} else if l.peek() == '<' {
+			l.next()
+
+			if l.next() != '<' {
+				return l.errorf("Unexpected '<<', did you mean '<<<'?")
+			}
+
+			l.emit(token.HereStr)
 		} else {
 			l.emit(token.Lt)
 		}
 
 		return lexStart
 	case r == '{':
+		l.mapBraces = append(l.mapBraces, l.isMapStart())
 		l.addSemicolon = false
 		l.emit(token.LBrace)
 		return lexStart
 	case r == '}':
+		if len(l.mapBraces) > 0 {
+			l.mapBraces = l.mapBraces[:len(l.mapBraces)-1]
+		}
+
 		l.emit(token.RBrace)
 		l.addSemicolon = false
 		return lexStart
 	case r == '[':
+		l.openBrackets++
 		l.emit(token.LBrack)
 		return lexStart
 	case r == ']':
+		if l.openBrackets > 0 {
+			l.openBrackets--
+		}
+
 		l.emit(token.RBrack)
 		return lexStart
 	case r == '(':
@@ -406,7 +482,14 @@ func lexStart(l *Lexer) stateFn {
 				}
 			}
 			absorbArgument(l)
-			l.emit(token.Arg)
+
+			// module.fn( is the invocation of a function of a
+			// module
+			if l.peek() == '(' && isQualifiedIdent(l.input[l.start:l.pos]) {
+				l.emit(token.Ident)
+			} else {
+				l.emit(token.Arg)
+			}
 		} else {
 			absorbArgument(l)
 			l.emit(token.Arg)
@@ -578,6 +661,29 @@ func isArgument(r rune) bool {
 		r != ')' && r != '>' && r != '"' && r != ',' && r != ';' && r != '|')
 }
 
+// isQualifiedIdent reports whether lit is an identifier qualified
+// by a module name, as in module.name.
+func isQualifiedIdent(lit string) bool {
+	parts := strings.Split(lit, ".")
+	if len(parts) != 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+
+		for _, r := range part {
+			if !isIdentifier(r) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 func isIdentifier(r rune) bool {
 	return isAlpha(r) || r == '_'
 }
@@ -591,3 +697,18 @@ func isAlpha(r rune) bool {
 func isEndOfLine(r rune) bool {
 	return r == '\r' || r == '\n'
 }
+
+// isBackgroundEnd tells if the '&' just read sends the command to
+// background, what happens only if it's the last token of the
+// command. Otherwise the '&' is an argument, as in: echo a & b
+func (l *Lexer) isBackgroundEnd() bool {
+	for _, r := range l.input[l.pos:] {
+		if isSpace(r) {
+			continue
+		}
+
+		return isEndOfLine(r) || r == ';' || r == '}' || r == '#'
+	}
+
+	return true
+}
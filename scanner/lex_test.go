@@ -917,6 +917,132 @@ func TestLexerRedirectMultipleMaps(t *testing.T) {
 	testTable("test suppress stderr", `cmd >[1=2] >[2=]`, expected, t)
 }
 
+func TestLexerRedirectAppend(t *testing.T) {
+	expected := []Token{
+		{typ: token.Ident, val: "cmd"},
+		{typ: token.Append, val: ">>"},
+		{typ: token.LBrack, val: "["},
+		{typ: token.Number, val: "2"},
+		{typ: token.RBrack, val: "]"},
+		{typ: token.Arg, val: "/var/log/cmd.log"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test append redirect", `cmd >>[2] /var/log/cmd.log`, expected, t)
+
+	expected = []Token{
+		{typ: token.Ident, val: "cmd"},
+		{typ: token.Append, val: ">>"},
+		{typ: token.Ident, val: "log"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test append redirect", `cmd >> log`, expected, t)
+}
+
+func TestLexerBackground(t *testing.T) {
+	expected := []Token{
+		{typ: token.Ident, val: "sleep"},
+		{typ: token.Number, val: "10"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Ident, val: "curl"},
+		{typ: token.Arg, val: "http://a?b=1&c=2"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test background", `sleep 10 &
+curl http://a?b=1&c=2 &`, expected, t)
+
+	expected = []Token{
+		{typ: token.Var, val: "var"},
+		{typ: token.Ident, val: "job"},
+		{typ: token.AssignCmd, val: "<="},
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Arg, val: "&a"},
+		{typ: token.Pipe, val: "|"},
+		{typ: token.Ident, val: "cat"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test background", `var job <= echo &a | cat &`, expected, t)
+
+	expected = []Token{
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Ident, val: "a"},
+		{typ: token.Arg, val: "&"},
+		{typ: token.Ident, val: "b"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Ident, val: "sleep"},
+		{typ: token.Number, val: "1"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Ident, val: "true"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test mid-line &", `echo a & b
+sleep 1 &	
+true &`, expected, t)
+
+	expected = []Token{
+		{typ: token.If, val: "if"},
+		{typ: token.Variable, val: "$a"},
+		{typ: token.Equal, val: "=="},
+		{typ: token.String, val: "b"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Arg, val: "&"},
+		{typ: token.Arg, val: "&x"},
+		{typ: token.Amp, val: "&"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.EOF},
+	}
+
+	testTable("test & in block", `if $a == "b" { echo & &x & }`, expected, t)
+}
+
+func TestLexerRedirectInput(t *testing.T) {
+	expected := []Token{
+		{typ: token.Ident, val: "cat"},
+		{typ: token.Lt, val: "<"},
+		{typ: token.LBrack, val: "["},
+		{typ: token.Number, val: "0"},
+		{typ: token.RBrack, val: "]"},
+		{typ: token.Arg, val: "/tmp/file"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test input redirect", `cat <[0] /tmp/file`, expected, t)
+
+	expected = []Token{
+		{typ: token.Ident, val: "cat"},
+		{typ: token.HereStr, val: "<<<"},
+		{typ: token.Variable, val: "$data"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test here-string", `cat <<< $data`, expected, t)
+
+	expected = []Token{
+		{typ: token.Ident, val: "cat"},
+		{typ: token.Illegal, val: "test here-string:1:7: Unexpected '<<', did you mean '<<<'?"},
+		{typ: token.EOF},
+	}
+
+	testTable("test here-string", `cat << $data`, expected, t)
+}
+
 func TestLexerImport(t *testing.T) {
 	expected := []Token{
 		{typ: token.Import, val: "import"},
@@ -969,6 +1095,32 @@ func TestLexerSimpleIf(t *testing.T) {
         }`, expected, t)
 }
 
+func TestLexerIfBooleanOps(t *testing.T) {
+	expected := []Token{
+		{typ: token.If, val: "if"},
+		{typ: token.Arg, val: "!"},
+		{typ: token.LParen, val: "("},
+		{typ: token.Variable, val: "$a"},
+		{typ: token.Gt, val: ">"},
+		{typ: token.String, val: "1"},
+		{typ: token.RParen, val: ")"},
+		{typ: token.And, val: "&&"},
+		{typ: token.Variable, val: "$b"},
+		{typ: token.Lt, val: "<"},
+		{typ: token.String, val: "2"},
+		{typ: token.Or, val: "||"},
+		{typ: token.Variable, val: "$c"},
+		{typ: token.Equal, val: "=="},
+		{typ: token.String, val: "3"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.EOF},
+	}
+
+	testTable("test if boolean ops", `if !($a > "1") && $b < "2" ||
+	$c == "3" {}`, expected, t)
+}
+
 func TestLexerIfWithConcat(t *testing.T) {
 	expected := []Token{
 		{typ: token.If, val: "if"},
@@ -1523,6 +1675,31 @@ func TestLexerReturn(t *testing.T) {
 }`, expected, t)
 }
 
+func TestLexerBreakContinue(t *testing.T) {
+	expected := []Token{
+		{typ: token.For, val: "for"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.Break, val: "break"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.EOF},
+	}
+
+	testTable("test break", `for { break }`, expected, t)
+
+	expected = []Token{
+		{typ: token.For, val: "for"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.Continue, val: "continue"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.EOF},
+	}
+
+	testTable("test continue", `for {
+	continue
+}`, expected, t)
+}
+
 func TestLexerFor(t *testing.T) {
 	expected := []Token{
 		{typ: token.For, val: "for"},
@@ -1709,6 +1886,61 @@ func TestLexerListIndexing(t *testing.T) {
 	testTable("test if with indexing", `if $crazies[0] == "patito" { echo ":D" }`, expected, t)
 }
 
+func TestLexerVariableColon(t *testing.T) {
+	expected := []Token{
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Illegal, val: "test colon:1:7: Unrecognized character in action: U+003A ':'"},
+		{typ: token.EOF},
+	}
+
+	testTable("test colon", `echo $p:/x`, expected, t)
+
+	expected = []Token{
+		{typ: token.If, val: "if"},
+		{typ: token.Variable, val: "$a"},
+		{typ: token.Equal, val: "=="},
+		{typ: token.Variable, val: "$b"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Illegal, val: "test colon in block:1:21: Unrecognized character in action: U+003A ':'"},
+		{typ: token.EOF},
+	}
+
+	testTable("test colon in block", `if $a == $b { echo $a:b }`, expected, t)
+
+	expected = []Token{
+		{typ: token.Ident, val: "echo"},
+		{typ: token.Variable, val: "$l"},
+		{typ: token.LBrack, val: "["},
+		{typ: token.Variable, val: "$a"},
+		{typ: token.Arg, val: ":"},
+		{typ: token.Variable, val: "$b"},
+		{typ: token.RBrack, val: "]"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test colon in slice", `echo $l[$a:$b]`, expected, t)
+
+	expected = []Token{
+		{typ: token.Var, val: "var"},
+		{typ: token.Ident, val: "m"},
+		{typ: token.Assign, val: "="},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.String, val: "a"},
+		{typ: token.Arg, val: ":"},
+		{typ: token.LBrace, val: "{"},
+		{typ: token.Variable, val: "$k"},
+		{typ: token.Arg, val: ":"},
+		{typ: token.Variable, val: "$v"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.RBrace, val: "}"},
+		{typ: token.EOF},
+	}
+
+	testTable("test colon in map", `var m = {"a": {$k: $v}}`, expected, t)
+}
+
 func TestLexerMultilineCmdExecution(t *testing.T) {
 	expected := []Token{
 		{typ: token.LParen, val: "("},
@@ -1918,3 +2150,30 @@ func TestLexerVar(t *testing.T) {
 
 	testTable("test simple var decl", `var a = "hello world"`, expected, t)
 }
+
+func TestLexerQualifiedFnInv(t *testing.T) {
+	expected := []Token{
+		{typ: token.Import, val: "import"},
+		{typ: token.String, val: "map"},
+		{typ: token.Ident, val: "as"},
+		{typ: token.Ident, val: "m"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Ident, val: "m.get"},
+		{typ: token.LParen, val: "("},
+		{typ: token.Variable, val: "$a"},
+		{typ: token.RParen, val: ")"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Arg, val: "./script.sh"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.Arg, val: "a.b.c"},
+		{typ: token.LParen, val: "("},
+		{typ: token.RParen, val: ")"},
+		{typ: token.Semicolon, val: ";"},
+		{typ: token.EOF},
+	}
+
+	testTable("test qualified fn inv", `import "map" as m
+m.get($a)
+./script.sh
+a.b.c()`, expected, t)
+}
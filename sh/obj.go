@@ -1,12 +1,18 @@
 package sh
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
 
 //go:generate stringer -type=objType
 const (
 	StringType objType = iota + 1
 	FnType
 	ListType
+	MapType
+	IntType
 )
 
 type (
@@ -32,9 +38,24 @@ type (
 		runes []rune
 	}
 
-	Collection interface {
+	IntObj struct {
+		objType
+		val int
+	}
+
+	MapObj struct {
+		objType
+		m map[string]Obj
+	}
+
+	Sizer interface {
 		Len() int
+	}
+
+	Collection interface {
+		Sizer
 		Get(index int) (Obj, error)
+		Slice(start, end int) (Obj, error)
 	}
 
 	WriteableCollection interface {
@@ -53,6 +74,17 @@ func NewCollection(o Obj) (Collection, error) {
 	return sizer, nil
 }
 
+func NewSizer(o Obj) (Sizer, error) {
+	sizer, ok := o.(Sizer)
+	if !ok {
+		return nil, fmt.Errorf(
+			"SizeError: trying to get size from type %s which is not a collection",
+			o.Type(),
+		)
+	}
+	return sizer, nil
+}
+
 func NewWriteableCollection(o Obj) (WriteableCollection, error) {
 	indexer, ok := o.(WriteableCollection)
 	if !ok {
@@ -64,6 +96,19 @@ func NewWriteableCollection(o Obj) (WriteableCollection, error) {
 	return indexer, nil
 }
 
+// realIndex converts negative indexes, relative to the end of a
+// collection of the given size, into absolute ones.
+func realIndex(index, size int) int {
+	if index < 0 {
+		return index + size
+	}
+	return index
+}
+
+func sliceErr(start, end int) error {
+	return fmt.Errorf("IndexError: invalid slice %d:%d", start, end)
+}
+
 func (o objType) Type() objType {
 	return o
 }
@@ -80,21 +125,52 @@ func (o *StrObj) Str() string { return string(o.runes) }
 func (o *StrObj) String() string { return o.Str() }
 
 func (o *StrObj) Get(index int) (Obj, error) {
-	if index >= o.Len() {
-		return nil, fmt.Errorf(
-			"IndexError: Index[%d] out of range, string size[%d]",
-			index,
-			o.Len(),
-		)
+	i := realIndex(index, o.Len())
+	if i < 0 || i >= o.Len() {
+		return nil, o.indexErr(index)
 	}
 
-	return NewStrObj(string(o.runes[index])), nil
+	return NewStrObj(string(o.runes[i])), nil
+}
+
+func (o *StrObj) Slice(start, end int) (Obj, error) {
+	s, e := realIndex(start, o.Len()), realIndex(end, o.Len())
+	if s < 0 || s > o.Len() {
+		return nil, o.indexErr(start)
+	}
+	if e < 0 || e > o.Len() {
+		return nil, o.indexErr(end)
+	}
+	if e < s {
+		return nil, sliceErr(start, end)
+	}
+
+	return NewStrObj(string(o.runes[s:e])), nil
+}
+
+func (o *StrObj) indexErr(index int) error {
+	return fmt.Errorf(
+		"IndexError: Index[%d] out of range, string size[%d]",
+		index,
+		o.Len(),
+	)
 }
 
 func (o *StrObj) Len() int {
 	return len(o.runes)
 }
 
+func NewIntObj(val int) *IntObj {
+	return &IntObj{
+		val:     val,
+		objType: IntType,
+	}
+}
+
+func (o *IntObj) Int() int { return o.val }
+
+func (o *IntObj) String() string { return strconv.Itoa(o.val) }
+
 func NewFnObj(val FnDef) *FnObj {
 	return &FnObj{
 		fn:      val,
@@ -118,26 +194,49 @@ func (o *ListObj) Len() int {
 }
 
 func (o *ListObj) Set(index int, value Obj) error {
-	if index >= len(o.list) {
+	i := realIndex(index, len(o.list))
+	if i < 0 || i >= len(o.list) {
 		return fmt.Errorf(
 			"IndexError: Index[%d] out of range, list size[%d]",
 			index,
 			len(o.list),
 		)
 	}
-	o.list[index] = value
+	o.list[i] = value
 	return nil
 }
 
 func (o *ListObj) Get(index int) (Obj, error) {
-	if index >= len(o.list) {
-		return nil, fmt.Errorf(
-			"IndexError: Index out of bounds, index[%d] but list size[%d]",
-			index,
-			len(o.list),
-		)
+	i := realIndex(index, len(o.list))
+	if i < 0 || i >= len(o.list) {
+		return nil, o.indexErr(index)
 	}
-	return o.list[index], nil
+	return o.list[i], nil
+}
+
+func (o *ListObj) Slice(start, end int) (Obj, error) {
+	s, e := realIndex(start, len(o.list)), realIndex(end, len(o.list))
+	if s < 0 || s > len(o.list) {
+		return nil, o.indexErr(start)
+	}
+	if e < 0 || e > len(o.list) {
+		return nil, o.indexErr(end)
+	}
+	if e < s {
+		return nil, sliceErr(start, end)
+	}
+
+	list := make([]Obj, e-s)
+	copy(list, o.list[s:e])
+	return NewListObj(list), nil
+}
+
+func (o *ListObj) indexErr(index int) error {
+	return fmt.Errorf(
+		"IndexError: Index out of bounds, index[%d] but list size[%d]",
+		index,
+		len(o.list),
+	)
 }
 
 func (o *ListObj) List() []Obj { return o.list }
@@ -157,3 +256,57 @@ func (o *ListObj) String() string {
 
 	return result
 }
+
+func NewMapObj(val map[string]Obj) *MapObj {
+	if val == nil {
+		val = make(map[string]Obj)
+	}
+
+	return &MapObj{
+		m:       val,
+		objType: MapType,
+	}
+}
+
+func (o *MapObj) Len() int {
+	return len(o.m)
+}
+
+func (o *MapObj) Get(key string) (Obj, error) {
+	val, ok := o.m[key]
+	if !ok {
+		return nil, fmt.Errorf("KeyError: key %q not found", key)
+	}
+	return val, nil
+}
+
+func (o *MapObj) Set(key string, value Obj) {
+	o.m[key] = value
+}
+
+// Keys returns the map keys in sorted order, so iteration over
+// maps is deterministic.
+func (o *MapObj) Keys() []string {
+	keys := make([]string, 0, len(o.m))
+	for k := range o.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (o *MapObj) Map() map[string]Obj { return o.m }
+
+func (o *MapObj) String() string {
+	result := "{"
+	keys := o.Keys()
+	for i, k := range keys {
+		result += k + ": " + o.m[k].String()
+
+		if i < len(keys)-1 {
+			result += ", "
+		}
+	}
+
+	return result + "}"
+}
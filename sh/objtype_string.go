@@ -4,9 +4,9 @@ package sh
 
 import "fmt"
 
-const _objType_name = "StringTypeFnTypeListType"
+const _objType_name = "StringTypeFnTypeListTypeMapTypeIntType"
 
-var _objType_index = [...]uint8{0, 10, 16, 24}
+var _objType_index = [...]uint8{0, 10, 16, 24, 31, 38}
 
 func (i objType) String() string {
 	i -= 1
@@ -1,6 +1,10 @@
 package sh
 
-import "io"
+import (
+	"io"
+
+	"github.com/madlambda/nash/ast"
+)
 
 type (
 	Runner interface {
@@ -15,6 +19,7 @@ type (
 		SetStderr(io.Writer)
 
 		StdoutPipe() (io.ReadCloser, error)
+		StderrPipe() (io.ReadCloser, error)
 
 		Stdin() io.Reader
 		Stdout() io.Writer
@@ -24,6 +29,8 @@ type (
 	FnArg struct {
 		Name       string
 		IsVariadic bool
+		Default    ast.Expr // value of optional arguments
+		Type       string   // type annotation, eg.: str, list
 	}
 
 	Fn interface {
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/madlambda/nash/tests/internal/tester"
+)
+
+func TestMap(t *testing.T) {
+	tester.Run(t, Nashcmd,
+		tester.TestCase{
+			Name: "KeyAccess",
+			ScriptCode: `
+				var m = {"a": "1", "b": ("2" "3")}
+				echo $m["a"]
+				echo $m["b"]
+			`,
+			ExpectStdout: "1\n2 3\n",
+		},
+		tester.TestCase{
+			Name: "KeyAccessWithVar",
+			ScriptCode: `
+				var k = "b"
+				var m = {"a": "1", $k: "2"}
+				echo $m[$k]
+			`,
+			ExpectStdout: "2\n",
+		},
+		tester.TestCase{
+			Name: "KeyAssignment",
+			ScriptCode: `
+				var m = {}
+				m["a"] = "1"
+				m["a"] = "2"
+				var k = "b"
+				m[$k] = "3"
+				echo $m["a"] $m["b"]
+			`,
+			ExpectStdout: "2 3\n",
+		},
+		tester.TestCase{
+			Name: "MultiLineLiteral",
+			ScriptCode: `
+				var m = {
+					"a": "1",
+					"b": "2",
+				}
+				echo $m["b"]
+			`,
+			ExpectStdout: "2\n",
+		},
+		tester.TestCase{
+			Name: "Len",
+			ScriptCode: `
+				var m = {"a": "1", "b": "2"}
+				var l <= len($m)
+				echo $l
+			`,
+			ExpectStdout: "2\n",
+		},
+		tester.TestCase{
+			Name: "IterateKeys",
+			ScriptCode: `
+				var m = {"b": "2", "a": "1", "c": "3"}
+				for k in $m {
+					echo $k
+				}
+			`,
+			ExpectStdout: "a\nb\nc\n",
+		},
+		tester.TestCase{
+			Name: "IterateKeysAndValues",
+			ScriptCode: `
+				var m = {"b": "2", "a": "1"}
+				for k, v in $m {
+					echo $k $v
+				}
+			`,
+			ExpectStdout: "a 1\nb 2\n",
+		},
+		tester.TestCase{
+			Name: "IterateEmpty",
+			ScriptCode: `
+				var m = {}
+				for k in $m {
+					exit("1")
+				}
+				echo "ok"
+			`,
+			ExpectStdout: "ok\n",
+		},
+		tester.TestCase{
+			Name: "MissingKeyFails",
+			ScriptCode: `
+				var m = {"a": "1"}
+				echo $m["b"]
+			`,
+			Fails:                 true,
+			ExpectStderrToContain: "KeyError",
+		},
+		tester.TestCase{
+			Name: "NonStringKeyFails",
+			ScriptCode: `
+				var k = ("a")
+				var m = {$k: "1"}
+			`,
+			Fails:                 true,
+			ExpectStderrToContain: "Invalid object type on map key",
+		},
+		tester.TestCase{
+			Name: "KeyValueIterationOnList",
+			ScriptCode: `
+				var l = ("a" "b")
+				for k, v in $l {
+					echo $k $v
+				}
+			`,
+			ExpectStdout: "0 a\n1 b\n",
+		},
+	)
+}
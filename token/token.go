@@ -33,7 +33,12 @@ const (
 	Plus      // +
 	Minus     // -
 	Gt        // >
+	Append    // >>
 	Lt        // <
+	HereStr   // <<<
+	And       // &&
+	Or        // ||
+	Amp       // &
 
 	Colon     // ,
 	Semicolon // ;
@@ -61,12 +66,17 @@ const (
 	BindFn // "bindfn <fn> <cmd>
 	Dump   // "dump" [ file ]
 	Return
+	Break
+	Continue
 	If
 	Else
 	For
 	Rfork
 	Fn
 	Var
+	Spawn
+	Defer
+	Switch
 
 	keyword_end
 )
@@ -88,7 +98,12 @@ var tokens = [...]string{
 	Plus:      "+",
 	Minus:     "-",
 	Gt:        ">",
+	Append:    ">>",
 	Lt:        "<",
+	HereStr:   "<<<",
+	And:       "&&",
+	Or:        "||",
+	Amp:       "&",
 
 	Colon:     ",",
 	Semicolon: ";",
@@ -106,18 +121,23 @@ var tokens = [...]string{
 
 	Variable: "VARIABLE",
 
-	Import:  "import",
-	SetEnv:  "setenv",
-	ShowEnv: "showenv",
-	BindFn:  "bindfn",
-	Dump:    "dump",
-	Return:  "return",
-	If:      "if",
-	Else:    "else",
-	For:     "for",
-	Rfork:   "rfork",
-	Fn:      "fn",
-	Var:     "var",
+	Import:   "import",
+	SetEnv:   "setenv",
+	ShowEnv:  "showenv",
+	BindFn:   "bindfn",
+	Dump:     "dump",
+	Return:   "return",
+	Break:    "break",
+	Continue: "continue",
+	If:       "if",
+	Else:     "else",
+	For:      "for",
+	Rfork:    "rfork",
+	Fn:       "fn",
+	Var:      "var",
+	Spawn:    "spawn",
+	Defer:    "defer",
+	Switch:   "switch",
 }
 
 var keywords map[string]Token